@@ -3,12 +3,14 @@ package assets
 import (
 	"errors"
 	"fmt"
-	"io"
+	"main/api/jobs"
+	"main/business"
 	"main/model"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type Handler struct {
@@ -22,52 +24,61 @@ func NewHandler(AssetService Service) *Handler {
 func (Handler *Handler) UploadAsset(context *gin.Context) {
 	var input model.DetailUploadInfor
 
-	// For multipart/form-data, you must bind the form fields, not JSON.
-	// The `form` tags in your struct will be used here.
-	if err := context.ShouldBind(&input); err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form data: " + err.Error(), "success": false})
+	// Each request gets its own workspace so concurrent uploads never share files.
+	workspace, err := business.NewWorkspace("upload")
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
 		return
 	}
+	// Once the job is queued the workspace belongs to it and is removed when the job finishes.
+	queued := false
+	defer func() {
+		if !queued {
+			workspace.Cleanup()
+		}
+	}()
 
-	// Retrieve the uploaded file from the form-data
-	fileHeader, err := context.FormFile("file") // "file" is the field name from the frontend
-	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "File is required: " + err.Error(), "success": false})
+	// Stream the multipart body: the file part goes straight to disk, never into memory.
+	if err := receiveMultipartUpload(context, workspace, &input); err != nil {
+		context.JSON(uploadErrorStatus(err), UploadErrorBody(err))
 		return
 	}
 
-	// The Filename field of fileHeader contains the original filename, including the extension.
-	// e.g., "my-awesome-model.glb". This is where you populate your struct's Filename.
-	input.Filename = fileHeader.Filename
-
-	// Open the file to read its contents
-	file, err := fileHeader.Open()
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to open uploaded file: " + err.Error(), "success": false})
+	// For multipart/form-data, you must bind the form fields, not JSON.
+	// The `form` tags in your struct will be used here.
+	if err := context.ShouldBindWith(&input, binding.FormMultipart); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form data: " + err.Error(), "success": false})
 		return
 	}
-	defer file.Close()
-
-	// Read the file content into a byte buffer to populate your struct's FileBuffer
-	buffer, err := io.ReadAll(file)
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to read file content: " + err.Error(), "success": false})
+	if input.EncodingProfile != "" {
+		if _, err := business.LookupKTX2Profile(input.EncodingProfile); err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+			return
+		}
+	}
+	if err := business.ValidateNormalizeMode(input.NormalizeMode); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err := business.ValidateWebPMode(input.WebPMode); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
 		return
 	}
-	input.FileBuffer = buffer
 
-	// Now the 'input' struct is fully populated and can be passed to the service layer.
-	response, err := Handler.AssetService.UploadAsset(context.Request.Context(), input)
+	// Conversion and pinning run on the job worker pool, the client follows GET /jobs/:id
+	// or the job_id carried by the progress events.
+	job, err := Handler.AssetService.EnqueueUpload(context.Request.Context(), input, workspace)
 	if err != nil {
-		if errors.Is(err, ErrorAssetExist) {
-			context.JSON(http.StatusConflict, gin.H{"error": err.Error(), "success": false})
+		if errors.Is(err, jobs.ErrorQueueFull) {
+			context.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "success": false})
 			return
 		}
 		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
 		return
 	}
+	queued = true
 
-	context.JSON(http.StatusOK, response)
+	context.JSON(http.StatusAccepted, gin.H{"success": true, "job_id": job.JobID, "status": job.Status, "message": "Upload queued"})
 }
 
 func (Handler *Handler) GetAsset(context *gin.Context) {
@@ -2,14 +2,17 @@ package assets
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"main/business"
 	"main/model"
+	"slices"
 	"sync"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository interface {
@@ -17,8 +20,17 @@ type Repository interface {
 	GetAsset(ctx context.Context, RoomID int) ([]model.ResponseMetadataInfor, error)
 	InsertAudio(ctx context.Context, assetCID, language, description string) (*model.Audio, error)
 	FindAudioByHash(ctx context.Context, textHash string, language string) (*model.Audio, error)
-	UpdateAudio(ctx context.Context, assetCID string, language string, status, audioCID string, attempts int) error
+	UpdateAudio(ctx context.Context, assetCID string, language string, textHash string, status, audioCID string, attempts int) error
 	FetchPendingAudioJobs(ctx context.Context, limit int) ([]model.Audio, error)
+	GetEncodingDefaults(ctx context.Context, roomID int, categoryID int) (roomProfile string, categoryProfile string, err error)
+	SaveRenditions(ctx context.Context, assetCID string, renditions []model.AssetRendition) error
+	GetLatestAsset(ctx context.Context, roomID int, meshName string) (*model.Asset, error)
+	FindAssetBySource(ctx context.Context, sourceKey string) (*model.Asset, error)
+	FindBlob(ctx context.Context, sha256 string, driver string) (*model.Blob, error)
+	SaveBlob(ctx context.Context, blob model.Blob) error
+	// ClaimBlobs marks the blobs of cids, and the renditions of those that are asset CIDs, as used
+	// just now, so retention leaves them alone until the upload reusing them has saved its asset.
+	ClaimBlobs(ctx context.Context, cids []string) error
 }
 
 type AssetRepo struct {
@@ -67,7 +79,7 @@ func (repo *AssetRepo) UpsertAsset(ctx context.Context, ktx2Resp model.AssetStru
 		return fmt.Errorf("database error during version check: %w", err)
 	}
 
-	fileSize := int64(len(info.FileBuffer))
+	fileSize := info.Filesize
 
 	// 3. Decide whether to create a new row or stop (The core logic change)
 	// We only create a new version (new row) if the CIDs have changed.
@@ -85,9 +97,25 @@ func (repo *AssetRepo) UpsertAsset(ctx context.Context, ktx2Resp model.AssetStru
 			"title":                  info.Title,
 			"vietnamese_description": info.VietnameseDescription,
 			"english_description":    info.EnglishDescription,
+			"encoding_profile":       info.EncodingProfile,
+			"normalization":          info.Normalization,
+			"hls_cid":                info.HLSCID,
+			"duration_seconds":       info.Duration,
+			"width":                  info.Width,
+			"height":                 info.Height,
 			"filesize":               fileSize,
+			"source_key":             info.SourceKey,
 			"updated_at":             time.Now(),
 		}
+		// Map updates bypass the json serializer of the column
+		if info.TileManifest != nil {
+			manifest, err := json.Marshal(info.TileManifest)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to encode tile manifest: %w", err)
+			}
+			updates["tile_manifest"] = string(manifest)
+		}
 
 		// LOGIC TO FIX MISSING WebP CID:
 		// Update the webp_cid if:
@@ -118,6 +146,14 @@ func (repo *AssetRepo) UpsertAsset(ctx context.Context, ktx2Resp model.AssetStru
 			Title:                 info.Title,
 			VietnameseDescription: info.VietnameseDescription,
 			EnglishDescription:    info.EnglishDescription,
+			EncodingProfile:       info.EncodingProfile,
+			Normalization:         info.Normalization,
+			HLSCID:                info.HLSCID,
+			Duration:              info.Duration,
+			Width:                 info.Width,
+			Height:                info.Height,
+			TileManifest:          info.TileManifest,
+			SourceKey:             info.SourceKey,
 			RoomID:                uint(info.RoomID),
 			Filesize:              fileSize,
 			CategoryID:            uint(ktx2Resp.CategoryID),
@@ -136,6 +172,71 @@ func (repo *AssetRepo) UpsertAsset(ctx context.Context, ktx2Resp model.AssetStru
 	return nil
 }
 
+// GetLatestAsset returns the latest version of a mesh in a room, nil when it was never uploaded.
+func (repo *AssetRepo) GetLatestAsset(ctx context.Context, roomID int, meshName string) (*model.Asset, error) {
+	var latest model.Asset
+	err := repo.database.WithContext(ctx).
+		Where("asset_mesh_name = ? AND room_id = ?", meshName, roomID).
+		Order("version DESC").
+		First(&latest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &latest, nil
+}
+
+// FindAssetBySource returns the latest asset converted from the same upload with the same options,
+// in any room and mesh, nil when there is none.
+func (repo *AssetRepo) FindAssetBySource(ctx context.Context, sourceKey string) (*model.Asset, error) {
+	var asset model.Asset
+	err := repo.database.WithContext(ctx).
+		Where("source_key = ?", sourceKey).
+		Order("updated_at DESC").
+		First(&asset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+// FindBlob looks up stored content by its SHA-256, nil when the driver has not stored it yet.
+func (repo *AssetRepo) FindBlob(ctx context.Context, sha256 string, driver string) (*model.Blob, error) {
+	var blob model.Blob
+	err := repo.database.WithContext(ctx).Where("sha256 = ? AND driver = ?", sha256, driver).First(&blob).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// SaveBlob adds content to the blob index, keeping the first CID when two uploads race.
+func (repo *AssetRepo) SaveBlob(ctx context.Context, blob model.Blob) error {
+	return repo.database.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sha256"}, {Name: "driver"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_used_at"}),
+	}).Create(&blob).Error
+}
+
+func (repo *AssetRepo) ClaimBlobs(ctx context.Context, cids []string) error {
+	cids = slices.DeleteFunc(slices.Clone(cids), func(cid string) bool { return cid == "" })
+	if len(cids) == 0 {
+		return nil
+	}
+	renditions := repo.database.Model(&model.AssetRendition{}).Select("cid").Where("asset_cid IN ?", cids)
+	return repo.database.WithContext(ctx).Model(&model.Blob{}).
+		Where("cid IN ? OR cid IN (?)", cids, renditions).
+		Update("last_used_at", time.Now()).Error
+}
+
 func (Repository *AssetRepo) GetAsset(ctx context.Context, RoomID int) ([]model.ResponseMetadataInfor, error) {
 
 	// 1. check cache
@@ -168,6 +269,11 @@ func (Repository *AssetRepo) GetAsset(ctx context.Context, RoomID int) ([]model.
 			a.title,
 			a.vietnamese_description AS viet_des,
 			a.english_description AS en_des,
+			a.hls_cid,
+			a.duration_seconds,
+			a.width,
+			a.height,
+			a.tile_manifest,
 			va.audio_cid AS viet_audio_cid,
 			ea.audio_cid AS eng_audio_cid
 			FROM filtered_assets AS a
@@ -176,6 +282,7 @@ func (Repository *AssetRepo) GetAsset(ctx context.Context, RoomID int) ([]model.
 			FROM audios au
 			WHERE au.asset_cid = a.asset_cid
 				AND au.language = 'vi'
+				AND au.text_hash = encode(sha256(convert_to(a.vietnamese_description, 'UTF8')), 'hex')
 				AND au.status = 'completed'
 			ORDER BY au.created_at DESC
 			LIMIT 1
@@ -185,6 +292,7 @@ func (Repository *AssetRepo) GetAsset(ctx context.Context, RoomID int) ([]model.
 			FROM audios au2
 			WHERE au2.asset_cid = a.asset_cid
 				AND au2.language = 'en'
+				AND au2.text_hash = encode(sha256(convert_to(a.english_description, 'UTF8')), 'hex')
 				AND au2.status = 'completed'
 			ORDER BY au2.created_at DESC
 			LIMIT 1
@@ -201,6 +309,11 @@ func (Repository *AssetRepo) GetAsset(ctx context.Context, RoomID int) ([]model.
 		return []model.ResponseMetadataInfor{}, nil
 	}
 
+	// 2. attach the rendition ladders
+	if err := Repository.attachRenditions(ctx, Assets); err != nil {
+		return nil, err
+	}
+
 	// 3. save in cache for reuse
 	latestAssetsCacheMu.Lock()
 	latestAssetsCache[room_id] = cachedResult{
@@ -211,11 +324,13 @@ func (Repository *AssetRepo) GetAsset(ctx context.Context, RoomID int) ([]model.
 	return Assets, nil
 }
 
+// InsertAudio records the narration of description for an asset. Meshes sharing an asset CID
+// keep their own narration, the text hash tells them apart.
 func (repo *AssetRepo) InsertAudio(ctx context.Context, assetCID, language, description string) (*model.Audio, error) {
 	textHash := business.HashTextSHA256(description)
 	var existing model.Audio
 
-	err := repo.database.WithContext(ctx).Where("asset_cid = ? AND language = ?", assetCID, language).First(&existing).Error
+	err := repo.database.WithContext(ctx).Where("asset_cid = ? AND language = ? AND text_hash = ?", assetCID, language, textHash).First(&existing).Error
 	// Case query execute succesfully without error
 	if err == nil {
 		return &existing, nil
@@ -247,10 +362,10 @@ func (repo *AssetRepo) FindAudioByHash(ctx context.Context, textHash string, lan
 	return &tuple, nil
 }
 
-func (repo *AssetRepo) UpdateAudio(ctx context.Context, assetCID string, language string, status, audioCID string, attempts int) error {
+func (repo *AssetRepo) UpdateAudio(ctx context.Context, assetCID string, language string, textHash string, status, audioCID string, attempts int) error {
 	changes := map[string]interface{}{
 		"status":     status,
-		"attempts":    attempts,
+		"attempts":   attempts,
 		"updated_at": time.Now(),
 	}
 
@@ -258,7 +373,7 @@ func (repo *AssetRepo) UpdateAudio(ctx context.Context, assetCID string, languag
 		changes["audio_cid"] = audioCID
 	}
 	return repo.database.WithContext(ctx).Model(&model.Audio{}).
-		Where("asset_cid = ? AND language = ?", assetCID, language).
+		Where("asset_cid = ? AND language = ? AND text_hash = ?", assetCID, language, textHash).
 		Updates(changes).Error
 }
 
@@ -268,3 +383,72 @@ func (repo *AssetRepo) FetchPendingAudioJobs(ctx context.Context, limit int) ([]
 	err := repo.database.WithContext(ctx).Where("status = ?", "pending").Limit(limit).Find(&jobs).Error
 	return jobs, err
 }
+
+// GetEncodingDefaults returns the default KTX2 profiles configured on the room and the category.
+// Either may be empty when nothing is configured.
+func (repo *AssetRepo) GetEncodingDefaults(ctx context.Context, roomID int, categoryID int) (string, string, error) {
+	var room model.Room
+	err := repo.database.WithContext(ctx).Select("default_encoding_profile").Where("room_id = ?", roomID).First(&room).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", "", err
+	}
+
+	var category model.Category
+	err = repo.database.WithContext(ctx).Select("default_encoding_profile").Where("category_id = ?", categoryID).First(&category).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", "", err
+	}
+	return room.DefaultEncodingProfile, category.DefaultEncodingProfile, nil
+}
+
+// SaveRenditions replaces the renditions stored for an asset with the ones just pinned.
+func (repo *AssetRepo) SaveRenditions(ctx context.Context, assetCID string, renditions []model.AssetRendition) error {
+	if len(renditions) == 0 {
+		return nil
+	}
+	kinds := make([]string, 0, len(renditions))
+	for i := range renditions {
+		renditions[i].AssetCID = assetCID
+		kinds = append(kinds, renditions[i].Kind)
+	}
+
+	return repo.database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("asset_cid = ? AND kind IN ?", assetCID, kinds).Delete(&model.AssetRendition{}).Error; err != nil {
+			return fmt.Errorf("failed to clear renditions: %w", err)
+		}
+		if err := tx.Create(&renditions).Error; err != nil {
+			return fmt.Errorf("failed to insert renditions: %w", err)
+		}
+		return nil
+	})
+}
+
+// attachRenditions loads the renditions of all listed assets in one query, smallest first.
+// HLS segments are only reachable through their playlists and are left out.
+func (repo *AssetRepo) attachRenditions(ctx context.Context, assets []model.ResponseMetadataInfor) error {
+	cids := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		cids = append(cids, asset.AssetCID)
+	}
+
+	var renditions []model.AssetRendition
+	err := repo.database.WithContext(ctx).
+		Where("asset_cid IN ? AND kind <> ?", cids, model.RenditionSegment).
+		Order("kind, width, height, label").
+		Find(&renditions).Error
+	if err != nil {
+		return fmt.Errorf("failed to load renditions: %w", err)
+	}
+
+	byAsset := make(map[string][]model.AssetRendition)
+	for _, rendition := range renditions {
+		byAsset[rendition.AssetCID] = append(byAsset[rendition.AssetCID], rendition)
+	}
+	for i := range assets {
+		assets[i].Renditions = byAsset[assets[i].AssetCID]
+		if assets[i].Renditions == nil {
+			assets[i].Renditions = []model.AssetRendition{}
+		}
+	}
+	return nil
+}
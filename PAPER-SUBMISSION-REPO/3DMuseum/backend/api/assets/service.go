@@ -1,14 +1,21 @@
 package assets
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"main/api/jobs"
 	"main/business"
 	"main/model"
 	"main/websocket"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,101 +25,299 @@ var (
 )
 
 type UploadResult struct {
-	Success  bool   `json:"success"`
-	AssetCID string `json:"asset_cid"`
-	WebpCID  string `json:"webp_cid,omitempty"`
-	Message  string `json:"message,omitempty"`
+	Success         bool   `json:"success"`
+	AssetCID        string `json:"asset_cid"`
+	WebpCID         string `json:"webp_cid,omitempty"`
+	EncodingProfile string `json:"encoding_profile,omitempty"`
+	// Normalization lists what was changed on an image before it was encoded
+	Normalization *business.NormalizationReport     `json:"normalization,omitempty"`
+	Renditions    []model.AssetRendition            `json:"renditions,omitempty"`
+	Model         *business.ModelOptimizationReport `json:"model,omitempty"` // uploaded glTF/GLB vs. the optimized GLB that was pinned
+	HLSCID        string                            `json:"hls_cid,omitempty"`
+	TileManifest  *model.TileManifest               `json:"tile_manifest,omitempty"`
+	// CIDMismatch is set when the CID the storage returned differs from the one computed locally
+	CIDMismatch bool `json:"cid_mismatch,omitempty"`
+	// Unchanged is set when the file was already the latest version and only the metadata was updated
+	Unchanged bool `json:"unchanged,omitempty"`
+	// Deduplicated is set when identical content was stored before and its CIDs were reused
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	Message      string `json:"message,omitempty"`
 }
 
 type Service interface {
 	UploadAsset(Context context.Context, DetailUploadInfor model.DetailUploadInfor) (*UploadResult, error)
+	EnqueueUpload(Context context.Context, DetailUploadInfor model.DetailUploadInfor, Workspace *business.Workspace) (*model.Job, error)
 	GetAsset(Context context.Context, RoomID int) ([]model.ResponseMetadataInfor, error)
 }
 
 type AssetService struct {
 	AssetRepo  Repository
-	PinataRepo business.PinataRepository
+	Storage    business.Storage
 	TTSRepo    business.TTSRepository
+	JobService jobs.Service
 }
 
-func NewService(AssetRepo Repository, PinataRepo business.PinataRepository, TTSRepo business.TTSRepository) *AssetService {
-	return &AssetService{AssetRepo: AssetRepo, PinataRepo: PinataRepo, TTSRepo: TTSRepo}
+func NewService(AssetRepo Repository, Storage business.Storage, TTSRepo business.TTSRepository, JobService jobs.Service) *AssetService {
+	return &AssetService{AssetRepo: AssetRepo, Storage: Storage, TTSRepo: TTSRepo, JobService: JobService}
+}
+
+// EnqueueUpload records a queued job and returns it right away; UploadAsset runs later on
+// the job worker pool. The workspace holding info.FilePath is removed once the job has finished,
+// so callers hand over ownership of it here.
+func (s *AssetService) EnqueueUpload(ctx context.Context, info model.DetailUploadInfor, workspace *business.Workspace) (*model.Job, error) {
+	jobID, err := business.RandomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate job id: %w", err)
+	}
+	info.JobID = jobID
+
+	job := &model.Job{
+		JobID:    jobID,
+		RoomID:   uint(info.RoomID),
+		MeshName: info.MeshName,
+		Filename: info.Filename,
+	}
+	err = s.JobService.Submit(ctx, job, func(jobCtx context.Context) (string, error) {
+		defer workspace.Cleanup()
+		result, err := s.UploadAsset(jobCtx, info)
+		if err != nil {
+			return "", err
+		}
+		return result.AssetCID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
 }
 
 // UploadAsset uploads the converted (ktx2 / webp fallback) asset, stores DB, and schedules TTS jobs.
 func (s *AssetService) UploadAsset(ctx context.Context, info model.DetailUploadInfor) (*UploadResult, error) {
-	// Save temp file (many converters expect a path)
-	tempPath := filepath.Join(os.TempDir(), fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(info.Filename)))
-	if err := os.WriteFile(tempPath, info.FileBuffer, 0644); err != nil {
-		return &UploadResult{}, fmt.Errorf("failed to save temp file: %w", err)
+	ctx = business.WithJobID(ctx, info.JobID)
+	s.setJobStatus(ctx, info.JobID, model.JobConverting)
+
+	// Converter outputs go to a workspace private to this upload, removed once it is pinned.
+	workspace, err := business.NewWorkspace("convert")
+	if err != nil {
+		return &UploadResult{}, err
 	}
-	defer os.Remove(tempPath)
+	defer workspace.Cleanup()
 
-	// Convert to KTX2 (preferred). Fallback to original bytes if conversion fails.
-	ktx2Buffer, ktx2Name, err := business.ConvertToKTX2(tempPath)
+	profile, err := s.resolveEncodingProfile(ctx, info)
 	if err != nil {
-		fmt.Printf("[WARN] KTX2 conversion failed: %v\n", err)
-		ktx2Buffer = info.FileBuffer
-		ktx2Name = info.Filename
+		return &UploadResult{}, err
+	}
+	info.EncodingProfile = profile.Name
+	categoryID, _, err := business.CategorizeFile(info.Filename)
+	if err != nil {
+		return &UploadResult{}, err
+	}
+
+	// The same upload converted with the same options was stored before: reuse it without converting
+	if info.SourceKey, err = s.sourceKey(info); err != nil {
+		fmt.Printf("[WARN] failed to hash upload: %v\n", err)
+	} else if existing, err := s.AssetRepo.FindAssetBySource(ctx, info.SourceKey); err != nil {
+		fmt.Printf("[WARN] source lookup failed: %v\n", err)
+	} else if existing != nil {
+		info.Normalization = existing.Normalization
+		return s.reuseStoredAsset(ctx, existing, existing.AssetName, info, UploadResult{
+			Deduplicated: true,
+			Message:      "Identical file already stored, CIDs reused",
+		})
+	}
+
+	// Camera photos are rotated, oversized and odd-sized; fix them up before the encoders see them.
+	// The upload is already spooled to disk by the handler, converters read it by path.
+	roomChannel := "room:" + strconv.Itoa(info.RoomID)
+	sourcePath := info.FilePath
+	var normalization *business.NormalizationReport
+	if business.CanNormalize(info.Filename) {
+		sourcePath, normalization, err = business.NormalizeImage(ctx, info.FilePath, workspace.Dir, info.NormalizeMode)
+		if err != nil {
+			return &UploadResult{}, fmt.Errorf("image normalization failed: %w", err)
+		}
+		if report, err := json.Marshal(normalization); err == nil {
+			info.Normalization = string(report)
+		}
+		broadcast(info.JobID, roomChannel, map[string]interface{}{
+			"type":          "upload",
+			"status":        "normalized",
+			"normalization": normalization,
+			"progress":      3,
+		})
 	}
 
-	// WebP fallback (best-effort)
-	webpBuffer, webpName, err := business.ConvertToWebP(tempPath)
+	primaryPath := sourcePath
+	primaryName := filepath.Base(sourcePath)
+	if sourcePath == info.FilePath {
+		primaryName = info.Filename
+	}
+	var modelReport *business.ModelOptimizationReport
+	if categoryID == 3 {
+		// glTF/GLB: toktx only understands images, the model optimizer encodes its textures instead.
+		// Fallback to streaming the original model if it cannot be optimized.
+		if optimizedPath, report, err := business.OptimizeModel(ctx, sourcePath, workspace.Dir, profile); err != nil {
+			fmt.Printf("[WARN] model optimization failed: %v\n", err)
+		} else {
+			primaryPath = optimizedPath
+			primaryName = filepath.Base(optimizedPath)
+			modelReport = report
+			broadcast(info.JobID, roomChannel, map[string]interface{}{
+				"type":     "upload",
+				"status":   "optimized",
+				"model":    modelReport,
+				"progress": 4,
+			})
+		}
+	} else if categoryID == 2 {
+		// Videos are pinned as uploaded, the HLS ladder is transcoded and pinned next to them below
+	} else if ktx2Path, err := business.ConvertToKTX2(ctx, sourcePath, workspace.Dir, profile); errors.Is(err, business.ErrConverterLimit) {
+		// Convert to KTX2 (preferred). Fallback to streaming the (normalized) source if conversion fails,
+		// but not when it hit a resource limit: that would hand the same oversized texture to every visitor.
+		return &UploadResult{}, err
+	} else if err != nil {
+		fmt.Printf("[WARN] KTX2 conversion failed: %v\n", err)
+	} else {
+		primaryPath = ktx2Path
+		primaryName = filepath.Base(ktx2Path)
+	}
+	primary, primarySize, err := openForUpload(primaryPath)
 	if err != nil {
-		fmt.Printf("[WARN] WebP conversion failed: %v\n", err)
+		return &UploadResult{}, err
+	}
+	defer primary.Close()
+
+	// Re-uploading the latest version of a mesh (e.g. to fix its description) pins nothing again
+	if latest, err := s.latestIfUnchanged(ctx, primary, info); err != nil {
+		fmt.Printf("[WARN] unchanged upload check failed: %v\n", err)
+	} else if latest != nil {
+		return s.reuseStoredAsset(ctx, latest, strings.TrimSuffix(primaryName, filepath.Ext(primaryName)), info, UploadResult{
+			Normalization: normalization,
+			Model:         modelReport,
+			Unchanged:     true,
+			Message:       "Asset unchanged, metadata updated",
+		})
+	}
+
+	// WebP rendition ladder (best-effort): images get the ladder, models a rendered preview
+	var webpRenditions []business.WebPRendition
+	if categoryID == 1 {
+		// Normalization keeps only the first frame, animations are converted from the upload itself
+		webpSource := sourcePath
+		if business.IsAnimatedImage(info.FilePath) {
+			webpSource = info.FilePath
+		}
+		if webpRenditions, err = business.ConvertToWebPRenditions(ctx, webpSource, workspace.Dir, info.WebPMode); err != nil {
+			fmt.Printf("[WARN] WebP conversion failed: %v\n", err)
+		}
+	} else if categoryID == 3 {
+		if thumbnail, err := business.RenderModelThumbnail(ctx, sourcePath, workspace.Dir); err != nil {
+			fmt.Printf("[WARN] model thumbnail rendering failed: %v\n", err)
+		} else {
+			webpRenditions = append(webpRenditions, thumbnail)
+		}
 	}
 
 	// Broadcast: room-level upload started (so any admins in same room know something is happening)
-	roomChannel := "room:" + strconv.Itoa(info.RoomID)
-	websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+	broadcast(info.JobID, roomChannel, map[string]interface{}{
 		"type":     "upload",
 		"status":   "starting",
 		"message":  "upload starting",
 		"progress": 5,
 	})
 
-	// Upload primary (KTX2 or original) to Pinata
-	ktx2Resp, err := s.PinataRepo.UploadAssetToPinata(ktx2Buffer, ktx2Name, roomChannel)
+	// Upload primary (KTX2 or original) to storage
+	s.setJobStatus(ctx, info.JobID, model.JobPinning)
+	ktx2Resp, deduplicated, err := s.putFile(ctx, primary, primarySize, primaryName, roomChannel)
+	if deduplicated {
+		ktx2Resp.CategoryID = categoryID
+	}
 	if err != nil {
-		websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+		broadcast(info.JobID, roomChannel, map[string]interface{}{
 			"type":     "upload",
 			"status":   "failed",
 			"error":    err.Error(),
 			"progress": 0,
 		})
-		return &UploadResult{}, fmt.Errorf("pinata KTX2 upload failed: %w", err)
+		return &UploadResult{}, fmt.Errorf("%s KTX2 upload failed: %w", s.Storage.Name(), err)
 	}
 
 	assetChannel := "asset:" + ktx2Resp.IpfsHash
 
 	// Broadcast: uploaded primary
-	websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+	broadcast(info.JobID, assetChannel, map[string]interface{}{
 		"type":      "upload",
 		"asset_cid": ktx2Resp.IpfsHash,
 		"status":    "uploaded",
 		"progress":  60,
 	})
-	websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+	broadcast(info.JobID, roomChannel, map[string]interface{}{
 		"type":      "upload",
 		"asset_cid": ktx2Resp.IpfsHash,
 		"status":    "uploaded",
 		"progress":  60,
 	})
 
-	// Upload webp as fallback (optional)
+	// Upload the webp ladder (optional), the original rendition doubles as the webp fallback,
+	// for models the rendered thumbnail is the only one
 	var webpCID string
-	if len(webpBuffer) > 0 {
-		if webpResp, err := s.PinataRepo.UploadAssetToPinata(webpBuffer, webpName, roomChannel); err == nil {
-			webpCID = webpResp.IpfsHash
+	var renditions []model.AssetRendition
+	for _, webpRendition := range webpRenditions {
+		rendition, err := s.pinRendition(ctx, webpRendition.Path, model.AssetRendition{
+			Kind:     model.RenditionWebP,
+			Label:    webpRendition.Label,
+			Width:    webpRendition.Width,
+			Height:   webpRendition.Height,
+			Mime:     "image/webp",
+			WebPMode: webpRendition.Mode,
+			Animated: webpRendition.Animated,
+		}, roomChannel)
+		if err != nil {
+			fmt.Printf("[WARN] webp %s upload failed: %v\n", webpRendition.Label, err)
+			continue
+		}
+		if rendition.Label == "original" || categoryID == 3 {
+			webpCID = rendition.CID
+		}
+		renditions = append(renditions, rendition)
+	}
+
+	// Model LODs (optional): lod0 is the model just pinned, the simplified levels are pinned next to it
+	if categoryID == 3 {
+		renditions = append(renditions, s.pinModelLODs(ctx, primaryPath, workspace.Dir, ktx2Resp.IpfsHash, primarySize, roomChannel)...)
+	}
+
+	// Deep-zoom tiles (on request, images only): cut from the upload itself, the normalized copy is capped
+	if categoryID == 1 && info.DeepZoom {
+		if manifest, err := s.pinDeepZoom(ctx, info.JobID, info.FilePath, workspace.Dir, roomChannel); err != nil {
+			fmt.Printf("[WARN] deep-zoom tiling failed: %v\n", err)
+		} else {
+			info.TileManifest = manifest
+		}
+	}
+
+	// HLS ladder and poster (optional): without them the original video is still playable on desktops.
+	// Segments are stored with the renditions, so retention unpins them, but not reported.
+	var segments []model.AssetRendition
+	if categoryID == 2 {
+		if video, err := s.pinVideo(ctx, info.JobID, sourcePath, workspace.Dir, roomChannel, assetChannel); err != nil {
+			fmt.Printf("[WARN] video transcoding failed: %v\n", err)
 		} else {
-			fmt.Printf("[WARN] webp upload failed: %v\n", err)
+			info.HLSCID = video.MasterCID
+			info.Duration = video.Probe.Duration
+			info.Width = video.Probe.Width
+			info.Height = video.Probe.Height
+			webpCID = video.PosterCID
+			renditions = append(renditions, video.Renditions...)
+			segments = video.Segments
 		}
 	}
 
 	// Upsert asset in DB with fallback info
+	s.setJobStatus(ctx, info.JobID, model.JobPersisting)
 	if err := s.AssetRepo.UpsertAsset(ctx, ktx2Resp, webpCID, info); err != nil {
 		// commit DB error but still let system know
-		websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+		broadcast(info.JobID, assetChannel, map[string]interface{}{
 			"type":     "upload",
 			"status":   "db_error",
 			"error":    err.Error(),
@@ -120,40 +325,325 @@ func (s *AssetService) UploadAsset(ctx context.Context, info model.DetailUploadI
 		})
 		return &UploadResult{}, err
 	}
+	if err := s.AssetRepo.SaveRenditions(ctx, ktx2Resp.IpfsHash, slices.Concat(renditions, segments)); err != nil {
+		fmt.Printf("[WARN] failed to store renditions of %s: %v\n", ktx2Resp.IpfsHash, err)
+	}
 
 	// Broadcast finalization
-	websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+	broadcast(info.JobID, assetChannel, map[string]interface{}{
 		"type":      "upload",
 		"asset_cid": ktx2Resp.IpfsHash,
 		"status":    "completed",
 		"progress":  100,
 	})
-	websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+	broadcast(info.JobID, roomChannel, map[string]interface{}{
 		"type":      "upload",
 		"asset_cid": ktx2Resp.IpfsHash,
 		"status":    "completed",
 		"progress":  100,
 	})
 
+	s.scheduleAudio(ctx, ktx2Resp.IpfsHash, info)
+
+	var response = &UploadResult{
+		Success:         true,
+		AssetCID:        ktx2Resp.IpfsHash,
+		WebpCID:         webpCID,
+		EncodingProfile: info.EncodingProfile,
+		Normalization:   normalization,
+		Renditions:      renditions,
+		Model:           modelReport,
+		HLSCID:          info.HLSCID,
+		TileManifest:    info.TileManifest,
+		CIDMismatch:     ktx2Resp.CIDMismatch,
+		Deduplicated:    deduplicated,
+		Message:         "Upload successfully",
+	}
+
+	return response, nil
+}
+
+// latestIfUnchanged returns the latest version of the mesh when primary is the file it was stored from,
+// nil otherwise. primary is rewound for the upload.
+func (s *AssetService) latestIfUnchanged(ctx context.Context, primary *os.File, info model.DetailUploadInfor) (*model.Asset, error) {
+	latest, err := s.AssetRepo.GetLatestAsset(ctx, info.RoomID, info.MeshName)
+	if err != nil || latest == nil {
+		return nil, err
+	}
+	contentID, err := s.Storage.ContentID(primary)
+	if _, seekErr := primary.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if contentID != latest.AssetCID {
+		return nil, nil
+	}
+	return latest, nil
+}
+
+// reuseStoredAsset points the mesh at an asset stored before and updates its metadata, keeping the
+// renditions, tiles and HLS ladder pinned with it. result carries what the caller already knows.
+func (s *AssetService) reuseStoredAsset(ctx context.Context, existing *model.Asset, assetName string, info model.DetailUploadInfor, result UploadResult) (*UploadResult, error) {
+	roomChannel := "room:" + strconv.Itoa(info.RoomID)
+	assetChannel := "asset:" + existing.AssetCID
+	info.HLSCID = existing.HLSCID
+	info.Duration = existing.Duration
+	info.Width = existing.Width
+	info.Height = existing.Height
+	info.TileManifest = existing.TileManifest
+	stored := model.AssetStruct{
+		Filename:   assetName,
+		IpfsHash:   existing.AssetCID,
+		CategoryID: int(existing.CategoryID),
+	}
+
+	// Retention may be pruning the version the CIDs come from
+	claimed := []string{existing.AssetCID, existing.WebpCID, existing.HLSCID}
+	if existing.TileManifest != nil {
+		claimed = append(claimed, existing.TileManifest.CID)
+	}
+	if err := s.AssetRepo.ClaimBlobs(ctx, claimed); err != nil {
+		fmt.Printf("[WARN] failed to claim blobs of %s: %v\n", existing.AssetCID, err)
+	}
+
+	s.setJobStatus(ctx, info.JobID, model.JobPersisting)
+	if err := s.AssetRepo.UpsertAsset(ctx, stored, existing.WebpCID, info); err != nil {
+		broadcast(info.JobID, assetChannel, map[string]interface{}{
+			"type":     "upload",
+			"status":   "db_error",
+			"error":    err.Error(),
+			"progress": 80,
+		})
+		return &UploadResult{}, err
+	}
+
+	for _, channel := range []string{assetChannel, roomChannel} {
+		broadcast(info.JobID, channel, map[string]interface{}{
+			"type":         "upload",
+			"asset_cid":    existing.AssetCID,
+			"status":       "completed",
+			"unchanged":    result.Unchanged,
+			"deduplicated": result.Deduplicated,
+			"progress":     100,
+		})
+	}
+
+	s.scheduleAudio(ctx, existing.AssetCID, info)
+
+	result.Success = true
+	result.AssetCID = existing.AssetCID
+	result.WebpCID = existing.WebpCID
+	result.EncodingProfile = info.EncodingProfile
+	result.HLSCID = info.HLSCID
+	result.TileManifest = info.TileManifest
+	return &result, nil
+}
+
+// sourceKey identifies an upload together with everything its conversion depends on.
+func (s *AssetService) sourceKey(info model.DetailUploadInfor) (string, error) {
+	file, err := os.Open(info.FilePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sum, _, err := business.HashFileSHA256(file)
+	if err != nil {
+		return "", err
+	}
+	return business.HashTextSHA256(strings.Join([]string{
+		sum,
+		s.Storage.Name(),
+		info.EncodingProfile,
+		info.NormalizeMode,
+		info.WebPMode,
+		strconv.FormatBool(info.DeepZoom),
+	}, "\x00")), nil
+}
+
+// putFile stores file unless identical content is in the blob index, in which case the CID it
+// was stored under is reused and deduplicated is true.
+func (s *AssetService) putFile(ctx context.Context, file *os.File, size int64, name string, progressChannel string) (stored model.AssetStruct, deduplicated bool, err error) {
+	sum, _, err := business.HashFileSHA256(file)
+	if err != nil {
+		return model.AssetStruct{}, false, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return model.AssetStruct{}, false, err
+	}
+
+	driver := s.Storage.Name()
+	if blob, err := s.AssetRepo.FindBlob(ctx, sum, driver); err != nil {
+		fmt.Printf("[WARN] blob index lookup failed: %v\n", err)
+	} else if blob != nil {
+		// Claimed so retention does not unpin it before the asset pointing at it is saved,
+		// unclaimed content is stored again instead
+		if err := s.AssetRepo.ClaimBlobs(ctx, []string{blob.CID}); err != nil {
+			fmt.Printf("[WARN] failed to claim blob %s: %v\n", blob.CID, err)
+		} else {
+			return model.AssetStruct{Filename: strings.TrimSuffix(name, filepath.Ext(name)), IpfsHash: blob.CID}, true, nil
+		}
+	}
+
+	stored, err = s.Storage.PutAsset(ctx, file, size, name, progressChannel)
+	if err != nil {
+		return model.AssetStruct{}, false, err
+	}
+	blob := model.Blob{SHA256: sum, Driver: driver, CID: stored.IpfsHash, Size: size, Mime: business.MimeType(name), LastUsedAt: time.Now()}
+	if err := s.AssetRepo.SaveBlob(ctx, blob); err != nil {
+		fmt.Printf("[WARN] failed to index blob %s: %v\n", stored.IpfsHash, err)
+	}
+	return stored, false, nil
+}
+
+// scheduleAudio records the TTS jobs of the descriptions and processes them in the background.
+func (s *AssetService) scheduleAudio(ctx context.Context, assetCID string, info model.DetailUploadInfor) {
 	// Create audio jobs records in DB
 	if info.EnglishDescription != "" {
-		_, _ = s.AssetRepo.InsertAudio(ctx, ktx2Resp.IpfsHash, "en", info.EnglishDescription)
+		_, _ = s.AssetRepo.InsertAudio(ctx, assetCID, "en", info.EnglishDescription)
 	}
 	if info.VietnameseDescription != "" {
-		_, _ = s.AssetRepo.InsertAudio(ctx, ktx2Resp.IpfsHash, "vi", info.VietnameseDescription)
+		_, _ = s.AssetRepo.InsertAudio(ctx, assetCID, "vi", info.VietnameseDescription)
 	}
 
 	// Launch async TTS processing
-	go s.ProcessAudioJobs(ktx2Resp.IpfsHash, info)
+	go s.ProcessAudioJobs(assetCID, info)
+}
 
-	var response = &UploadResult{
-		Success:  true,
-		AssetCID: ktx2Resp.IpfsHash,
-		WebpCID:  webpCID,
-		Message:  "Upload successfully",
+// resolveEncodingProfile picks the KTX2 profile: the upload's own choice first, then the
+// room default, then the category default, then business.DefaultKTX2Profile.
+func (s *AssetService) resolveEncodingProfile(ctx context.Context, info model.DetailUploadInfor) (business.KTX2Profile, error) {
+	if info.EncodingProfile != "" {
+		return business.LookupKTX2Profile(info.EncodingProfile)
 	}
 
-	return response, nil
+	categoryID, _, err := business.CategorizeFile(info.Filename)
+	if err != nil {
+		return business.KTX2Profile{}, err
+	}
+	roomProfile, categoryProfile, err := s.AssetRepo.GetEncodingDefaults(ctx, info.RoomID, categoryID)
+	if err != nil {
+		fmt.Printf("[WARN] failed to load encoding defaults: %v\n", err)
+	}
+	for _, name := range []string{roomProfile, categoryProfile} {
+		if name == "" {
+			continue
+		}
+		if profile, err := business.LookupKTX2Profile(name); err == nil {
+			return profile, nil
+		}
+		fmt.Printf("[WARN] ignoring misconfigured default encoding profile %q\n", name)
+	}
+	return business.LookupKTX2Profile(business.DefaultKTX2Profile)
+}
+
+// pinRendition pins the file at path and fills in the CID and size of the rendition describing it.
+func (s *AssetService) pinRendition(ctx context.Context, path string, rendition model.AssetRendition, progressChannel string) (model.AssetRendition, error) {
+	file, size, err := openForUpload(path)
+	if err != nil {
+		return model.AssetRendition{}, err
+	}
+	defer file.Close()
+
+	resp, _, err := s.putFile(ctx, file, size, filepath.Base(path), progressChannel)
+	if err != nil {
+		return model.AssetRendition{}, err
+	}
+	rendition.CID = resp.IpfsHash
+	rendition.Filesize = size
+	return rendition, nil
+}
+
+// pinDeepZoom cuts the image into a tile pyramid and pins it as one directory.
+func (s *AssetService) pinDeepZoom(ctx context.Context, jobID string, imagePath string, outputDir string, progressChannel string) (*model.TileManifest, error) {
+	broadcast(jobID, progressChannel, map[string]interface{}{
+		"type":     "upload",
+		"status":   "tiling",
+		"progress": 65,
+	})
+	pyramid, err := business.GenerateDeepZoom(ctx, imagePath, outputDir)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Storage.PutDirectory(ctx, pyramid.Dir, progressChannel)
+	if err != nil {
+		return nil, fmt.Errorf("tile upload failed: %w", err)
+	}
+	return &model.TileManifest{
+		CID:        resp.IpfsHash,
+		Descriptor: pyramid.Descriptor(),
+		TilePath:   pyramid.TileTemplate(),
+		Format:     "webp",
+		Width:      pyramid.Width,
+		Height:     pyramid.Height,
+		TileSize:   pyramid.TileSize,
+		Overlap:    pyramid.Overlap,
+		MaxLevel:   pyramid.MaxLevel,
+		Tiles:      pyramid.Tiles,
+	}, nil
+}
+
+// pinModelLODs simplifies the pinned model into its LOD levels and pins every level but lod0,
+// which is the model itself. Failures are logged, an asset without LODs still works.
+func (s *AssetService) pinModelLODs(ctx context.Context, modelPath string, outputDir string, modelCID string, modelSize int64, progressChannel string) []model.AssetRendition {
+	lods, err := business.GenerateModelLODs(ctx, modelPath, outputDir)
+	if err != nil {
+		fmt.Printf("[WARN] LOD generation failed: %v\n", err)
+		return nil
+	}
+
+	var renditions []model.AssetRendition
+	for _, lod := range lods {
+		rendition := model.AssetRendition{
+			Kind:          model.RenditionLOD,
+			Label:         lod.Label,
+			Mime:          "model/gltf-binary",
+			TriangleCount: lod.Triangles,
+			BoundingBox:   lod.Bounds,
+		}
+		if lod.Path == "" {
+			rendition.CID = modelCID
+			rendition.Filesize = modelSize
+		} else if rendition, err = s.pinRendition(ctx, lod.Path, rendition, progressChannel); err != nil {
+			fmt.Printf("[WARN] %s upload failed: %v\n", lod.Label, err)
+			continue
+		}
+		renditions = append(renditions, rendition)
+	}
+	return renditions
+}
+
+// openForUpload opens a file to be streamed to storage and returns its size for progress reporting.
+func openForUpload(path string) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+func (s *AssetService) setJobStatus(ctx context.Context, jobID string, status string) {
+	if jobID == "" || s.JobService == nil {
+		return
+	}
+	if err := s.JobService.SetStatus(ctx, jobID, status); err != nil {
+		fmt.Printf("[WARN] failed to update job %s to %s: %v\n", jobID, status, err)
+	}
+}
+
+// broadcast sends a progress event, tagged with the job ID when the upload runs as a job.
+func broadcast(jobID string, channel string, data map[string]interface{}) {
+	if jobID != "" {
+		data["job_id"] = jobID
+	}
+	websocket.GlobalHub.BroadcastProgress(channel, data)
 }
 
 func (AssetService *AssetService) GetAsset(context context.Context, RoomID int) ([]model.ResponseMetadataInfor, error) {
@@ -163,7 +653,7 @@ func (AssetService *AssetService) GetAsset(context context.Context, RoomID int)
 
 // ProcessAudioJobs processes EN/VI TTS for a single assetCID with concurrency per language.
 func (s *AssetService) ProcessAudioJobs(assetCID string, detail model.DetailUploadInfor) {
-	ctx := context.Background()
+	ctx := business.WithJobID(context.Background(), detail.JobID)
 
 	type job struct {
 		Lang string
@@ -195,7 +685,7 @@ func (s *AssetService) ProcessAudioJobs(assetCID string, detail model.DetailUplo
 			existing, err := s.AssetRepo.FindAudioByHash(ctx, textHash, j.Lang)
 			if err == nil && existing.AudioCID != "" {
 				// reuse
-				_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, "completed", existing.AudioCID, attempts)
+				_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, textHash, "completed", existing.AudioCID, attempts)
 				msg := map[string]interface{}{
 					"type":     "tts",
 					"language": j.Lang,
@@ -203,20 +693,20 @@ func (s *AssetService) ProcessAudioJobs(assetCID string, detail model.DetailUplo
 					"cid":      existing.AudioCID,
 					"progress": 100,
 				}
-				websocket.GlobalHub.BroadcastProgress(assetChannel, msg)
-				websocket.GlobalHub.BroadcastProgress(roomChannel, msg)
+				broadcast(detail.JobID, assetChannel, msg)
+				broadcast(detail.JobID, roomChannel, msg)
 				return
 			}
 
 			// Mark processing and broadcast
-			_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, "processing", "", attempts)
-			websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+			_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, textHash, "processing", "", attempts)
+			broadcast(detail.JobID, assetChannel, map[string]interface{}{
 				"type":     "tts",
 				"language": j.Lang,
 				"status":   "processing",
 				"progress": 10,
 			})
-			websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+			broadcast(detail.JobID, roomChannel, map[string]interface{}{
 				"type":     "tts",
 				"language": j.Lang,
 				"status":   "processing",
@@ -227,14 +717,14 @@ func (s *AssetService) ProcessAudioJobs(assetCID string, detail model.DetailUplo
 			audioData, fileName, err := s.TTSRepo.GenerateAudio(ctx, j.Text, j.Lang, detail.MeshName)
 			if err != nil {
 				attempts++
-				_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, "failed", "", attempts)
-				websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+				_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, textHash, "failed", "", attempts)
+				broadcast(detail.JobID, assetChannel, map[string]interface{}{
 					"type":     "tts",
 					"language": j.Lang,
 					"status":   "failed",
 					"progress": 0,
 				})
-				websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+				broadcast(detail.JobID, roomChannel, map[string]interface{}{
 					"type":     "tts",
 					"language": j.Lang,
 					"status":   "failed",
@@ -244,30 +734,30 @@ func (s *AssetService) ProcessAudioJobs(assetCID string, detail model.DetailUplo
 			}
 
 			// Upload audio
-			websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+			broadcast(detail.JobID, assetChannel, map[string]interface{}{
 				"type":     "tts",
 				"language": j.Lang,
 				"status":   "uploading",
 				"progress": 70,
 			})
-			websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+			broadcast(detail.JobID, roomChannel, map[string]interface{}{
 				"type":     "tts",
 				"language": j.Lang,
 				"status":   "uploading",
 				"progress": 70,
 			})
 
-			resp, err := s.PinataRepo.UploadAudioToPinata(audioData, fileName, assetChannel)
+			resp, err := s.Storage.PutAudio(ctx, bytes.NewReader(audioData), int64(len(audioData)), fileName, assetChannel)
 			if err != nil {
 				attempts++
-				_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, "failed", "", attempts)
-				websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+				_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, textHash, "failed", "", attempts)
+				broadcast(detail.JobID, assetChannel, map[string]interface{}{
 					"type":     "tts",
 					"language": j.Lang,
 					"status":   "failed",
 					"progress": 0,
 				})
-				websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+				broadcast(detail.JobID, roomChannel, map[string]interface{}{
 					"type":     "tts",
 					"language": j.Lang,
 					"status":   "failed",
@@ -277,10 +767,10 @@ func (s *AssetService) ProcessAudioJobs(assetCID string, detail model.DetailUplo
 			}
 
 			duration := time.Since(start).Milliseconds()
-			_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, "completed", resp.IpfsHash, attempts)
+			_ = s.AssetRepo.UpdateAudio(ctx, assetCID, j.Lang, textHash, "completed", resp.IpfsHash, attempts)
 
 			// Broadcast completion to both channels
-			websocket.GlobalHub.BroadcastProgress(assetChannel, map[string]interface{}{
+			broadcast(detail.JobID, assetChannel, map[string]interface{}{
 				"type":     "tts",
 				"language": j.Lang,
 				"status":   "completed",
@@ -288,7 +778,7 @@ func (s *AssetService) ProcessAudioJobs(assetCID string, detail model.DetailUplo
 				"progress": 100,
 				"duration": duration,
 			})
-			websocket.GlobalHub.BroadcastProgress(roomChannel, map[string]interface{}{
+			broadcast(detail.JobID, roomChannel, map[string]interface{}{
 				"type":     "tts",
 				"language": j.Lang,
 				"status":   "completed",
@@ -0,0 +1,268 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"main/business"
+	"main/model"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+// fakeRepo keeps assets and blobs in memory and enforces the unique (room, mesh, version) index.
+type fakeRepo struct {
+	mu     sync.Mutex
+	nextID uint
+	assets []model.Asset
+	blobs  map[string]model.Blob
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{blobs: make(map[string]model.Blob)}
+}
+
+func (r *fakeRepo) UpsertAsset(ctx context.Context, ktx2Resp model.AssetStruct, webpCID string, info model.DetailUploadInfor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latest := r.latest(info.RoomID, info.MeshName)
+	if latest != nil && latest.AssetCID == ktx2Resp.IpfsHash {
+		latest.Title = info.Title
+		latest.WebpCID = webpCID
+		latest.SourceKey = info.SourceKey
+		return nil
+	}
+	version := 1
+	if latest != nil {
+		version = latest.Version + 1
+	}
+	for _, asset := range r.assets {
+		if int(asset.RoomID) == info.RoomID && asset.AssetMeshName == info.MeshName && asset.Version == version {
+			return fmt.Errorf("duplicate key value violates unique constraint \"uniq_assets_room_mesh_version\"")
+		}
+	}
+	r.nextID++
+	r.assets = append(r.assets, model.Asset{
+		AID:           r.nextID,
+		AssetCID:      ktx2Resp.IpfsHash,
+		WebpCID:       webpCID,
+		AssetMeshName: info.MeshName,
+		AssetName:     ktx2Resp.Filename,
+		Title:         info.Title,
+		SourceKey:     info.SourceKey,
+		RoomID:        uint(info.RoomID),
+		CategoryID:    uint(ktx2Resp.CategoryID),
+		Version:       version,
+	})
+	return nil
+}
+
+func (r *fakeRepo) latest(roomID int, meshName string) *model.Asset {
+	var latest *model.Asset
+	for i := range r.assets {
+		asset := &r.assets[i]
+		if int(asset.RoomID) == roomID && asset.AssetMeshName == meshName && (latest == nil || asset.Version > latest.Version) {
+			latest = asset
+		}
+	}
+	return latest
+}
+
+func (r *fakeRepo) GetLatestAsset(ctx context.Context, roomID int, meshName string) (*model.Asset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if latest := r.latest(roomID, meshName); latest != nil {
+		copied := *latest
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) FindAssetBySource(ctx context.Context, sourceKey string) (*model.Asset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.assets) - 1; i >= 0; i-- {
+		if r.assets[i].SourceKey == sourceKey {
+			copied := r.assets[i]
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) FindBlob(ctx context.Context, sha256 string, driver string) (*model.Blob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if blob, ok := r.blobs[sha256+driver]; ok {
+		return &blob, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) SaveBlob(ctx context.Context, blob model.Blob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.blobs[blob.SHA256+blob.Driver]; !ok {
+		r.blobs[blob.SHA256+blob.Driver] = blob
+	}
+	return nil
+}
+
+func (r *fakeRepo) ClaimBlobs(ctx context.Context, cids []string) error {
+	return nil
+}
+
+func (r *fakeRepo) GetAsset(ctx context.Context, roomID int) ([]model.ResponseMetadataInfor, error) {
+	return nil, nil
+}
+
+func (r *fakeRepo) InsertAudio(ctx context.Context, assetCID, language, description string) (*model.Audio, error) {
+	return &model.Audio{}, nil
+}
+
+func (r *fakeRepo) FindAudioByHash(ctx context.Context, textHash string, language string) (*model.Audio, error) {
+	return nil, nil
+}
+
+func (r *fakeRepo) UpdateAudio(ctx context.Context, assetCID string, language string, textHash string, status, audioCID string, attempts int) error {
+	return nil
+}
+
+func (r *fakeRepo) FetchPendingAudioJobs(ctx context.Context, limit int) ([]model.Audio, error) {
+	return nil, nil
+}
+
+func (r *fakeRepo) GetEncodingDefaults(ctx context.Context, roomID int, categoryID int) (string, string, error) {
+	return "", "", nil
+}
+
+func (r *fakeRepo) SaveRenditions(ctx context.Context, assetCID string, renditions []model.AssetRendition) error {
+	return nil
+}
+
+func newTestService(t *testing.T) (*AssetService, *fakeRepo) {
+	t.Helper()
+	storage, err := business.NewLocalStorage(t.TempDir(), "http://localhost/storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newFakeRepo()
+	return NewService(repo, storage, nil, nil), repo
+}
+
+// writePNG writes a small opaque image whose pixels depend on seed.
+func writePNG(t *testing.T, seed uint8) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: seed, A: 255})
+		}
+	}
+	path := filepath.Join(t.TempDir(), fmt.Sprintf("upload-%d.png", seed))
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func upload(t *testing.T, s *AssetService, path string, roomID int, meshName string) *UploadResult {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.UploadAsset(context.Background(), model.DetailUploadInfor{
+		Filename: filepath.Base(path),
+		MeshName: meshName,
+		Title:    meshName,
+		RoomID:   roomID,
+		FilePath: path,
+		Filesize: info.Size(),
+	})
+	if err != nil {
+		t.Fatalf("upload of %s to room %d mesh %s failed: %v", filepath.Base(path), roomID, meshName, err)
+	}
+	return result
+}
+
+func TestIdenticalUploadToAnotherMeshReusesCIDs(t *testing.T) {
+	s, repo := newTestService(t)
+	path := writePNG(t, 1)
+
+	first := upload(t, s, path, 1, "statue")
+	for _, target := range []struct {
+		roomID   int
+		meshName string
+	}{{1, "statue-copy"}, {2, "statue"}} {
+		result := upload(t, s, path, target.roomID, target.meshName)
+		if !result.Deduplicated {
+			t.Errorf("room %d mesh %s: upload not deduplicated", target.roomID, target.meshName)
+		}
+		if result.AssetCID != first.AssetCID || result.WebpCID != first.WebpCID {
+			t.Errorf("room %d mesh %s: got CIDs %s/%s, want %s/%s", target.roomID, target.meshName,
+				result.AssetCID, result.WebpCID, first.AssetCID, first.WebpCID)
+		}
+		latest, _ := repo.GetLatestAsset(context.Background(), target.roomID, target.meshName)
+		if latest == nil || latest.Version != 1 || latest.AssetCID != first.AssetCID {
+			t.Errorf("room %d mesh %s: got latest version %+v", target.roomID, target.meshName, latest)
+		}
+	}
+	if len(repo.assets) != 3 {
+		t.Errorf("got %d asset rows, want 3", len(repo.assets))
+	}
+}
+
+func TestReuploadOfOlderVersionAddsVersion(t *testing.T) {
+	s, repo := newTestService(t)
+	original := writePNG(t, 1)
+	edited := writePNG(t, 2)
+
+	first := upload(t, s, original, 1, "statue")
+	upload(t, s, edited, 1, "statue")
+	result := upload(t, s, original, 1, "statue")
+
+	if result.AssetCID != first.AssetCID {
+		t.Errorf("got CID %s, want %s", result.AssetCID, first.AssetCID)
+	}
+	latest, _ := repo.GetLatestAsset(context.Background(), 1, "statue")
+	if latest == nil || latest.Version != 3 || latest.AssetCID != first.AssetCID {
+		t.Errorf("got latest version %+v, want version 3 of %s", latest, first.AssetCID)
+	}
+}
+
+// Meshes share CIDs, so only the version of a mesh may be unique.
+func TestAssetCIDsAreNotUnique(t *testing.T) {
+	assetSchema, err := schema.Parse(&model.Asset{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, column := range []string{"asset_cid", "webp_cid"} {
+		if field := assetSchema.LookUpField(column); field == nil || field.Unique {
+			t.Errorf("%s must exist and not be unique", column)
+		}
+	}
+
+	index := assetSchema.LookIndex("uniq_assets_room_mesh_version")
+	if index == nil || index.Class != "UNIQUE" {
+		t.Fatal("missing unique index uniq_assets_room_mesh_version")
+	}
+	var columns []string
+	for _, option := range index.Fields {
+		columns = append(columns, option.DBName)
+	}
+	if fmt.Sprint(columns) != "[room_id asset_mesh_name version]" {
+		t.Errorf("got index columns %v", columns)
+	}
+}
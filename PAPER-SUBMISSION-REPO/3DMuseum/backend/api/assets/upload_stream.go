@@ -0,0 +1,126 @@
+package assets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"main/business"
+	"main/model"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Text fields are tiny; anything bigger than this is not a legitimate form value.
+const maxFormFieldSize = 64 << 10
+
+var ErrorMissingFile = errors.New("file is required")
+
+// receiveMultipartUpload walks the multipart body part by part. The "file" part is
+// spooled into the workspace under its category size limit, the other parts are
+// collected as form values so the request can still be bound with `form` tags.
+func receiveMultipartUpload(context *gin.Context, workspace *business.Workspace, input *model.DetailUploadInfor) error {
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, business.MaxUploadSize()+maxFormFieldSize)
+
+	reader, err := context.Request.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("invalid multipart request: %w", err)
+	}
+
+	values := url.Values{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read multipart body: %w", err)
+		}
+
+		if part.FormName() == "file" && part.FileName() != "" {
+			err = spoolFilePart(part, workspace, input)
+		} else if part.FormName() != "" {
+			var value []byte
+			value, err = io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			values.Add(part.FormName(), string(value))
+		}
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if input.FilePath == "" {
+		return ErrorMissingFile
+	}
+
+	// Hand the collected fields to gin's multipart binding.
+	context.Request.MultipartForm = &multipart.Form{Value: values}
+	return nil
+}
+
+func spoolFilePart(part *multipart.Part, workspace *business.Workspace, input *model.DetailUploadInfor) error {
+	// The Filename of the part contains the original filename, including the extension.
+	// e.g., "my-awesome-model.glb". This is where you populate your struct's Filename.
+	input.Filename = part.FileName()
+
+	categoryID, _, err := business.CategorizeFile(input.Filename)
+	if err != nil {
+		return err
+	}
+
+	path := workspace.Path(input.Filename)
+	size, err := business.SpoolFile(part, path, business.UploadSizeLimit(categoryID))
+	if err != nil {
+		return err
+	}
+	input.FilePath = path
+	input.Filesize = size
+
+	// Reject renamed or broken files before anything is queued, converted or pinned
+	return business.ValidateFile(path, input.Filename)
+}
+
+// uploadErrorStatus maps errors from receiving the upload body to HTTP status codes.
+func uploadErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, business.ErrUploadTooLarge) || errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, business.ErrUnsupportedFileType) {
+		return http.StatusUnsupportedMediaType
+	}
+	if status, ok := ValidationErrorStatus(err); ok {
+		return status
+	}
+	return http.StatusBadRequest
+}
+
+// ValidationErrorStatus maps a *business.ValidationError to 415 when the content is not the
+// claimed type, to 413 when decoding it would exceed the decode limits and to 422 when it is
+// the right type but malformed.
+func ValidationErrorStatus(err error) (int, bool) {
+	var validationErr *business.ValidationError
+	if !errors.As(err, &validationErr) {
+		return 0, false
+	}
+	switch validationErr.Code {
+	case business.ValidationTypeMismatch:
+		return http.StatusUnsupportedMediaType, true
+	case business.ValidationPixelLimit, business.ValidationFrameLimit, business.ValidationByteLimit:
+		return http.StatusRequestEntityTooLarge, true
+	}
+	return http.StatusUnprocessableEntity, true
+}
+
+// UploadErrorBody adds the error code to the error response so clients can tell
+// a renamed file from a corrupt one.
+func UploadErrorBody(err error) gin.H {
+	body := gin.H{"error": err.Error(), "success": false}
+	if code := business.ErrorCode(err); code != "" {
+		body["code"] = code
+	}
+	return body
+}
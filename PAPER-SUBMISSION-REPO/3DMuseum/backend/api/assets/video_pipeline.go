@@ -0,0 +1,188 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"main/business"
+	"main/model"
+	"path/filepath"
+	"sync"
+)
+
+// Segments are small, pinning a few at once hides most of the per-request latency
+const segmentPinWorkers = 4
+
+// pinnedVideo is what the HLS pipeline pinned for one video upload.
+type pinnedVideo struct {
+	MasterCID  string
+	PosterCID  string // WebP poster, used as the asset's webp fallback
+	Probe      business.VideoProbe
+	Renditions []model.AssetRendition
+	Segments   []model.AssetRendition // one per HLS segment of every variant
+}
+
+// pinVideo transcodes the video into an HLS ladder and pins it bottom-up: segments first, then
+// each variant playlist rewritten to the segment CIDs, then the master playlist rewritten to the
+// variant CIDs. Every file goes through the blob index like the other renditions. Progress goes
+// to both the room and the asset channel.
+func (s *AssetService) pinVideo(ctx context.Context, jobID string, sourcePath string, outputDir string, roomChannel string, assetChannel string) (*pinnedVideo, error) {
+	progress := func(status string, percent int, extra map[string]interface{}) {
+		for _, channel := range []string{roomChannel, assetChannel} {
+			msg := map[string]interface{}{
+				"type":     "transcode",
+				"status":   status,
+				"progress": percent,
+			}
+			for k, v := range extra {
+				msg[k] = v
+			}
+			broadcast(jobID, channel, msg)
+		}
+	}
+
+	s.setJobStatus(ctx, jobID, model.JobConverting)
+	output, err := business.TranscodeToHLS(ctx, sourcePath, outputDir, func(percent int) {
+		progress("transcoding", percent, nil)
+	})
+	if err != nil {
+		progress("failed", 0, map[string]interface{}{"error": err.Error()})
+		return nil, err
+	}
+	s.setJobStatus(ctx, jobID, model.JobPinning)
+
+	video := &pinnedVideo{Probe: output.Probe}
+	for _, poster := range []struct {
+		Label string
+		Path  string
+		Mime  string
+	}{
+		{"jpeg", output.PosterJPEG, "image/jpeg"},
+		{"webp", output.PosterWebP, "image/webp"},
+	} {
+		rendition, err := s.pinRendition(ctx, poster.Path, model.AssetRendition{Kind: model.RenditionPoster, Label: poster.Label, Mime: poster.Mime}, "")
+		if err != nil {
+			return nil, fmt.Errorf("poster upload failed: %w", err)
+		}
+		if poster.Label == "webp" {
+			video.PosterCID = rendition.CID
+		}
+		video.Renditions = append(video.Renditions, rendition)
+	}
+
+	total := 0
+	for _, variant := range output.Variants {
+		total += len(variant.Segments)
+	}
+	pinned := 0
+	variantCIDs := make(map[string]string, len(output.Variants))
+	for _, variant := range output.Variants {
+		segments, err := s.pinSegments(ctx, variant, func() {
+			pinned++
+			progress("pinning", pinned*100/total, map[string]interface{}{"pinned": pinned, "total": total})
+		})
+		if err != nil {
+			progress("failed", 0, map[string]interface{}{"error": err.Error()})
+			return nil, fmt.Errorf("%s: %w", variant.Name, err)
+		}
+		segmentCIDs := make(map[string]string, len(segments))
+		var size int64
+		for i, segment := range segments {
+			segmentCIDs[variant.Segments[i]] = segment.CID
+			size += segment.Filesize
+		}
+		video.Segments = append(video.Segments, segments...)
+		if err := business.RewritePlaylist(variant.Playlist, segmentCIDs); err != nil {
+			return nil, err
+		}
+		rendition, err := s.pinRendition(ctx, variant.Playlist, model.AssetRendition{
+			Kind:   model.RenditionHLS,
+			Label:  variant.Name,
+			Width:  variant.Width,
+			Height: variant.Height,
+			Mime:   "application/vnd.apple.mpegurl",
+		}, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s playlist upload failed: %w", variant.Name, err)
+		}
+		// What a client downloads to play the variant, not just the playlist
+		rendition.Filesize += size
+		variantCIDs[variant.Playlist] = rendition.CID
+		video.Renditions = append(video.Renditions, rendition)
+	}
+
+	if err := business.RewritePlaylist(output.Master, variantCIDs); err != nil {
+		return nil, err
+	}
+	master, size, err := openForUpload(output.Master)
+	if err != nil {
+		return nil, err
+	}
+	defer master.Close()
+	resp, _, err := s.putFile(ctx, master, size, "master.m3u8", "")
+	if err != nil {
+		return nil, fmt.Errorf("master playlist upload failed: %w", err)
+	}
+	video.MasterCID = resp.IpfsHash
+	progress("completed", 100, map[string]interface{}{"hls_cid": video.MasterCID})
+	return video, nil
+}
+
+// pinSegments pins the segment files of a variant with a few uploads in flight and returns them
+// as renditions in playlist order. done is called after every pinned segment.
+func (s *AssetService) pinSegments(ctx context.Context, variant business.HLSVariant, done func()) ([]model.AssetRendition, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	segments := make([]model.AssetRendition, len(variant.Segments))
+	slots := make(chan struct{}, segmentPinWorkers)
+	for i, segment := range variant.Segments {
+		if ctx.Err() != nil {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, segment string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			file, size, err := openForUpload(segment)
+			if err == nil {
+				var resp model.AssetStruct
+				resp, _, err = s.putFile(ctx, file, size, filepath.Base(filepath.Dir(segment))+"_"+filepath.Base(segment), "")
+				file.Close()
+				if err == nil {
+					segments[i] = model.AssetRendition{
+						Kind:     model.RenditionSegment,
+						Label:    variant.Name + "/" + filepath.Base(segment),
+						CID:      resp.IpfsHash,
+						Filesize: size,
+						Mime:     "video/mp2t",
+					}
+					mu.Lock()
+					done()
+					mu.Unlock()
+					return
+				}
+			}
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			mu.Unlock()
+		}(i, segment)
+	}
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return segments, nil
+}
@@ -0,0 +1,31 @@
+package health
+
+import (
+	"main/business"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	Storage business.Storage
+}
+
+func NewHandler(Storage business.Storage) *Handler {
+	return &Handler{Storage: Storage}
+}
+
+// GetHealth handles GET /health. The server answers 200 as long as it runs; "degraded" means the
+// storage backend is failing and uploads will be rejected until it recovers.
+func (Handler *Handler) GetHealth(context *gin.Context) {
+	storage := business.StorageHealth{Driver: Handler.Storage.Name(), Healthy: true}
+	if reporter, ok := Handler.Storage.(business.HealthReporter); ok {
+		storage = reporter.Health()
+	}
+
+	status := "ok"
+	if !storage.Healthy {
+		status = "degraded"
+	}
+	context.JSON(http.StatusOK, gin.H{"status": status, "storage": storage})
+}
@@ -0,0 +1,98 @@
+package imports
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"main/business"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	ImportService Service
+}
+
+func NewHandler(ImportService Service) *Handler {
+	return &Handler{ImportService: ImportService}
+}
+
+// ImportAssets accepts multipart/form-data with an "archive" zip of media files and an
+// optional "manifest" (.csv or .json). Without it, manifest.csv/manifest.json is read from the zip.
+// Every row is queued as an upload job, the report lists their job IDs.
+func (Handler *Handler) ImportAssets(context *gin.Context) {
+	workspace, err := business.NewWorkspace("import-archive")
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	defer workspace.Cleanup()
+
+	archivePath, manifestName, manifest, err := receiveImport(context, workspace)
+	if err != nil {
+		status := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.Is(err, business.ErrUploadTooLarge) || errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		context.JSON(status, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	report, err := Handler.ImportService.ImportArchive(context.Request.Context(), archivePath, manifestName, manifest)
+	if errors.Is(err, ErrorInvalidBatch) {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "success": false, "report": report})
+		return
+	}
+	if errors.Is(err, ErrorImportTooLarge) {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	context.JSON(http.StatusAccepted, report)
+}
+
+// receiveImport spools the archive to disk and keeps the (small) manifest in memory.
+func receiveImport(context *gin.Context, workspace *business.Workspace) (string, string, []byte, error) {
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, business.ImportArchiveSizeLimit()+maxManifestSize)
+
+	reader, err := context.Request.MultipartReader()
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid multipart request: %w", err)
+	}
+
+	var archivePath, manifestName string
+	var manifest []byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", nil, fmt.Errorf("failed to read multipart body: %w", err)
+		}
+
+		switch part.FormName() {
+		case "archive":
+			archivePath = workspace.Path("archive.zip")
+			_, err = business.SpoolFile(part, archivePath, business.ImportArchiveSizeLimit())
+		case "manifest":
+			manifestName = part.FileName()
+			manifest, err = io.ReadAll(io.LimitReader(part, maxManifestSize))
+		}
+		part.Close()
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	if archivePath == "" {
+		return "", "", nil, errors.New("archive is required")
+	}
+	return archivePath, manifestName, manifest, nil
+}
@@ -0,0 +1,121 @@
+package imports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestRow maps one media file in the archive to the fields of POST /upload.
+type ManifestRow struct {
+	Filename              string `json:"filename"`
+	MeshName              string `json:"mesh_name"`
+	Title                 string `json:"title"`
+	VietnameseDescription string `json:"vietnamese_description"`
+	EnglishDescription    string `json:"english_description"`
+	RoomID                int    `json:"roomID"`
+	EncodingProfile       string `json:"encoding_profile,omitempty"` // optional
+	NormalizeMode         string `json:"normalize_mode,omitempty"`   // optional
+	DeepZoom              bool   `json:"deep_zoom,omitempty"`        // optional
+	WebPMode              string `json:"webp_mode,omitempty"`        // optional
+}
+
+// Required CSV columns; encoding_profile, normalize_mode, deep_zoom and webp_mode may be added as optional columns.
+var manifestColumns = []string{"filename", "mesh_name", "title", "vietnamese_description", "english_description", "roomID"}
+
+// ParseManifest reads a CSV (with a header row) or a JSON array, chosen by file extension.
+// A manifest sent as a plain form field has no name and is recognised by its first character.
+func ParseManifest(name string, data []byte) ([]ManifestRow, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return parseJSONManifest(data)
+	case ".csv":
+		return parseCSVManifest(data)
+	case "":
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			return parseJSONManifest(data)
+		}
+		return parseCSVManifest(data)
+	}
+	return nil, fmt.Errorf("manifest %q must be a .csv or .json file", name)
+}
+
+func parseJSONManifest(data []byte) ([]ManifestRow, error) {
+	var rows []ManifestRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("invalid JSON manifest: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("manifest has no rows")
+	}
+	return rows, nil
+}
+
+func parseCSVManifest(data []byte) ([]ManifestRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))) // spreadsheet exports often carry a BOM
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV manifest header: %w", err)
+	}
+	index := make(map[string]int)
+	for i, column := range header {
+		index[strings.TrimSpace(column)] = i
+	}
+	for _, column := range manifestColumns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("CSV manifest is missing column %q", column)
+		}
+	}
+
+	var rows []ManifestRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV manifest: %w", err)
+		}
+		get := func(column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		roomID, err := strconv.Atoi(get("roomID"))
+		if err != nil {
+			return nil, fmt.Errorf("CSV manifest line %d: roomID %q is not a number", line, get("roomID"))
+		}
+		deepZoom := false
+		if value := get("deep_zoom"); value != "" {
+			if deepZoom, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("CSV manifest line %d: deep_zoom %q is not a boolean", line, value)
+			}
+		}
+		rows = append(rows, ManifestRow{
+			Filename:              get("filename"),
+			MeshName:              get("mesh_name"),
+			Title:                 get("title"),
+			VietnameseDescription: get("vietnamese_description"),
+			EnglishDescription:    get("english_description"),
+			RoomID:                roomID,
+			EncodingProfile:       get("encoding_profile"),
+			NormalizeMode:         get("normalize_mode"),
+			DeepZoom:              deepZoom,
+			WebPMode:              get("webp_mode"),
+		})
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("manifest has no rows")
+	}
+	return rows, nil
+}
@@ -0,0 +1,308 @@
+package imports
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"main/api/assets"
+	"main/business"
+	"main/model"
+	"main/websocket"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorInvalidBatch   = errors.New("import batch failed validation, nothing was uploaded")
+	ErrorImportTooLarge = errors.New("the files of the import batch exceed MAX_IMPORT_EXTRACTED_MB")
+)
+
+// Manifest file names looked up inside the archive when none is sent separately.
+var archiveManifestNames = []string{"manifest.csv", "manifest.json"}
+
+const maxManifestSize = 4 << 20
+
+type RowReport struct {
+	Row      int    `json:"row"`
+	Filename string `json:"filename"`
+	MeshName string `json:"mesh_name"`
+	RoomID   int    `json:"roomID"`
+	Status   string `json:"status"` // "invalid", "queued" or "failed"
+	JobID    string `json:"job_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	Success bool        `json:"success"`
+	Total   int         `json:"total"`
+	Queued  int         `json:"queued"`
+	Failed  int         `json:"failed"`
+	Rows    []RowReport `json:"rows"`
+}
+
+type Service interface {
+	// ImportArchive validates every manifest row and file against the archive before queueing
+	// anything, then queues one upload job per row; their progress is followed through the job API.
+	// manifestName/manifest may be empty when the manifest is packed inside the archive.
+	ImportArchive(ctx context.Context, archivePath string, manifestName string, manifest []byte) (*ImportReport, error)
+}
+
+type ImportService struct {
+	AssetService assets.Service
+}
+
+func NewService(AssetService assets.Service) *ImportService {
+	return &ImportService{AssetService: AssetService}
+}
+
+// extractedFile is an archive entry spooled into a workspace of its own, which the upload job
+// takes over once it is queued.
+type extractedFile struct {
+	workspace *business.Workspace
+	filename  string
+	path      string
+	size      int64
+}
+
+func (s *ImportService) ImportArchive(ctx context.Context, archivePath string, manifestName string, manifest []byte) (*ImportReport, error) {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	defer archive.Close()
+
+	if manifest == nil {
+		manifestName, manifest, err = readArchiveManifest(&archive.Reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rows, err := ParseManifest(manifestName, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Validate the whole batch up front: the manifest first, then the total size of the
+	// files, and only then their contents, which have to be extracted for that
+	entries, report := validateBatch(rows, &archive.Reader)
+	if report.Failed > 0 {
+		return report, ErrorInvalidBatch
+	}
+	var total uint64
+	for _, entry := range entries {
+		total += entry.UncompressedSize64
+	}
+	if limit := business.ImportExtractedSizeLimit(); total > uint64(limit) {
+		return nil, fmt.Errorf("%w: they add up to %d MB, the limit is %d MB", ErrorImportTooLarge, (total+1<<20-1)>>20, limit>>20)
+	}
+	files, err := extractBatch(entries, report)
+	if err != nil {
+		return report, err
+	}
+
+	// 2. Queue every row on the regular upload pipeline
+	for i, row := range rows {
+		rowReport := &report.Rows[i]
+		file := files[i]
+		job, err := s.AssetService.EnqueueUpload(ctx, model.DetailUploadInfor{
+			Filename:              file.filename,
+			MeshName:              row.MeshName,
+			Title:                 row.Title,
+			VietnameseDescription: row.VietnameseDescription,
+			EnglishDescription:    row.EnglishDescription,
+			RoomID:                row.RoomID,
+			EncodingProfile:       row.EncodingProfile,
+			NormalizeMode:         row.NormalizeMode,
+			DeepZoom:              row.DeepZoom,
+			WebPMode:              row.WebPMode,
+			FilePath:              file.path,
+			Filesize:              file.size,
+		}, file.workspace)
+		if err != nil {
+			file.workspace.Cleanup()
+			rowReport.Status = "failed"
+			rowReport.Error = err.Error()
+			report.Failed++
+		} else {
+			rowReport.Status = "queued"
+			rowReport.JobID = job.JobID
+			report.Queued++
+		}
+
+		websocket.GlobalHub.BroadcastProgress("room:"+strconv.Itoa(row.RoomID), map[string]interface{}{
+			"type":      "import",
+			"status":    rowReport.Status,
+			"row":       rowReport.Row,
+			"total":     report.Total,
+			"filename":  row.Filename,
+			"mesh_name": row.MeshName,
+			"job_id":    rowReport.JobID,
+			"error":     rowReport.Error,
+			"progress":  (i + 1) * 100 / report.Total,
+		})
+	}
+
+	report.Success = report.Failed == 0
+	return report, nil
+}
+
+// extractEntry spools an archive entry of at most limit bytes into a new workspace and checks
+// its content.
+func extractEntry(entry *zip.File, limit int64) (*extractedFile, error) {
+	workspace, err := business.NewWorkspace("import")
+	if err != nil {
+		return nil, err
+	}
+	file := &extractedFile{workspace: workspace, filename: path.Base(entry.Name)}
+	file.path = workspace.Path(file.filename)
+
+	src, err := entry.Open()
+	if err != nil {
+		workspace.Cleanup()
+		return nil, fmt.Errorf("failed to open %s in archive: %w", entry.Name, err)
+	}
+	defer src.Close()
+
+	// The declared size was checked already, the limit here guards against lying headers.
+	if file.size, err = business.SpoolFile(src, file.path, limit); err != nil {
+		workspace.Cleanup()
+		return nil, err
+	}
+	if err := business.ValidateFile(file.path, file.filename); err != nil {
+		workspace.Cleanup()
+		return nil, err
+	}
+	return file, nil
+}
+
+// extractBatch extracts and checks the entry of every row. It stops at the first entry that fails,
+// marks its row invalid and removes what was extracted so far. The caller owns the workspaces of
+// the extracted files.
+func extractBatch(entries []*zip.File, report *ImportReport) ([]*extractedFile, error) {
+	files := make([]*extractedFile, len(entries))
+	remaining := business.ImportExtractedSizeLimit()
+	for i, entry := range entries {
+		categoryID, _, err := business.CategorizeFile(entry.Name)
+		if err == nil {
+			files[i], err = extractEntry(entry, min(business.UploadSizeLimit(categoryID), remaining))
+		}
+		if err != nil {
+			for _, file := range files[:i] {
+				file.workspace.Cleanup()
+			}
+			report.Rows[i].Status = "invalid"
+			report.Rows[i].Error = err.Error()
+			report.Failed++
+			return nil, ErrorInvalidBatch
+		}
+		// Headers may understate sizes, the batch limit holds for what is actually extracted
+		remaining -= files[i].size
+	}
+	return files, nil
+}
+
+// validateBatch resolves each row to an archive entry and reports every problem of the manifest
+// it finds, so curators can fix the whole batch in one pass. Every row gets an entry of its own,
+// file contents are checked later by extractBatch.
+func validateBatch(rows []ManifestRow, archive *zip.Reader) ([]*zip.File, *ImportReport) {
+	byName := make(map[string]*zip.File)
+	byBase := make(map[string][]*zip.File)
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		byName[strings.TrimPrefix(path.Clean(f.Name), "/")] = f
+		byBase[path.Base(f.Name)] = append(byBase[path.Base(f.Name)], f)
+	}
+
+	report := &ImportReport{Total: len(rows)}
+	entries := make([]*zip.File, len(rows))
+	seenMesh := make(map[string]int)
+	seenEntry := make(map[*zip.File]int)
+
+	for i, row := range rows {
+		var problems []string
+
+		if row.Filename == "" {
+			problems = append(problems, "filename is required")
+		} else if f, ok := byName[strings.TrimPrefix(path.Clean(row.Filename), "/")]; ok {
+			entries[i] = f
+		} else if matches := byBase[path.Base(row.Filename)]; len(matches) == 1 {
+			entries[i] = matches[0]
+		} else if len(matches) > 1 {
+			problems = append(problems, fmt.Sprintf("%s matches %d files in the archive, use the full path", row.Filename, len(matches)))
+		} else {
+			problems = append(problems, fmt.Sprintf("%s is not in the archive", row.Filename))
+		}
+
+		if entry := entries[i]; entry != nil {
+			if first, ok := seenEntry[entry]; ok {
+				problems = append(problems, fmt.Sprintf("%s is already imported by row %d", entry.Name, first))
+			} else {
+				seenEntry[entry] = i + 1
+			}
+			if categoryID, _, err := business.CategorizeFile(entry.Name); err != nil {
+				problems = append(problems, err.Error())
+			} else if entry.UncompressedSize64 > uint64(business.UploadSizeLimit(categoryID)) {
+				problems = append(problems, business.ErrUploadTooLarge.Error())
+			}
+		}
+
+		if row.MeshName == "" {
+			problems = append(problems, "mesh_name is required")
+		}
+		if row.EncodingProfile != "" {
+			if _, err := business.LookupKTX2Profile(row.EncodingProfile); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		if err := business.ValidateNormalizeMode(row.NormalizeMode); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if err := business.ValidateWebPMode(row.WebPMode); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if row.RoomID <= 0 {
+			problems = append(problems, "roomID must be a positive number")
+		}
+		key := strconv.Itoa(row.RoomID) + "/" + row.MeshName
+		if first, ok := seenMesh[key]; ok && row.MeshName != "" {
+			problems = append(problems, fmt.Sprintf("mesh_name %s in room %d is already used by row %d", row.MeshName, row.RoomID, first))
+		} else {
+			seenMesh[key] = i + 1
+		}
+
+		rowReport := RowReport{Row: i + 1, Filename: row.Filename, MeshName: row.MeshName, RoomID: row.RoomID, Status: "valid"}
+		if len(problems) > 0 {
+			rowReport.Status = "invalid"
+			rowReport.Error = strings.Join(problems, "; ")
+			report.Failed++
+		}
+		report.Rows = append(report.Rows, rowReport)
+	}
+	return entries, report
+}
+
+func readArchiveManifest(archive *zip.Reader) (string, []byte, error) {
+	for _, name := range archiveManifestNames {
+		for _, f := range archive.File {
+			if !strings.EqualFold(f.Name, name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to open %s: %w", name, err)
+			}
+			defer rc.Close()
+			data, err := io.ReadAll(io.LimitReader(rc, maxManifestSize))
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			return name, data, nil
+		}
+	}
+	return "", nil, errors.New("no manifest provided and none found in archive (manifest.csv or manifest.json)")
+}
@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Handler struct {
+	JobService Service
+}
+
+func NewHandler(JobService Service) *Handler {
+	return &Handler{JobService: JobService}
+}
+
+func (Handler *Handler) GetJob(context *gin.Context) {
+	job, err := Handler.JobService.GetJob(context.Request.Context(), context.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, job)
+}
+
+// ListJobs handles GET /jobs?room=<roomID> and returns the latest jobs of that room.
+func (Handler *Handler) ListJobs(context *gin.Context) {
+	roomID, err := strconv.ParseInt(context.Query("room"), 10, 32)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room"})
+		return
+	}
+
+	jobList, err := Handler.JobService.ListJobs(context.Request.Context(), int(roomID))
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, jobList)
+}
@@ -0,0 +1,84 @@
+package jobs
+
+import (
+	"context"
+	"main/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	CreateJob(ctx context.Context, job *model.Job) error
+	UpdateJobStatus(ctx context.Context, jobID string, status string) error
+	FinishJob(ctx context.Context, jobID string, status string, assetCID string, errorMessage string, errorCode string) error
+	GetJob(ctx context.Context, jobID string) (*model.Job, error)
+	ListJobsByRoom(ctx context.Context, roomID int, limit int) ([]model.Job, error)
+	FailInterruptedJobs(ctx context.Context) (int64, error)
+}
+
+type JobRepo struct {
+	database *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *JobRepo {
+	return &JobRepo{database: db}
+}
+
+func (repo *JobRepo) CreateJob(ctx context.Context, job *model.Job) error {
+	return repo.database.WithContext(ctx).Create(job).Error
+}
+
+func (repo *JobRepo) UpdateJobStatus(ctx context.Context, jobID string, status string) error {
+	return repo.database.WithContext(ctx).Model(&model.Job{}).
+		Where("job_id = ?", jobID).
+		Updates(map[string]interface{}{"status": status, "updated_at": time.Now()}).Error
+}
+
+func (repo *JobRepo) FinishJob(ctx context.Context, jobID string, status string, assetCID string, errorMessage string, errorCode string) error {
+	now := time.Now()
+	return repo.database.WithContext(ctx).Model(&model.Job{}).
+		Where("job_id = ?", jobID).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"asset_cid":   assetCID,
+			"error":       errorMessage,
+			"error_code":  errorCode,
+			"updated_at":  now,
+			"finished_at": now,
+		}).Error
+}
+
+func (repo *JobRepo) GetJob(ctx context.Context, jobID string) (*model.Job, error) {
+	var job model.Job
+	if err := repo.database.WithContext(ctx).Where("job_id = ?", jobID).First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobsByRoom returns the most recent jobs of a room, newest first.
+func (repo *JobRepo) ListJobsByRoom(ctx context.Context, roomID int, limit int) ([]model.Job, error) {
+	var jobs []model.Job
+	err := repo.database.WithContext(ctx).
+		Where("room_id = ?", roomID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// FailInterruptedJobs marks jobs that were still in flight when the server stopped.
+// Their spooled files lived in a workspace that no longer exists, so they cannot resume.
+func (repo *JobRepo) FailInterruptedJobs(ctx context.Context) (int64, error) {
+	now := time.Now()
+	result := repo.database.WithContext(ctx).Model(&model.Job{}).
+		Where("status NOT IN ?", []string{model.JobDone, model.JobFailed}).
+		Updates(map[string]interface{}{
+			"status":      model.JobFailed,
+			"error":       "interrupted by server restart, please upload again",
+			"updated_at":  now,
+			"finished_at": now,
+		})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,157 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/business"
+	"main/model"
+	"main/websocket"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrorQueueFull = errors.New("upload queue is full, please retry later")
+
+const (
+	defaultWorkers    = 2
+	defaultQueueSize  = 100
+	defaultJobTimeout = 30 * time.Minute
+	listLimit         = 50
+)
+
+// Task is the work behind a job. It returns the asset CID on success.
+type Task func(ctx context.Context) (string, error)
+
+type Service interface {
+	// Submit persists the job as queued and schedules the task on the worker pool.
+	Submit(ctx context.Context, job *model.Job, task Task) error
+	// SetStatus records an intermediate pipeline stage (converting, pinning, persisting).
+	SetStatus(ctx context.Context, jobID string, status string) error
+	GetJob(ctx context.Context, jobID string) (*model.Job, error)
+	ListJobs(ctx context.Context, roomID int) ([]model.Job, error)
+}
+
+type queuedJob struct {
+	job  model.Job
+	task Task
+}
+
+type JobService struct {
+	JobRepo Repository
+	queue   chan queuedJob
+	timeout time.Duration
+}
+
+// NewService starts UPLOAD_WORKERS workers (default 2) fed by a queue of
+// UPLOAD_QUEUE_SIZE jobs (default 100). Each job may run for UPLOAD_JOB_TIMEOUT_MINUTES.
+func NewService(JobRepo Repository) *JobService {
+	s := &JobService{
+		JobRepo: JobRepo,
+		queue:   make(chan queuedJob, envInt("UPLOAD_QUEUE_SIZE", defaultQueueSize)),
+		timeout: Timeout(),
+	}
+
+	if n, err := JobRepo.FailInterruptedJobs(context.Background()); err != nil {
+		fmt.Printf("[WARN] failed to clean up interrupted jobs: %v\n", err)
+	} else if n > 0 {
+		fmt.Printf("[INFO] marked %d interrupted upload jobs as failed\n", n)
+	}
+
+	for i := 0; i < envInt("UPLOAD_WORKERS", defaultWorkers); i++ {
+		go s.worker()
+	}
+	return s
+}
+
+func (s *JobService) Submit(ctx context.Context, job *model.Job, task Task) error {
+	job.Status = model.JobQueued
+	if err := s.JobRepo.CreateJob(ctx, job); err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+
+	select {
+	case s.queue <- queuedJob{job: *job, task: task}:
+		s.broadcast(job.RoomID, job.JobID, model.JobQueued, nil)
+		return nil
+	default:
+		_ = s.JobRepo.FinishJob(ctx, job.JobID, model.JobFailed, "", ErrorQueueFull.Error(), "")
+		return ErrorQueueFull
+	}
+}
+
+func (s *JobService) SetStatus(ctx context.Context, jobID string, status string) error {
+	return s.JobRepo.UpdateJobStatus(ctx, jobID, status)
+}
+
+func (s *JobService) GetJob(ctx context.Context, jobID string) (*model.Job, error) {
+	return s.JobRepo.GetJob(ctx, jobID)
+}
+
+func (s *JobService) ListJobs(ctx context.Context, roomID int) ([]model.Job, error) {
+	return s.JobRepo.ListJobsByRoom(ctx, roomID, listLimit)
+}
+
+func (s *JobService) worker() {
+	for queued := range s.queue {
+		s.run(queued)
+	}
+}
+
+// run executes one job detached from the HTTP request that created it.
+func (s *JobService) run(queued queuedJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	job := queued.job
+	assetCID, err := func() (cid string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("upload job panicked: %v", r)
+			}
+		}()
+		return queued.task(ctx)
+	}()
+
+	// Record the outcome even if the job context has already expired.
+	if err != nil {
+		fmt.Printf("[WARN] upload job %s failed: %v\n", job.JobID, err)
+		code := business.ErrorCode(err)
+		_ = s.JobRepo.FinishJob(context.Background(), job.JobID, model.JobFailed, "", err.Error(), code)
+		extra := map[string]interface{}{"error": err.Error()}
+		if code != "" {
+			extra["code"] = code
+		}
+		s.broadcast(job.RoomID, job.JobID, model.JobFailed, extra)
+		return
+	}
+	_ = s.JobRepo.FinishJob(context.Background(), job.JobID, model.JobDone, assetCID, "", "")
+	s.broadcast(job.RoomID, job.JobID, model.JobDone, map[string]interface{}{"asset_cid": assetCID})
+}
+
+func (s *JobService) broadcast(roomID uint, jobID string, status string, extra map[string]interface{}) {
+	msg := map[string]interface{}{
+		"type":   "job",
+		"job_id": jobID,
+		"status": status,
+	}
+	for k, v := range extra {
+		msg[k] = v
+	}
+	websocket.GlobalHub.BroadcastProgress("room:"+strconv.Itoa(int(roomID)), msg)
+}
+
+// Timeout is how long a job may run, UPLOAD_JOB_TIMEOUT_MINUTES (default 30).
+func Timeout() time.Duration {
+	return time.Duration(envInt("UPLOAD_JOB_TIMEOUT_MINUTES", int(defaultJobTimeout/time.Minute))) * time.Minute
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,242 @@
+package media
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheMB     = 1024
+	defaultMaxObjectMB = 256
+)
+
+// cacheEntry is what is known about a cached object, kept next to it as <key>.json.
+type cacheEntry struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// Cache keeps fetched objects on disk and evicts the least recently served ones once it
+// outgrows its budget. Objects are immutable, so entries never need revalidation.
+type Cache struct {
+	dir            string
+	maxBytes       int64
+	maxObjectBytes int64
+
+	mu       sync.Mutex
+	size     int64
+	lru      *list.List // of *cacheEntry, most recently served first
+	entries  map[string]*list.Element
+	inflight map[string]chan struct{} // keys being fetched, closed when done
+}
+
+// NewCache opens the cache in MEDIA_CACHE_DIR (default <tmp>/museum-media-cache) holding up to
+// MEDIA_CACHE_MB megabytes (default 1024), picking up what a previous run left there. Objects over
+// MEDIA_CACHE_MAX_OBJECT_MB (default 256, at most the whole cache) are not cached.
+func NewCache() (*Cache, error) {
+	dir := os.Getenv("MEDIA_CACHE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "museum-media-cache")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media cache dir: %w", err)
+	}
+
+	maxMB := envMB("MEDIA_CACHE_MB", defaultCacheMB)
+	maxObjectMB := min(envMB("MEDIA_CACHE_MAX_OBJECT_MB", defaultMaxObjectMB), maxMB)
+
+	cache := &Cache{
+		dir:            dir,
+		maxBytes:       int64(maxMB) << 20,
+		maxObjectBytes: int64(maxObjectMB) << 20,
+		lru:            list.New(),
+		entries:        make(map[string]*list.Element),
+		inflight:       make(map[string]chan struct{}),
+	}
+	cache.load()
+	return cache, nil
+}
+
+func envMB(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// Cacheable reports whether an object of size bytes is kept in the cache, unknown sizes (-1) are.
+func (c *Cache) Cacheable(size int64) bool {
+	return size <= c.maxObjectBytes
+}
+
+func (c *Cache) dataPath(key string) string { return filepath.Join(c.dir, key) }
+func (c *Cache) metaPath(key string) string { return filepath.Join(c.dir, key+".json") }
+
+// load indexes the entries left on disk, ordered by when they were last served, and removes
+// temporary files of fetches that never finished.
+func (c *Cache) load() {
+	if partials, err := filepath.Glob(filepath.Join(c.dir, ".fetch-*")); err == nil {
+		for _, partial := range partials {
+			os.Remove(partial)
+		}
+	}
+	metas, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return
+	}
+
+	type loaded struct {
+		entry    *cacheEntry
+		lastUsed time.Time
+	}
+	var found []loaded
+	for _, metaPath := range metas {
+		key := strings.TrimSuffix(filepath.Base(metaPath), ".json")
+		data, err := os.ReadFile(metaPath)
+		var entry cacheEntry
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		info, statErr := os.Stat(c.dataPath(key))
+		if err != nil || statErr != nil || entry.Key != key || info.Size() != entry.Size {
+			c.remove(key)
+			continue
+		}
+		found = append(found, loaded{entry: &entry, lastUsed: info.ModTime()})
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].lastUsed.After(found[j].lastUsed) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range found {
+		c.entries[item.entry.Key] = c.lru.PushBack(item.entry)
+		c.size += item.entry.Size
+	}
+	c.evict()
+}
+
+// Open returns the cached object under key, nil when it is not cached.
+func (c *Cache) Open(key string) (*os.File, *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	// Eviction only unlinks the file, an object already opened can still be served in full
+	file, err := os.Open(c.dataPath(key))
+	if err != nil {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		c.size -= element.Value.(*cacheEntry).Size
+		return nil, nil
+	}
+	c.lru.MoveToFront(element)
+	// The modification time records the last use across restarts
+	now := time.Now()
+	_ = os.Chtimes(c.dataPath(key), now, now)
+	return file, element.Value.(*cacheEntry)
+}
+
+// Fill fetches the object under key into the cache and opens it. fetch writes the object to w
+// and describes it. Concurrent fills of one key fetch it once.
+func (c *Cache) Fill(key string, fetch func(w io.Writer) (cacheEntry, error)) (*os.File, *cacheEntry, error) {
+	for {
+		if file, entry := c.Open(key); file != nil {
+			return file, entry, nil
+		}
+
+		c.mu.Lock()
+		done, busy := c.inflight[key]
+		if !busy {
+			done = make(chan struct{})
+			c.inflight[key] = done
+		}
+		c.mu.Unlock()
+		if !busy {
+			break
+		}
+		// Another request is fetching it; if that fails this one fetches it itself
+		<-done
+		if file, entry := c.Open(key); file != nil {
+			return file, entry, nil
+		}
+	}
+
+	defer func() {
+		c.mu.Lock()
+		close(c.inflight[key])
+		delete(c.inflight, key)
+		c.mu.Unlock()
+	}()
+
+	temp, err := os.CreateTemp(c.dir, ".fetch-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	entry, err := fetch(temp)
+	if err == nil {
+		entry.Size, err = temp.Seek(0, io.SeekCurrent)
+	}
+	temp.Close()
+	if err != nil {
+		os.Remove(temp.Name())
+		return nil, nil, err
+	}
+	entry.Key = key
+
+	meta, err := json.Marshal(entry)
+	if err == nil {
+		err = os.WriteFile(c.metaPath(key), meta, 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), c.dataPath(key))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		os.Remove(c.metaPath(key))
+		return nil, nil, fmt.Errorf("failed to store cache entry: %w", err)
+	}
+
+	file, err := os.Open(c.dataPath(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	c.entries[key] = c.lru.PushFront(&entry)
+	c.size += entry.Size
+	// An object bigger than the whole cache is served from the open file and dropped right away
+	c.evict()
+	c.mu.Unlock()
+	return file, &entry, nil
+}
+
+// evict drops least recently served entries until the cache fits its budget. c.mu must be held.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		element := c.lru.Back()
+		entry := element.Value.(*cacheEntry)
+		c.lru.Remove(element)
+		delete(c.entries, entry.Key)
+		c.size -= entry.Size
+		c.remove(entry.Key)
+	}
+}
+
+func (c *Cache) remove(key string) {
+	os.Remove(c.dataPath(key))
+	os.Remove(c.metaPath(key))
+}
@@ -0,0 +1,50 @@
+package media
+
+import (
+	"errors"
+	"main/business"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	MediaService Service
+}
+
+func NewHandler(MediaService Service) *Handler {
+	return &Handler{MediaService: MediaService}
+}
+
+// GetMedia handles GET /media/:cid and GET /media/:cid/*path, streaming a stored file from the
+// configured storage driver. Content is addressed by CID and never changes, so clients and
+// proxies may cache it forever. Range and If-None-Match requests are answered from the local cache,
+// or for objects too big for it by fetching only the requested range.
+func (Handler *Handler) GetMedia(context *gin.Context) {
+	cid := context.Param("cid")
+	filePath := strings.TrimPrefix(context.Param("path"), "/")
+
+	media, err := Handler.MediaService.Open(context.Request.Context(), cid, filePath)
+	switch {
+	case errors.Is(err, ErrInvalidMedia):
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	case errors.Is(err, business.ErrObjectNotFound):
+		context.JSON(http.StatusNotFound, gin.H{"error": "Media not found", "success": false})
+		return
+	case err != nil:
+		context.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	defer media.Content.Close()
+
+	header := context.Writer.Header()
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	header.Set("ETag", `"`+media.ETag+`"`)
+	if media.ContentType != "" {
+		header.Set("Content-Type", media.ContentType)
+	}
+	media.ExpectRange(context.GetHeader("Range"))
+	http.ServeContent(context.Writer, context.Request, media.Name, media.ModTime, media.Content)
+}
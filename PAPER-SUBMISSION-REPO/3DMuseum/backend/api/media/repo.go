@@ -0,0 +1,33 @@
+package media
+
+import (
+	"context"
+	"main/model"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	// FindMime returns the content type a CID was stored with, empty when it is not in the blob index.
+	FindMime(ctx context.Context, cid string) (string, error)
+}
+
+type MediaRepo struct {
+	database *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *MediaRepo {
+	return &MediaRepo{database: db}
+}
+
+func (repo *MediaRepo) FindMime(ctx context.Context, cid string) (string, error) {
+	var mimes []string
+	err := repo.database.WithContext(ctx).Model(&model.Blob{}).
+		Where("cid = ?", cid).
+		Limit(1).
+		Pluck("mime", &mimes).Error
+	if err != nil || len(mimes) == 0 {
+		return "", err
+	}
+	return mimes[0], nil
+}
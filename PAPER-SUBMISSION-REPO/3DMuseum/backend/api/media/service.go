@@ -0,0 +1,144 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"main/business"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMedia = errors.New("invalid media CID or path")
+
+// CIDs of every driver are alphanumeric: base58/base32 CIDs and hex SHA-256 IDs
+var cidPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,128}$`)
+
+// Media is a stored object opened for serving, the caller closes Content.
+type Media struct {
+	Content     io.ReadSeekCloser
+	Name        string
+	ETag        string
+	ContentType string // empty when it has to be sniffed
+	ModTime     time.Time
+}
+
+// ExpectRange tells a streamed object which bytes the request asks for, so only those are
+// fetched from storage. Call it with the Range header before serving Content.
+func (m *Media) ExpectRange(header string) {
+	if remote, ok := m.Content.(*remoteObject); ok {
+		remote.expectRange(header)
+	}
+}
+
+type Service interface {
+	// Open returns a stored file, path selects a file inside a stored directory.
+	Open(ctx context.Context, cid string, path string) (*Media, error)
+}
+
+type MediaService struct {
+	MediaRepo Repository
+	Storage   business.Storage
+	Cache     *Cache
+}
+
+// Path is where GET /media serves cid, or the file at filePath inside the directory cid.
+func Path(cid string, filePath string) string {
+	if filePath == "" {
+		return "/media/" + cid
+	}
+	return "/media/" + cid + "/" + strings.TrimPrefix(filePath, "/")
+}
+
+func NewService(MediaRepo Repository, Storage business.Storage, Cache *Cache) *MediaService {
+	return &MediaService{MediaRepo: MediaRepo, Storage: Storage, Cache: Cache}
+}
+
+func (s *MediaService) Open(ctx context.Context, cid string, filePath string) (*Media, error) {
+	if !cidPattern.MatchString(cid) {
+		return nil, ErrInvalidMedia
+	}
+	if filePath != "" {
+		cleaned := strings.TrimPrefix(path.Clean("/"+filePath), "/")
+		if cleaned != filePath {
+			return nil, ErrInvalidMedia
+		}
+	}
+
+	etag := cid
+	name := cid
+	if filePath != "" {
+		etag = cid + "/" + filePath
+		name = path.Base(filePath)
+	}
+
+	// The same CID means the same bytes on every driver that could produce it
+	key := business.HashTextSHA256(s.Storage.Name() + "\x00" + cid + "\x00" + filePath)
+	if file, entry := s.Cache.Open(key); file != nil {
+		return &Media{Content: file, Name: name, ETag: etag, ContentType: entry.ContentType, ModTime: entry.ModTime}, nil
+	}
+
+	// Big objects, videos mostly, are streamed: a Range request fetches only its range and the
+	// cache is not flushed by an object that would be evicted again right away
+	object, err := s.Storage.Stat(ctx, cid, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Cache.Cacheable(object.Size) {
+		content := &remoteObject{
+			size: object.Size,
+			end:  object.Size,
+			open: func(offset int64, length int64) (io.ReadCloser, error) {
+				return s.Storage.GetRange(ctx, cid, filePath, offset, length)
+			},
+		}
+		contentType := s.contentType(ctx, cid, filePath, object.ContentType)
+		if contentType == "" {
+			contentType = content.sniffContentType()
+		}
+		return &Media{Content: content, Name: name, ETag: etag, ContentType: contentType, ModTime: object.ModTime}, nil
+	}
+
+	file, entry, err := s.Cache.Fill(key, func(w io.Writer) (cacheEntry, error) {
+		body, object, err := s.Storage.Get(ctx, cid, filePath)
+		if err != nil {
+			return cacheEntry{}, err
+		}
+		defer body.Close()
+		if _, err := io.Copy(w, body); err != nil {
+			return cacheEntry{}, fmt.Errorf("failed to fetch %s: %w", cid, err)
+		}
+		return cacheEntry{ContentType: s.contentType(ctx, cid, filePath, object.ContentType), ModTime: object.ModTime}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Media{Content: file, Name: name, ETag: etag, ContentType: entry.ContentType, ModTime: entry.ModTime}, nil
+}
+
+// contentType prefers what the storage reported, unless it is a generic fallback: gateways serve a
+// KTX2 addressed by bare CID as octet-stream. The file name and then the blob index decide instead.
+func (s *MediaService) contentType(ctx context.Context, cid string, filePath string, reported string) string {
+	mediaType, _, _ := strings.Cut(reported, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "", "application/octet-stream", "text/plain":
+	default:
+		return reported
+	}
+
+	if path.Ext(filePath) != "" {
+		return business.MimeType(filePath)
+	}
+	if filePath == "" {
+		if mime, err := s.MediaRepo.FindMime(ctx, cid); err != nil {
+			fmt.Printf("[WARN] failed to look up the content type of %s: %v\n", cid, err)
+		} else if mime != "" {
+			return mime
+		}
+	}
+	// http.ServeContent sniffs it from the first bytes
+	return ""
+}
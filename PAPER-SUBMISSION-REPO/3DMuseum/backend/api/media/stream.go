@@ -0,0 +1,113 @@
+package media
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// remoteObject serves an object too big for the cache straight from storage. Seeking is free,
+// the next read opens a ranged stream from there, so http.ServeContent answers Range requests
+// by fetching only the requested bytes.
+type remoteObject struct {
+	open   func(offset int64, length int64) (io.ReadCloser, error)
+	size   int64
+	offset int64
+	end    int64 // where the requested range ends, size when the whole object was asked for
+
+	body       io.ReadCloser // open stream, positioned at bodyOffset and ending at bodyEnd
+	bodyOffset int64
+	bodyEnd    int64
+}
+
+func (o *remoteObject) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body != nil && o.bodyOffset != o.offset {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.body == nil {
+		// Storage sends no more than the range needs, a read past it fetches the rest
+		end := o.size
+		if o.offset < o.end {
+			end = o.end
+		}
+		body, err := o.open(o.offset, end-o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body, o.bodyOffset, o.bodyEnd = body, o.offset, end
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset = o.offset
+	if err == io.EOF {
+		o.body.Close()
+		o.body = nil
+		switch {
+		case o.offset < o.bodyEnd:
+			err = io.ErrUnexpectedEOF
+		case o.offset < o.size && n == 0:
+			// The requested range is done, the rest of the object comes from a new stream
+			return o.Read(p)
+		case o.offset < o.size:
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (o *remoteObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the object")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *remoteObject) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+// expectRange sets end from a single-range Range header, multiple ranges fetch to the end.
+func (o *remoteObject) expectRange(header string) {
+	o.end = o.size
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok || first == "" {
+		// A suffix range ends with the object
+		return
+	}
+	if end, err := strconv.ParseInt(last, 10, 64); err == nil && end >= 0 && end < o.size {
+		o.end = end + 1
+	}
+}
+
+// sniffContentType detects the type from the first bytes as http.ServeContent would, but with
+// a bounded read instead of a stream that ServeContent abandons after 512 bytes.
+func (o *remoteObject) sniffContentType() string {
+	body, err := o.open(0, min(o.size, 512))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer body.Close()
+	head, _ := io.ReadAll(io.LimitReader(body, 512))
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,90 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeService serves one remoteObject over data, typed by sniffing as Open does when storage
+// cannot name the type, and records every ranged read.
+type fakeService struct {
+	data  []byte
+	reads [][2]int64
+}
+
+func (s *fakeService) object() *remoteObject {
+	size := int64(len(s.data))
+	return &remoteObject{size: size, end: size, open: func(offset int64, length int64) (io.ReadCloser, error) {
+		s.reads = append(s.reads, [2]int64{offset, length})
+		return io.NopCloser(bytes.NewReader(s.data[offset : offset+length])), nil
+	}}
+}
+
+func (s *fakeService) Open(ctx context.Context, cid string, path string) (*Media, error) {
+	content := s.object()
+	return &Media{Content: content, Name: cid, ETag: cid, ContentType: content.sniffContentType(), ModTime: time.Unix(0, 0)}, nil
+}
+
+func TestRemoteObjectFetchesOnlyTheRange(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	tests := []struct {
+		name      string
+		rangeSpec string
+		status    int
+		body      []byte
+		read      [2]int64
+	}{
+		{"whole object", "", http.StatusOK, data, [2]int64{0, 10000}},
+		{"closed range", "bytes=100-199", http.StatusPartialContent, data[100:200], [2]int64{100, 100}},
+		{"open range", "bytes=9000-", http.StatusPartialContent, data[9000:], [2]int64{9000, 1000}},
+		{"suffix range", "bytes=-500", http.StatusPartialContent, data[9500:], [2]int64{9500, 500}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &fakeService{data: data}
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.GET("/media/:cid", NewHandler(service).GetMedia)
+
+			req := httptest.NewRequest(http.MethodGet, "/media/cid", nil)
+			if test.rangeSpec != "" {
+				req.Header.Set("Range", test.rangeSpec)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.status || !bytes.Equal(w.Body.Bytes(), test.body) {
+				t.Fatalf("got %d with %d bytes, want %d with %d bytes", w.Code, w.Body.Len(), test.status, len(test.body))
+			}
+			// The sniff, then the range itself
+			if want := [][2]int64{{0, 512}, test.read}; len(service.reads) != 2 || service.reads[1] != want[1] || service.reads[0] != want[0] {
+				t.Errorf("storage reads %v, want %v", service.reads, want)
+			}
+		})
+	}
+}
+
+func TestRemoteObjectReadsPastTheRange(t *testing.T) {
+	service := &fakeService{data: []byte("0123456789")}
+	object := service.object()
+	object.expectRange("bytes=2-4")
+	object.Seek(2, io.SeekStart)
+
+	got, err := io.ReadAll(object)
+	if err != nil || string(got) != "23456789" {
+		t.Fatalf("ReadAll = %q, %v", got, err)
+	}
+	if want := [][2]int64{{2, 3}, {5, 5}}; len(service.reads) != 2 || service.reads[0] != want[0] || service.reads[1] != want[1] {
+		t.Errorf("storage reads %v, want %v", service.reads, want)
+	}
+}
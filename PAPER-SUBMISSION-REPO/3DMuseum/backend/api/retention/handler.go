@@ -0,0 +1,109 @@
+package retention
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Handler struct {
+	RetentionService Service
+}
+
+func NewHandler(RetentionService Service) *Handler {
+	return &Handler{RetentionService: RetentionService}
+}
+
+// GetPolicy handles GET /retention/rooms/:roomID.
+func (Handler *Handler) GetPolicy(context *gin.Context) {
+	roomID, err := strconv.Atoi(context.Param("roomID"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room"})
+		return
+	}
+
+	policy, err := Handler.RetentionService.GetPolicy(context.Request.Context(), roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, policy)
+}
+
+// SetPolicy handles PUT /retention/rooms/:roomID with {"keep_versions": 3, "keep_days": 30};
+// null or a missing field removes that rule.
+func (Handler *Handler) SetPolicy(context *gin.Context) {
+	roomID, err := strconv.Atoi(context.Param("roomID"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room"})
+		return
+	}
+	var policy Policy
+	if err := context.ShouldBindJSON(&policy); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = Handler.RetentionService.SetPolicy(context.Request.Context(), roomID, policy)
+	switch {
+	case errors.Is(err, ErrInvalidPolicy):
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, policy)
+}
+
+// Run handles POST /retention/run?room=<roomID>&dry_run=false. Without a room every room with
+// a policy is processed. Runs are dry unless dry_run=false: the report lists what would be unpinned.
+func (Handler *Handler) Run(context *gin.Context) {
+	roomID := 0
+	if room := context.Query("room"); room != "" {
+		id, err := strconv.Atoi(room)
+		if err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room"})
+			return
+		}
+		roomID = id
+	}
+	dryRun := context.Query("dry_run") != "false"
+
+	reports, err := Handler.RetentionService.Run(context.Request.Context(), roomID, dryRun)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "reports": reports})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"dry_run": dryRun, "reports": reports})
+}
+
+// ListAudit handles GET /retention/audit?room=<roomID> and returns what was unpinned in that room.
+func (Handler *Handler) ListAudit(context *gin.Context) {
+	roomID, err := strconv.Atoi(context.Query("room"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room"})
+		return
+	}
+
+	entries, err := Handler.RetentionService.ListAudit(context.Request.Context(), roomID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,186 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"main/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	GetRoom(ctx context.Context, roomID int) (*model.Room, error)
+	SetPolicy(ctx context.Context, roomID int, policy Policy) error
+	ListRoomsWithPolicy(ctx context.Context) ([]model.Room, error)
+	ListVersions(ctx context.Context, roomID int) ([]model.Asset, error)
+	ListRenditions(ctx context.Context, assetCIDs []string) ([]model.AssetRendition, error)
+	ListAudios(ctx context.Context, assetCIDs []string) ([]model.Audio, error)
+	SharedAssetCIDs(ctx context.Context, assetCIDs []string, prunedIDs []uint) ([]string, error)
+	IsReferenced(ctx context.Context, cid string, prunedIDs []uint, prunedCIDs []string, claimedSince time.Time) (bool, error)
+	DeleteVersion(ctx context.Context, asset model.Asset, shared bool) error
+	ForgetBlobs(ctx context.Context, cid string) error
+	SaveAudit(ctx context.Context, entries []model.RetentionAudit) error
+	ListAudit(ctx context.Context, roomID int, limit int) ([]model.RetentionAudit, error)
+}
+
+type RetentionRepo struct {
+	database *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *RetentionRepo {
+	return &RetentionRepo{database: db}
+}
+
+func (repo *RetentionRepo) GetRoom(ctx context.Context, roomID int) (*model.Room, error) {
+	var room model.Room
+	if err := repo.database.WithContext(ctx).Where("room_id = ?", roomID).First(&room).Error; err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (repo *RetentionRepo) SetPolicy(ctx context.Context, roomID int, policy Policy) error {
+	result := repo.database.WithContext(ctx).Model(&model.Room{}).
+		Where("room_id = ?", roomID).
+		Updates(map[string]interface{}{
+			"retain_versions": policy.KeepVersions,
+			"retain_days":     policy.KeepDays,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (repo *RetentionRepo) ListRoomsWithPolicy(ctx context.Context) ([]model.Room, error) {
+	var rooms []model.Room
+	err := repo.database.WithContext(ctx).
+		Where("retain_versions IS NOT NULL OR retain_days IS NOT NULL").
+		Order("room_id").
+		Find(&rooms).Error
+	return rooms, err
+}
+
+// ListVersions returns every version of every mesh in the room, latest version of a mesh first.
+func (repo *RetentionRepo) ListVersions(ctx context.Context, roomID int) ([]model.Asset, error) {
+	var assets []model.Asset
+	err := repo.database.WithContext(ctx).
+		Where("room_id = ?", roomID).
+		Order("asset_mesh_name, version DESC").
+		Find(&assets).Error
+	return assets, err
+}
+
+func (repo *RetentionRepo) ListRenditions(ctx context.Context, assetCIDs []string) ([]model.AssetRendition, error) {
+	var renditions []model.AssetRendition
+	err := repo.database.WithContext(ctx).Where("asset_cid IN ?", assetCIDs).Find(&renditions).Error
+	return renditions, err
+}
+
+func (repo *RetentionRepo) ListAudios(ctx context.Context, assetCIDs []string) ([]model.Audio, error) {
+	var audios []model.Audio
+	err := repo.database.WithContext(ctx).
+		Where("asset_cid IN ? AND audio_cid IS NOT NULL AND audio_cid <> ''", assetCIDs).
+		Find(&audios).Error
+	return audios, err
+}
+
+// SharedAssetCIDs returns which of assetCIDs are also the CID of an asset that is not being pruned,
+// e.g. another mesh uploaded from the same file.
+func (repo *RetentionRepo) SharedAssetCIDs(ctx context.Context, assetCIDs []string, prunedIDs []uint) ([]string, error) {
+	var shared []string
+	err := repo.database.WithContext(ctx).Model(&model.Asset{}).
+		Where("asset_cid IN ? AND asset_id NOT IN ?", assetCIDs, prunedIDs).
+		Distinct().
+		Pluck("asset_cid", &shared).Error
+	return shared, err
+}
+
+// IsReferenced reports whether anything but the versions being pruned still points at cid,
+// in any room: another asset, a rendition or a narration reused through its text hash, or an
+// upload that claimed its blob after claimedSince and may not have saved its asset yet.
+// prunedCIDs are the asset CIDs whose renditions and narrations go with the pruned versions.
+func (repo *RetentionRepo) IsReferenced(ctx context.Context, cid string, prunedIDs []uint, prunedCIDs []string, claimedSince time.Time) (bool, error) {
+	db := repo.database.WithContext(ctx)
+
+	var count int64
+	err := db.Model(&model.Blob{}).Where("cid = ? AND last_used_at > ?", cid, claimedSince).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	// tile_manifest is stored as compact JSON by the serializer
+	err = db.Model(&model.Asset{}).
+		Where("asset_id NOT IN ?", prunedIDs).
+		Where("asset_cid = ? OR webp_cid = ? OR hls_cid = ? OR tile_manifest LIKE ?", cid, cid, cid, `%"cid":"`+cid+`"%`).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	// NOT IN on an empty list matches nothing in SQL
+	renditions := db.Model(&model.AssetRendition{}).Where("cid = ?", cid)
+	audios := db.Model(&model.Audio{}).Where("audio_cid = ?", cid)
+	if len(prunedCIDs) > 0 {
+		renditions = renditions.Where("asset_cid NOT IN ?", prunedCIDs)
+		audios = audios.Where("asset_cid NOT IN ?", prunedCIDs)
+	}
+
+	err = renditions.Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	err = audios.Count(&count).Error
+	return count > 0, err
+}
+
+// DeleteVersion removes a superseded version with its renditions and narrations. When its asset CID
+// is shared with an asset that stays, the renditions and narrations stay with that asset.
+func (repo *RetentionRepo) DeleteVersion(ctx context.Context, asset model.Asset, shared bool) error {
+	return repo.database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if shared {
+			if err := tx.Where("asset_id = ?", asset.AID).Delete(&model.Asset{}).Error; err != nil {
+				return fmt.Errorf("failed to delete asset version: %w", err)
+			}
+			return nil
+		}
+		if err := tx.Where("asset_cid = ?", asset.AssetCID).Delete(&model.AssetRendition{}).Error; err != nil {
+			return fmt.Errorf("failed to delete renditions: %w", err)
+		}
+		if err := tx.Where("asset_cid = ?", asset.AssetCID).Delete(&model.Audio{}).Error; err != nil {
+			return fmt.Errorf("failed to delete audios: %w", err)
+		}
+		if err := tx.Where("asset_id = ?", asset.AID).Delete(&model.Asset{}).Error; err != nil {
+			return fmt.Errorf("failed to delete asset version: %w", err)
+		}
+		return nil
+	})
+}
+
+// ForgetBlobs drops a removed CID from the blob index, so uploads of the same content store it again.
+func (repo *RetentionRepo) ForgetBlobs(ctx context.Context, cid string) error {
+	return repo.database.WithContext(ctx).Where("cid = ?", cid).Delete(&model.Blob{}).Error
+}
+
+func (repo *RetentionRepo) SaveAudit(ctx context.Context, entries []model.RetentionAudit) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return repo.database.WithContext(ctx).Create(&entries).Error
+}
+
+// ListAudit returns the most recent audit entries of a room, newest first.
+func (repo *RetentionRepo) ListAudit(ctx context.Context, roomID int, limit int) ([]model.RetentionAudit, error) {
+	var entries []model.RetentionAudit
+	err := repo.database.WithContext(ctx).
+		Where("room_id = ?", roomID).
+		Order("created_at DESC, audit_id DESC").
+		Limit(limit).
+		Find(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,356 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/api/jobs"
+	"main/business"
+	"main/model"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrInvalidPolicy = errors.New("retention values must not be negative")
+
+const (
+	defaultIntervalHours = 24
+	auditLimit           = 200
+)
+
+// Policy decides which superseded versions of a mesh a room keeps; the latest version is always kept.
+// A superseded version is kept while either rule keeps it, a nil rule keeps nothing and a room with
+// no rule at all keeps everything.
+type Policy struct {
+	KeepVersions *int `json:"keep_versions"` // newest superseded versions kept per mesh
+	KeepDays     *int `json:"keep_days"`     // days a version is kept after it was superseded
+}
+
+func (p Policy) enabled() bool {
+	return p.KeepVersions != nil || p.KeepDays != nil
+}
+
+// keeps reports whether the rank-th newest superseded version (from 1), superseded at supersededAt, is kept.
+func (p Policy) keeps(rank int, supersededAt time.Time, now time.Time) bool {
+	if p.KeepVersions != nil && rank <= *p.KeepVersions {
+		return true
+	}
+	if p.KeepDays != nil && now.Sub(supersededAt) < time.Duration(*p.KeepDays)*24*time.Hour {
+		return true
+	}
+	return false
+}
+
+// Report is what a retention run of one room removed, or would remove on a dry run.
+type Report struct {
+	RunID      string          `json:"run_id,omitempty"`
+	RoomID     int             `json:"room_id"`
+	DryRun     bool            `json:"dry_run"`
+	Policy     Policy          `json:"policy"`
+	Versions   []PrunedVersion `json:"versions"`
+	Unpinned   int             `json:"unpinned"`   // CIDs removed from storage
+	Failed     int             `json:"failed"`     // CIDs storage refused to remove, retried next run
+	Referenced int             `json:"referenced"` // CIDs kept because a live row still uses them
+}
+
+// PrunedVersion is a superseded version that fell out of the policy.
+type PrunedVersion struct {
+	AssetID      uint        `json:"asset_id"`
+	MeshName     string      `json:"mesh_name"`
+	Version      int         `json:"version"`
+	CreatedAt    time.Time   `json:"created_at"`
+	SupersededAt time.Time   `json:"superseded_at"`
+	Removed      bool        `json:"removed"` // row deleted; false on dry runs and when a CID failed to unpin
+	CIDs         []PrunedCID `json:"cids"`
+}
+
+type PrunedCID struct {
+	CID        string `json:"cid"`
+	Kind       string `json:"kind"`
+	Referenced bool   `json:"referenced,omitempty"`
+	Unpinned   bool   `json:"unpinned,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type Service interface {
+	GetPolicy(ctx context.Context, roomID int) (Policy, error)
+	SetPolicy(ctx context.Context, roomID int, policy Policy) error
+	// Run applies the policy of one room, or of every room that has one when roomID is 0.
+	Run(ctx context.Context, roomID int, dryRun bool) ([]Report, error)
+	ListAudit(ctx context.Context, roomID int) ([]model.RetentionAudit, error)
+}
+
+type RetentionService struct {
+	RetentionRepo Repository
+	Storage       business.Storage
+	// Runs are serialized, two of them would race to unpin the same CIDs
+	mu sync.Mutex
+}
+
+func NewService(RetentionRepo Repository, Storage business.Storage) *RetentionService {
+	return &RetentionService{RetentionRepo: RetentionRepo, Storage: Storage}
+}
+
+func (s *RetentionService) GetPolicy(ctx context.Context, roomID int) (Policy, error) {
+	room, err := s.RetentionRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return Policy{}, err
+	}
+	return roomPolicy(*room), nil
+}
+
+func (s *RetentionService) SetPolicy(ctx context.Context, roomID int, policy Policy) error {
+	if (policy.KeepVersions != nil && *policy.KeepVersions < 0) || (policy.KeepDays != nil && *policy.KeepDays < 0) {
+		return ErrInvalidPolicy
+	}
+	return s.RetentionRepo.SetPolicy(ctx, roomID, policy)
+}
+
+func (s *RetentionService) ListAudit(ctx context.Context, roomID int) ([]model.RetentionAudit, error) {
+	return s.RetentionRepo.ListAudit(ctx, roomID, auditLimit)
+}
+
+func (s *RetentionService) Run(ctx context.Context, roomID int, dryRun bool) ([]Report, error) {
+	var rooms []model.Room
+	if roomID != 0 {
+		room, err := s.RetentionRepo.GetRoom(ctx, roomID)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	} else {
+		var err error
+		if rooms, err = s.RetentionRepo.ListRoomsWithPolicy(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make([]Report, 0, len(rooms))
+	for _, room := range rooms {
+		report, err := s.runRoom(ctx, room, dryRun)
+		if err != nil {
+			return reports, fmt.Errorf("retention of room %d failed: %w", room.RID, err)
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// StartScheduler applies the room policies every RETENTION_INTERVAL_HOURS (default 24).
+func (s *RetentionService) StartScheduler() {
+	interval := time.Duration(envInt("RETENTION_INTERVAL_HOURS", defaultIntervalHours)) * time.Hour
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			reports, err := s.Run(context.Background(), 0, false)
+			if err != nil {
+				fmt.Printf("[WARN] scheduled retention run failed: %v\n", err)
+			}
+			for _, report := range reports {
+				if report.Unpinned > 0 || report.Failed > 0 {
+					fmt.Printf("[INFO] retention of room %d unpinned %d CIDs, %d failed\n", report.RoomID, report.Unpinned, report.Failed)
+				}
+			}
+		}
+	}()
+}
+
+func (s *RetentionService) runRoom(ctx context.Context, room model.Room, dryRun bool) (*Report, error) {
+	report := &Report{RoomID: int(room.RID), DryRun: dryRun, Policy: roomPolicy(room), Versions: []PrunedVersion{}}
+	if !report.Policy.enabled() {
+		return report, nil
+	}
+
+	// 1. Superseded versions the policy no longer keeps
+	versions, err := s.RetentionRepo.ListVersions(ctx, int(room.RID))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var pruned []model.Asset
+	var prunedIDs []uint
+	var prunedCIDs []string
+	meshStart := 0
+	for i, asset := range versions {
+		if i == 0 || versions[i-1].AssetMeshName != asset.AssetMeshName {
+			meshStart = i // latest version of its mesh
+			continue
+		}
+		rank := i - meshStart
+		supersededAt := versions[i-1].CreatedAt
+		if report.Policy.keeps(rank, supersededAt, now) {
+			continue
+		}
+		pruned = append(pruned, asset)
+		prunedIDs = append(prunedIDs, asset.AID)
+		prunedCIDs = append(prunedCIDs, asset.AssetCID)
+		report.Versions = append(report.Versions, PrunedVersion{
+			AssetID:      asset.AID,
+			MeshName:     asset.AssetMeshName,
+			Version:      asset.Version,
+			CreatedAt:    asset.CreatedAt,
+			SupersededAt: supersededAt,
+		})
+	}
+	if len(pruned) == 0 {
+		return report, nil
+	}
+
+	// 2. Everything they were stored as. Renditions and narrations of an asset CID that a version
+	// outside the pruned ones shares stay with it.
+	sharedCIDs, err := s.RetentionRepo.SharedAssetCIDs(ctx, prunedCIDs, prunedIDs)
+	if err != nil {
+		return nil, err
+	}
+	shared := make(map[string]bool, len(sharedCIDs))
+	for _, cid := range sharedCIDs {
+		shared[cid] = true
+	}
+	var ownedCIDs []string
+	for _, cid := range prunedCIDs {
+		if !shared[cid] {
+			ownedCIDs = append(ownedCIDs, cid)
+		}
+	}
+
+	var renditions []model.AssetRendition
+	var audios []model.Audio
+	if len(ownedCIDs) > 0 {
+		if renditions, err = s.RetentionRepo.ListRenditions(ctx, ownedCIDs); err != nil {
+			return nil, err
+		}
+		if audios, err = s.RetentionRepo.ListAudios(ctx, ownedCIDs); err != nil {
+			return nil, err
+		}
+	}
+	for i, asset := range pruned {
+		cids := &report.Versions[i].CIDs
+		addCID(cids, asset.AssetCID, "asset")
+		addCID(cids, asset.WebpCID, "webp")
+		addCID(cids, asset.HLSCID, "hls")
+		if asset.TileManifest != nil {
+			addCID(cids, asset.TileManifest.CID, "tiles")
+		}
+		for _, rendition := range renditions {
+			if rendition.AssetCID == asset.AssetCID {
+				addCID(cids, rendition.CID, rendition.Kind)
+			}
+		}
+		for _, audio := range audios {
+			if audio.AssetCID == asset.AssetCID {
+				addCID(cids, audio.AudioCID, "audio")
+			}
+		}
+	}
+
+	// 3. Unpin what no live row references, each CID once even when versions share it
+	if !dryRun {
+		if report.RunID, err = business.RandomID(); err != nil {
+			return nil, fmt.Errorf("failed to generate run id: %w", err)
+		}
+	}
+	outcomes := make(map[string]PrunedCID)
+	var audit []model.RetentionAudit
+	// An upload reusing a blob points an asset at it within one job timeout
+	claimedSince := time.Now().Add(-jobs.Timeout())
+	for i := range report.Versions {
+		version := &report.Versions[i]
+		for j := range version.CIDs {
+			cid := &version.CIDs[j]
+			if outcome, done := outcomes[cid.CID]; done {
+				cid.Referenced, cid.Unpinned, cid.Error = outcome.Referenced, outcome.Unpinned, outcome.Error
+				continue
+			}
+
+			if cid.Referenced, err = s.RetentionRepo.IsReferenced(ctx, cid.CID, prunedIDs, ownedCIDs, claimedSince); err != nil {
+				return nil, err
+			}
+			switch {
+			case cid.Referenced:
+				report.Referenced++
+			case dryRun:
+			default:
+				entry := model.RetentionAudit{
+					RunID:    report.RunID,
+					RoomID:   room.RID,
+					AssetID:  version.AssetID,
+					MeshName: version.MeshName,
+					Version:  version.Version,
+					CID:      cid.CID,
+					Kind:     cid.Kind,
+					Driver:   s.Storage.Name(),
+					Action:   model.RetentionUnpinned,
+				}
+				if err := s.Storage.Delete(ctx, cid.CID); err != nil && !errors.Is(err, business.ErrObjectNotFound) {
+					cid.Error = err.Error()
+					entry.Action = model.RetentionFailed
+					entry.Error = cid.Error
+					report.Failed++
+				} else {
+					cid.Unpinned = true
+					report.Unpinned++
+					if err := s.RetentionRepo.ForgetBlobs(ctx, cid.CID); err != nil {
+						fmt.Printf("[WARN] failed to drop %s from the blob index: %v\n", cid.CID, err)
+					}
+				}
+				audit = append(audit, entry)
+			}
+			outcomes[cid.CID] = *cid
+		}
+	}
+	if err := s.RetentionRepo.SaveAudit(ctx, audit); err != nil {
+		fmt.Printf("[WARN] failed to record retention audit of room %d: %v\n", room.RID, err)
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	// 4. Drop the rows whose CIDs are all gone or still in use elsewhere, the others are retried next run
+	for i, asset := range pruned {
+		version := &report.Versions[i]
+		failed := false
+		for _, cid := range version.CIDs {
+			failed = failed || cid.Error != ""
+		}
+		if failed {
+			continue
+		}
+		if err := s.RetentionRepo.DeleteVersion(ctx, asset, shared[asset.AssetCID]); err != nil {
+			return nil, err
+		}
+		version.Removed = true
+	}
+	return report, nil
+}
+
+func roomPolicy(room model.Room) Policy {
+	return Policy{KeepVersions: room.RetainVersions, KeepDays: room.RetainDays}
+}
+
+// addCID lists cid under kind once, the first kind wins (lod0 is the asset itself).
+func addCID(cids *[]PrunedCID, cid string, kind string) {
+	if cid == "" {
+		return
+	}
+	for _, existing := range *cids {
+		if existing.CID == cid {
+			return
+		}
+	}
+	*cids = append(*cids, PrunedCID{CID: cid, Kind: kind})
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
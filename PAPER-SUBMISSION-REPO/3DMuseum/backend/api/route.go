@@ -1,26 +1,108 @@
 package api
 
 import (
+	"log"
 	"main/api/assets"
+	"main/api/health"
+	"main/api/imports"
+	"main/api/jobs"
+	"main/api/media"
+	"main/api/retention"
+	"main/api/tiles"
+	"main/api/uploads"
 	"main/business"
 	"main/websocket"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func RegisterAssetRoutes(router *gin.Engine, database *gorm.DB, PinataService *business.PinataService, SFU *websocket.SFU) {
+func RegisterAssetRoutes(router *gin.Engine, database *gorm.DB, Storage business.Storage, SFU *websocket.SFU) {
 	assetRepository := assets.NewRepository(database)
-	pinataRepository := business.NewPinataRepo(PinataService)
 	ttsRepository := business.NewTTSRepo()
-	assetService := assets.NewService(assetRepository, pinataRepository, ttsRepository)
+	jobRepository := jobs.NewRepository(database)
+	jobService := jobs.NewService(jobRepository)
+	jobHandler := jobs.NewHandler(jobService)
+
+	assetService := assets.NewService(assetRepository, Storage, ttsRepository, jobService)
 	assetHandler := assets.NewHandler(assetService)
 
+	uploadStore, err := uploads.NewStore()
+	if err != nil {
+		log.Fatalf("Failed to initialize resumable upload store: %v", err)
+	}
+	uploadStore.StartJanitor(time.Hour)
+	uploadService := uploads.NewService(uploadStore, assetService, jobService)
+	uploadHandler := uploads.NewHandler(uploadService)
+
+	importService := imports.NewService(assetService)
+	importHandler := imports.NewHandler(importService)
+
+	tileRepository := tiles.NewRepository(database)
+	tileService := tiles.NewService(tileRepository)
+	tileHandler := tiles.NewHandler(tileService)
+
+	mediaCache, err := media.NewCache()
+	if err != nil {
+		log.Fatalf("Failed to initialize media cache: %v", err)
+	}
+	mediaRepository := media.NewRepository(database)
+	mediaService := media.NewService(mediaRepository, Storage, mediaCache)
+	mediaHandler := media.NewHandler(mediaService)
+
+	retentionRepository := retention.NewRepository(database)
+	retentionService := retention.NewService(retentionRepository, Storage)
+	retentionService.StartScheduler()
+	retentionHandler := retention.NewHandler(retentionService)
+
+	healthHandler := health.NewHandler(Storage)
+
 	assetRoutes := router.Group("/")
 	{
 		assetRoutes.GET("/hello", assetHandler.Hello)
+		assetRoutes.GET("/health", healthHandler.GetHealth)
 		assetRoutes.POST("/upload", assetHandler.UploadAsset)
 		assetRoutes.GET("/list/:roomID", assetHandler.GetAsset)
+		assetRoutes.POST("/import", importHandler.ImportAssets)
+	}
+
+	// The local storage driver serves what it stores itself, without directory listings
+	if localStorage, ok := Storage.(*business.LocalStorage); ok {
+		router.StaticFS("/storage", gin.Dir(localStorage.Root, false))
+	}
+
+	// Stored files proxied from the storage driver, so clients never see its gateway
+	for _, route := range []string{"/media/:cid", "/media/:cid/*path"} {
+		router.GET(route, mediaHandler.GetMedia)
+		router.HEAD(route, mediaHandler.GetMedia)
+	}
+
+	// Deep-zoom tiles of images uploaded with deep_zoom
+	router.GET("/tiles/:cid/:level/:x/:y", tileHandler.GetTile)
+
+	// Background upload jobs
+	jobRoutes := router.Group("/jobs")
+	{
+		jobRoutes.GET("", jobHandler.ListJobs)
+		jobRoutes.GET("/:id", jobHandler.GetJob)
+	}
+
+	// Retention of superseded asset versions
+	retentionRoutes := router.Group("/retention")
+	{
+		retentionRoutes.GET("/rooms/:roomID", retentionHandler.GetPolicy)
+		retentionRoutes.PUT("/rooms/:roomID", retentionHandler.SetPolicy)
+		retentionRoutes.POST("/run", retentionHandler.Run)
+		retentionRoutes.GET("/audit", retentionHandler.ListAudit)
+	}
+
+	// Resumable (tus-style) uploads for large artefacts on unreliable connections
+	uploadRoutes := router.Group("/uploads")
+	{
+		uploadRoutes.POST("", uploadHandler.CreateUpload)
+		uploadRoutes.HEAD("/:id", uploadHandler.UploadStatus)
+		uploadRoutes.PATCH("/:id", uploadHandler.UploadChunk)
 	}
 	router.GET("/ws", websocket.HandleWS)
 	router.POST("/join", SFU.HandleJoin)
@@ -8,6 +8,6 @@ import (
 	"gorm.io/gorm"
 )
 
-func RegisterRoutes(router *gin.Engine, database *gorm.DB, PinataService *business.PinataService, SFU *websocket.SFU) {
-	RegisterAssetRoutes(router, database, PinataService, SFU)
+func RegisterRoutes(router *gin.Engine, database *gorm.DB, Storage business.Storage, SFU *websocket.SFU) {
+	RegisterAssetRoutes(router, database, Storage, SFU)
 }
@@ -0,0 +1,54 @@
+package tiles
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Handler struct {
+	TileService Service
+}
+
+func NewHandler(TileService Service) *Handler {
+	return &Handler{TileService: TileService}
+}
+
+// GetTile handles GET /tiles/:cid/:level/:x/:y and returns the /media URL of a deep-zoom
+// tile of the asset. With ?redirect=true it redirects there instead, so it can be used as
+// an image source directly.
+func (Handler *Handler) GetTile(context *gin.Context) {
+	var coordinates [3]int
+	for i, name := range []string{"level", "x", "y"} {
+		value, err := strconv.Atoi(context.Param(name))
+		if err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name, "success": false})
+			return
+		}
+		coordinates[i] = value
+	}
+
+	tile, err := Handler.TileService.GetTile(context.Request.Context(), context.Param("cid"), coordinates[0], coordinates[1], coordinates[2])
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, gin.H{"error": "Asset not found", "success": false})
+		return
+	case errors.Is(err, ErrNoTiles), errors.Is(err, ErrTileOutOfRange):
+		context.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	if context.Query("redirect") == "true" {
+		// Tiles are immutable, so is where they live
+		context.Header("Cache-Control", "public, max-age=31536000, immutable")
+		context.Redirect(http.StatusFound, tile.URL)
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"success": true, "tile": tile})
+}
@@ -0,0 +1,45 @@
+package tiles
+
+import (
+	"context"
+	"main/model"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	GetTileManifest(ctx context.Context, assetCID string) (*model.TileManifest, error)
+}
+
+type TileRepo struct {
+	database *gorm.DB
+
+	// A pinned pyramid never changes, so manifests are kept for the life of the process
+	manifests sync.Map
+}
+
+func NewRepository(db *gorm.DB) *TileRepo {
+	return &TileRepo{database: db}
+}
+
+// GetTileManifest returns the tile manifest of an asset, nil when it has no deep-zoom tiles
+// and gorm.ErrRecordNotFound when there is no such asset.
+func (repo *TileRepo) GetTileManifest(ctx context.Context, assetCID string) (*model.TileManifest, error) {
+	if cached, ok := repo.manifests.Load(assetCID); ok {
+		return cached.(*model.TileManifest), nil
+	}
+
+	var asset model.Asset
+	err := repo.database.WithContext(ctx).
+		Select("asset_cid", "tile_manifest").
+		Where("asset_cid = ?", assetCID).
+		First(&asset).Error
+	if err != nil {
+		return nil, err
+	}
+	if asset.TileManifest != nil {
+		repo.manifests.Store(assetCID, asset.TileManifest)
+	}
+	return asset.TileManifest, nil
+}
@@ -0,0 +1,59 @@
+package tiles
+
+import (
+	"context"
+	"errors"
+	"main/api/media"
+	"main/business"
+)
+
+var (
+	ErrNoTiles        = errors.New("asset has no deep-zoom tiles")
+	ErrTileOutOfRange = errors.New("tile is outside the pyramid")
+)
+
+// Tile is where one deep-zoom tile can be fetched, URL is a /media path on this server.
+type Tile struct {
+	Level int    `json:"level"`
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	URL   string `json:"url"`
+}
+
+type Service interface {
+	GetTile(ctx context.Context, assetCID string, level, x, y int) (*Tile, error)
+}
+
+type TileService struct {
+	TileRepo Repository
+}
+
+func NewService(TileRepo Repository) *TileService {
+	return &TileService{TileRepo: TileRepo}
+}
+
+// GetTile resolves a tile of the asset's pyramid to its media URL after checking it exists.
+func (s *TileService) GetTile(ctx context.Context, assetCID string, level, x, y int) (*Tile, error) {
+	manifest, err := s.TileRepo.GetTileManifest(ctx, assetCID)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, ErrNoTiles
+	}
+
+	if level < 0 || level > manifest.MaxLevel || x < 0 || y < 0 {
+		return nil, ErrTileOutOfRange
+	}
+	width, height := business.DeepZoomLevelSize(manifest.Width, manifest.Height, manifest.MaxLevel, level)
+	if x*manifest.TileSize >= width || y*manifest.TileSize >= height {
+		return nil, ErrTileOutOfRange
+	}
+
+	return &Tile{
+		Level: level,
+		X:     x,
+		Y:     y,
+		URL:   media.Path(manifest.CID, business.ExpandTilePath(manifest.TilePath, level, x, y)),
+	}, nil
+}
@@ -0,0 +1,172 @@
+package uploads
+
+import (
+	"encoding/base64"
+	"errors"
+	"main/api/assets"
+	"main/api/jobs"
+	"main/business"
+	"main/model"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Resumable uploads follow the tus 1.0 core protocol:
+//
+//	POST  /uploads      Upload-Length + Upload-Metadata  -> 201, Location: /uploads/<id>
+//	HEAD  /uploads/:id                                   -> Upload-Offset / Upload-Length, Upload-Job-ID once queued
+//	PATCH /uploads/:id  Upload-Offset + chunk body       -> 204, or 202 with the queued job on the last chunk
+//	                                                        (repeating it returns the same job; on 503 the data is kept, retry it)
+//
+// Upload-Metadata carries the same fields as POST /upload (filename, mesh_name, title,
+// vietnamese_description, english_description, roomID, encoding_profile,
+// normalize_mode, deep_zoom, webp_mode) as "key base64(value)" pairs.
+const tusVersion = "1.0.0"
+
+type Handler struct {
+	UploadService Service
+}
+
+func NewHandler(UploadService Service) *Handler {
+	return &Handler{UploadService: UploadService}
+}
+
+func (Handler *Handler) CreateUpload(context *gin.Context) {
+	context.Header("Tus-Resumable", tusVersion)
+
+	length, err := strconv.ParseInt(context.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length header is required", "success": false})
+		return
+	}
+
+	metadata, err := parseUploadMetadata(context.GetHeader("Upload-Metadata"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata: " + err.Error(), "success": false})
+		return
+	}
+
+	var input model.DetailUploadInfor
+	if err := binding.MapFormWithTag(&input, metadata, "form"); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload metadata: " + err.Error(), "success": false})
+		return
+	}
+	input.Filename = firstValue(metadata, "filename")
+	if input.Filename == "" {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Metadata must include filename", "success": false})
+		return
+	}
+
+	session, err := Handler.UploadService.CreateUpload(context.Request.Context(), input, length)
+	if err != nil {
+		context.JSON(errorStatus(err), assets.UploadErrorBody(err))
+		return
+	}
+
+	context.Header("Location", "/uploads/"+session.ID)
+	setSessionHeaders(context, session)
+	context.JSON(http.StatusCreated, gin.H{"success": true, "upload_id": session.ID})
+}
+
+func (Handler *Handler) UploadStatus(context *gin.Context) {
+	context.Header("Tus-Resumable", tusVersion)
+	context.Header("Cache-Control", "no-store")
+
+	session, err := Handler.UploadService.GetUpload(context.Request.Context(), context.Param("id"))
+	if err != nil {
+		context.Status(errorStatus(err))
+		return
+	}
+	setSessionHeaders(context, session)
+	context.Status(http.StatusOK)
+}
+
+func (Handler *Handler) UploadChunk(context *gin.Context) {
+	context.Header("Tus-Resumable", tusVersion)
+
+	if context.ContentType() != "application/offset+octet-stream" {
+		context.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream", "success": false})
+		return
+	}
+	offset, err := strconv.ParseInt(context.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header is required", "success": false})
+		return
+	}
+
+	session, job, err := Handler.UploadService.AppendChunk(context.Request.Context(), context.Param("id"), offset, context.Request.Body)
+	if session != nil {
+		setSessionHeaders(context, session)
+	}
+	if err != nil {
+		context.JSON(errorStatus(err), assets.UploadErrorBody(err))
+		return
+	}
+
+	if job != nil {
+		context.JSON(http.StatusAccepted, gin.H{"success": true, "job_id": job.JobID, "status": job.Status, "message": "Upload queued"})
+		return
+	}
+	context.Status(http.StatusNoContent)
+}
+
+func setSessionHeaders(context *gin.Context, session *Session) {
+	context.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	context.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	context.Header("Upload-Expires", session.ExpiresAt.Format(time.RFC1123))
+	if session.JobID != "" {
+		context.Header("Upload-Job-ID", session.JobID)
+	}
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrorUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrorOffsetMismatch), errors.Is(err, ErrorUploadBusy):
+		return http.StatusConflict
+	case errors.Is(err, business.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, business.ErrUnsupportedFileType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, business.ErrUnknownEncodingProfile), errors.Is(err, business.ErrUnknownNormalizeMode),
+		errors.Is(err, business.ErrUnknownWebPMode):
+		return http.StatusBadRequest
+	case errors.Is(err, jobs.ErrorQueueFull):
+		return http.StatusServiceUnavailable
+	}
+	if status, ok := assets.ValidationErrorStatus(err); ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2".
+func parseUploadMetadata(header string) (map[string][]string, error) {
+	metadata := make(map[string][]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("value of " + key + " is not base64")
+		}
+		metadata[key] = []string{string(value)}
+	}
+	return metadata, nil
+}
+
+func firstValue(values map[string][]string, key string) string {
+	if v := values[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"main/api/assets"
+	"main/api/jobs"
+	"main/business"
+	"main/model"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeAssets queues uploads into fakeJobs, or refuses them with jobs.ErrorQueueFull while full is set.
+type fakeAssets struct {
+	jobs *fakeJobs
+	full bool
+}
+
+func (a *fakeAssets) UploadAsset(ctx context.Context, info model.DetailUploadInfor) (*assets.UploadResult, error) {
+	return nil, nil
+}
+
+func (a *fakeAssets) EnqueueUpload(ctx context.Context, info model.DetailUploadInfor, workspace *business.Workspace) (*model.Job, error) {
+	if a.full {
+		return nil, jobs.ErrorQueueFull
+	}
+	defer workspace.Cleanup()
+	return a.jobs.add(info), nil
+}
+
+func (a *fakeAssets) GetAsset(ctx context.Context, roomID int) ([]model.ResponseMetadataInfor, error) {
+	return nil, nil
+}
+
+type fakeJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*model.Job
+}
+
+func (j *fakeJobs) add(info model.DetailUploadInfor) *model.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job := &model.Job{JobID: "job-" + strconv.Itoa(len(j.jobs)+1), Filename: info.Filename, Status: model.JobQueued}
+	j.jobs[job.JobID] = job
+	return job
+}
+
+func (j *fakeJobs) Submit(ctx context.Context, job *model.Job, task jobs.Task) error { return nil }
+
+func (j *fakeJobs) SetStatus(ctx context.Context, jobID string, status string) error { return nil }
+
+func (j *fakeJobs) GetJob(ctx context.Context, jobID string) (*model.Job, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.jobs[jobID], nil
+}
+
+func (j *fakeJobs) ListJobs(ctx context.Context, roomID int) ([]model.Job, error) { return nil, nil }
+
+func newTestRouter(t *testing.T) (*gin.Engine, *fakeAssets) {
+	t.Helper()
+	t.Setenv("RESUMABLE_UPLOAD_DIR", t.TempDir())
+	store, err := NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeJobs := &fakeJobs{jobs: make(map[string]*model.Job)}
+	assetService := &fakeAssets{jobs: fakeJobs}
+	handler := NewHandler(NewService(store, assetService, fakeJobs))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/uploads", handler.CreateUpload)
+	router.HEAD("/uploads/:id", handler.UploadStatus)
+	router.PATCH("/uploads/:id", handler.UploadChunk)
+	return router, assetService
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 32))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func serve(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func createUpload(t *testing.T, router *gin.Engine, length int) string {
+	t.Helper()
+	metadata := []string{}
+	for key, value := range map[string]string{"filename": "painting.png", "mesh_name": "painting", "roomID": "1"} {
+		metadata = append(metadata, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", strings.Join(metadata, ","))
+	w := serve(router, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	return strings.TrimPrefix(w.Header().Get("Location"), "/uploads/")
+}
+
+func patch(router *gin.Engine, id string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/uploads/"+id, bytes.NewReader(chunk))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	return serve(router, req)
+}
+
+func head(router *gin.Engine, id string) *httptest.ResponseRecorder {
+	return serve(router, httptest.NewRequest(http.MethodHead, "/uploads/"+id, nil))
+}
+
+func TestRepeatedFinalChunkReturnsSameJob(t *testing.T) {
+	router, assetService := newTestRouter(t)
+	data := testPNG(t)
+	half := len(data) / 2
+	id := createUpload(t, router, len(data))
+
+	if w := patch(router, id, 0, data[:half]); w.Code != http.StatusNoContent {
+		t.Fatalf("first chunk: %d %s", w.Code, w.Body)
+	}
+	first := patch(router, id, half, data[half:])
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("final chunk: %d %s", first.Code, first.Body)
+	}
+	jobID := first.Header().Get("Upload-Job-ID")
+	if jobID == "" || !strings.Contains(first.Body.String(), jobID) {
+		t.Fatalf("final chunk: job %q, body %s", jobID, first.Body)
+	}
+
+	// The response was lost: the client repeats the final chunk at the offset it sent it at
+	again := patch(router, id, half, data[half:])
+	if again.Code != http.StatusAccepted || again.Header().Get("Upload-Job-ID") != jobID {
+		t.Errorf("repeated final chunk: %d job %q, want 202 job %s", again.Code, again.Header().Get("Upload-Job-ID"), jobID)
+	}
+	if got := len(assetService.jobs.jobs); got != 1 {
+		t.Errorf("%d jobs queued, want 1", got)
+	}
+
+	// Or it asks where the upload stands
+	status := head(router, id)
+	if status.Code != http.StatusOK || status.Header().Get("Upload-Job-ID") != jobID {
+		t.Errorf("HEAD: %d job %q, want job %s", status.Code, status.Header().Get("Upload-Job-ID"), jobID)
+	}
+}
+
+func TestFinalChunkRetriedAfterQueueFull(t *testing.T) {
+	router, assetService := newTestRouter(t)
+	data := testPNG(t)
+	id := createUpload(t, router, len(data))
+
+	assetService.full = true
+	if w := patch(router, id, 0, data); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("final chunk with a full queue: %d %s, want 503", w.Code, w.Body)
+	}
+	status := head(router, id)
+	if status.Header().Get("Upload-Offset") != strconv.Itoa(len(data)) || status.Header().Get("Upload-Job-ID") != "" {
+		t.Errorf("HEAD after 503: offset %s job %q, want the complete upload without a job",
+			status.Header().Get("Upload-Offset"), status.Header().Get("Upload-Job-ID"))
+	}
+
+	// The client retries the chunk it sent, the kept data is queued
+	assetService.full = false
+	w := patch(router, id, 0, data)
+	if w.Code != http.StatusAccepted || w.Header().Get("Upload-Job-ID") == "" {
+		t.Fatalf("retried final chunk: %d %s, want 202 with a job", w.Code, w.Body)
+	}
+	if got := len(assetService.jobs.jobs); got != 1 {
+		t.Errorf("%d jobs queued, want 1", got)
+	}
+}
+
+func TestChunkAtWrongOffsetConflicts(t *testing.T) {
+	router, _ := newTestRouter(t)
+	data := testPNG(t)
+	id := createUpload(t, router, len(data))
+
+	if w := patch(router, id, 10, data[10:]); w.Code != http.StatusConflict {
+		t.Errorf("chunk at offset 10 of an empty upload: %d, want 409", w.Code)
+	}
+	if w := head(router, id); w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("offset after a conflicting chunk: %s, want 0", w.Header().Get("Upload-Offset"))
+	}
+}
+
+func TestInvalidUploadReportsCode(t *testing.T) {
+	router, _ := newTestRouter(t)
+	data := []byte("GIF89a, not the PNG the name promises")
+	id := createUpload(t, router, len(data))
+
+	w := patch(router, id, 0, data)
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code < 400 || body.Code == "" {
+		t.Errorf("final chunk of a mislabelled file: %d %s, want an error with a code", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,148 @@
+package uploads
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"main/api/assets"
+	"main/api/jobs"
+	"main/business"
+	"main/model"
+	"os"
+)
+
+type Service interface {
+	CreateUpload(ctx context.Context, info model.DetailUploadInfor, length int64) (*Session, error)
+	GetUpload(ctx context.Context, id string) (*Session, error)
+	// AppendChunk stores a chunk and, once the last byte has arrived, queues the asset pipeline.
+	// Repeating the final chunk returns the job it queued.
+	AppendChunk(ctx context.Context, id string, offset int64, body io.Reader) (*Session, *model.Job, error)
+}
+
+type UploadService struct {
+	Store        *Store
+	AssetService assets.Service
+	JobService   jobs.Service
+}
+
+func NewService(Store *Store, AssetService assets.Service, JobService jobs.Service) *UploadService {
+	return &UploadService{Store: Store, AssetService: AssetService, JobService: JobService}
+}
+
+func (s *UploadService) CreateUpload(ctx context.Context, info model.DetailUploadInfor, length int64) (*Session, error) {
+	categoryID, _, err := business.CategorizeFile(info.Filename)
+	if err != nil {
+		return nil, err
+	}
+	if length > business.UploadSizeLimit(categoryID) {
+		return nil, business.ErrUploadTooLarge
+	}
+	if info.EncodingProfile != "" {
+		if _, err := business.LookupKTX2Profile(info.EncodingProfile); err != nil {
+			return nil, err
+		}
+	}
+	if err := business.ValidateNormalizeMode(info.NormalizeMode); err != nil {
+		return nil, err
+	}
+	if err := business.ValidateWebPMode(info.WebPMode); err != nil {
+		return nil, err
+	}
+	return s.Store.Create(info, length)
+}
+
+func (s *UploadService) GetUpload(ctx context.Context, id string) (*Session, error) {
+	return s.Store.Get(id)
+}
+
+func (s *UploadService) AppendChunk(ctx context.Context, id string, offset int64, body io.Reader) (*Session, *model.Job, error) {
+	session, err := s.Store.WriteChunk(id, offset, body)
+	if err != nil || !session.Complete() {
+		return session, nil, err
+	}
+	if session.JobID != "" {
+		job, err := s.JobService.GetJob(ctx, session.JobID)
+		return session, job, err
+	}
+
+	// The session is locked while it is queued, a concurrent final chunk must not queue it twice
+	if !s.Store.lock(id) {
+		return session, nil, ErrorUploadBusy
+	}
+	defer s.Store.unlock(id)
+	if session, err = s.Store.Get(id); err != nil {
+		return nil, nil, err
+	}
+	if session.JobID != "" {
+		job, err := s.JobService.GetJob(ctx, session.JobID)
+		return session, job, err
+	}
+
+	job, err := s.finish(ctx, session)
+	return session, job, err
+}
+
+// finish moves the assembled file into a fresh workspace under its original name
+// (converters pick their input format from the extension) and queues it on the
+// regular upload pipeline, which then owns the workspace. When it cannot be queued
+// the file is moved back, so the client retries the final chunk instead of the upload.
+func (s *UploadService) finish(ctx context.Context, session *Session) (*model.Job, error) {
+	workspace, err := business.NewWorkspace("resumable")
+	if err != nil {
+		return nil, err
+	}
+
+	path := workspace.Path(session.Filename)
+	if err := moveFile(s.Store.DataPath(session.ID), path); err != nil {
+		workspace.Cleanup()
+		return nil, fmt.Errorf("failed to move completed upload: %w", err)
+	}
+	// Content that fails validation fails it again, there is nothing to retry
+	if err := business.ValidateFile(path, session.Filename); err != nil {
+		workspace.Cleanup()
+		s.Store.Remove(session.ID)
+		return nil, err
+	}
+
+	info := session.Info
+	info.FilePath = path
+	info.Filesize = session.Length
+	job, err := s.AssetService.EnqueueUpload(ctx, info, workspace)
+	if err != nil {
+		if moveErr := moveFile(path, s.Store.DataPath(session.ID)); moveErr != nil {
+			fmt.Printf("[WARN] failed to keep completed upload %s for a retry: %v\n", session.ID, moveErr)
+			s.Store.Remove(session.ID)
+		}
+		workspace.Cleanup()
+		return nil, err
+	}
+	if err := s.Store.Queued(session, job.JobID); err != nil {
+		fmt.Printf("[WARN] failed to record job of upload %s: %v\n", session.ID, err)
+	}
+	return job, nil
+}
+
+// moveFile renames src to dst, copying when they sit on different filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
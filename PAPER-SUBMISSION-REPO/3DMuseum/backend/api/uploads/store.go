@@ -0,0 +1,246 @@
+package uploads
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"main/business"
+	"main/model"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorUploadNotFound = errors.New("upload not found or expired")
+	ErrorUploadBusy     = errors.New("upload is receiving another chunk")
+	ErrorOffsetMismatch = errors.New("Upload-Offset does not match the current offset")
+)
+
+const defaultUploadTTL = 24 * time.Hour
+
+// Session is the persisted state of one resumable upload.
+// It is written as <id>.json next to the partial data file <id>.part.
+type Session struct {
+	ID        string                  `json:"id"`
+	Filename  string                  `json:"filename"`
+	Length    int64                   `json:"length"`
+	Offset    int64                   `json:"offset"`
+	Info      model.DetailUploadInfor `json:"info"`
+	JobID     string                  `json:"job_id,omitempty"` // set once the completed upload is queued, the data is gone then
+	CreatedAt time.Time               `json:"created_at"`
+	ExpiresAt time.Time               `json:"expires_at"`
+}
+
+func (s *Session) Complete() bool {
+	return s.Offset == s.Length
+}
+
+// Store keeps partial uploads on disk so they survive dropped connections and restarts.
+type Store struct {
+	dir  string
+	ttl  time.Duration
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewStore uses RESUMABLE_UPLOAD_DIR and RESUMABLE_UPLOAD_TTL_HOURS when set.
+func NewStore() (*Store, error) {
+	dir := os.Getenv("RESUMABLE_UPLOAD_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "museum-resumable-uploads")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create resumable upload dir: %w", err)
+	}
+
+	ttl := defaultUploadTTL
+	if v := os.Getenv("RESUMABLE_UPLOAD_TTL_HOURS"); v != "" {
+		if hours, err := strconv.Atoi(v); err == nil && hours > 0 {
+			ttl = time.Duration(hours) * time.Hour
+		}
+	}
+	return &Store{dir: dir, ttl: ttl, busy: make(map[string]bool)}, nil
+}
+
+func (store *Store) infoPath(id string) string { return filepath.Join(store.dir, id+".json") }
+func (store *Store) dataPath(id string) string { return filepath.Join(store.dir, id+".part") }
+
+func (store *Store) Create(info model.DetailUploadInfor, length int64) (*Session, error) {
+	id, err := business.RandomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	now := time.Now().UTC()
+	session := &Session{
+		ID:        id,
+		Filename:  info.Filename,
+		Length:    length,
+		Info:      info,
+		CreatedAt: now,
+		ExpiresAt: now.Add(store.ttl),
+	}
+
+	data, err := os.Create(store.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	data.Close()
+
+	if err := store.save(session); err != nil {
+		_ = os.Remove(store.dataPath(id))
+		return nil, err
+	}
+	return session, nil
+}
+
+func (store *Store) Get(id string) (*Session, error) {
+	// IDs are hex, reject anything that could escape the store directory.
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrorUploadNotFound
+	}
+	raw, err := os.ReadFile(store.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrorUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(raw, &session); err != nil {
+		return nil, fmt.Errorf("corrupt upload state %s: %w", id, err)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		store.Remove(id)
+		return nil, ErrorUploadNotFound
+	}
+	return &session, nil
+}
+
+// WriteChunk appends the body at offset. It never writes past the declared length:
+// extra bytes fail the chunk with business.ErrUploadTooLarge. Once the upload is complete
+// any chunk is ignored and the session returned as it is.
+func (store *Store) WriteChunk(id string, offset int64, body io.Reader) (*Session, error) {
+	if !store.lock(id) {
+		return nil, ErrorUploadBusy
+	}
+	defer store.unlock(id)
+
+	session, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Complete() {
+		// The final chunk sent again, at its original offset, after its response was lost or
+		// queueing it failed. The caller returns the job or queues the upload again.
+		return session, nil
+	}
+	if offset != session.Offset {
+		return session, ErrorOffsetMismatch
+	}
+
+	data, err := os.OpenFile(store.dataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer data.Close()
+	if _, err := data.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	remaining := session.Length - session.Offset
+	written, copyErr := io.Copy(data, io.LimitReader(body, remaining+1))
+	if written > remaining {
+		copyErr = business.ErrUploadTooLarge
+		written = 0
+	}
+
+	// Keep whatever arrived before a dropped connection: that is the point of resuming.
+	session.Offset += written
+	if err := data.Truncate(session.Offset); err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = time.Now().UTC().Add(store.ttl)
+	if err := store.save(session); err != nil {
+		return nil, err
+	}
+	return session, copyErr
+}
+
+// DataPath returns the file holding the received bytes of an upload.
+func (store *Store) DataPath(id string) string {
+	return store.dataPath(id)
+}
+
+// Queued records that the completed upload was handed to job. The session is kept until it expires
+// so the final chunk can be repeated.
+func (store *Store) Queued(session *Session, jobID string) error {
+	session.JobID = jobID
+	return store.save(session)
+}
+
+func (store *Store) Remove(id string) {
+	_ = os.Remove(store.dataPath(id))
+	_ = os.Remove(store.infoPath(id))
+}
+
+// StartJanitor periodically deletes expired partial uploads.
+func (store *Store) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			store.sweep()
+		}
+	}()
+}
+
+func (store *Store) sweep() {
+	matches, err := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		if store.lock(id) {
+			// Get removes the session when it has expired.
+			if _, err := store.Get(id); errors.Is(err, ErrorUploadNotFound) {
+				fmt.Printf("[INFO] removed expired resumable upload %s\n", id)
+			}
+			store.unlock(id)
+		}
+	}
+}
+
+func (store *Store) save(session *Session) error {
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	tmp := store.infoPath(session.ID) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("failed to persist upload state: %w", err)
+	}
+	return os.Rename(tmp, store.infoPath(session.ID))
+}
+
+func (store *Store) lock(id string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.busy[id] {
+		return false
+	}
+	store.busy[id] = true
+	return true
+}
+
+func (store *Store) unlock(id string) {
+	store.mu.Lock()
+	delete(store.busy, id)
+	store.mu.Unlock()
+}
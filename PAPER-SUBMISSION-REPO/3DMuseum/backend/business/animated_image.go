@@ -0,0 +1,306 @@
+package business
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// Browsers play frames with a delay of 10ms or less at 100ms, so do we
+const defaultFrameDuration = 100
+
+// frameFunc receives every frame of an animation composited onto the full canvas, with its
+// duration in milliseconds. The canvas is reused for the next frame, copy what you keep.
+type frameFunc func(canvas *image.NRGBA, duration int) error
+
+// IsAnimatedImage reports whether path is a GIF or (A)PNG with more than one frame.
+func IsAnimatedImage(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	_, format, err := image.DecodeConfig(file)
+	if err != nil || (format != "gif" && format != "png") {
+		return false
+	}
+	frames, err := countFrames(file, format)
+	return err == nil && frames > 1
+}
+
+// decodeAnimation plays the GIF or APNG at path frame by frame into emit and returns how often
+// the animation is played, 0 for forever.
+func decodeAnimation(path string, emit frameFunc) (int, error) {
+	config, format, err := CheckDecodeLimits(path)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case "gif":
+		return decodeGIFAnimation(file, emit)
+	case "png":
+		return decodeAPNGAnimation(file, config, emit)
+	}
+	return 0, fmt.Errorf("%s images are not animated", format)
+}
+
+func decodeGIFAnimation(r io.Reader, emit frameFunc) (int, error) {
+	decoded, err := gif.DecodeAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode: %v", err)
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, decoded.Config.Width, decoded.Config.Height))
+	var previous *image.NRGBA
+	for i, frame := range decoded.Image {
+		var disposal byte
+		if i < len(decoded.Disposal) {
+			disposal = decoded.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+		// Transparent palette entries leave the canvas as it was
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if err := emit(canvas, frameDuration(decoded.Delay[i]*10)); err != nil {
+			return 0, err
+		}
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+
+	// GIF counts repeats after the first play, -1 when there is no loop extension at all
+	switch {
+	case decoded.LoopCount == 0:
+		return 0, nil
+	case decoded.LoopCount < 0:
+		return 1, nil
+	}
+	return decoded.LoopCount + 1, nil
+}
+
+// apngFrame is a frame control (fcTL) chunk and the image data that follows it.
+type apngFrame struct {
+	width, height int
+	x, y          int
+	delayNum      uint16
+	delayDen      uint16
+	disposeOp     byte
+	blendOp       byte
+	data          bytes.Buffer
+}
+
+// APNG dispose and blend operations
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendOver         = 1
+)
+
+// decodeAPNGAnimation reads an APNG chunk by chunk. Every frame is turned back into a plain PNG,
+// with the header and palette of the file, and decoded by image/png, then composited as its
+// fcTL says.
+func decodeAPNGAnimation(r io.Reader, config image.Config, emit frameFunc) (int, error) {
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return 0, err
+	}
+
+	var (
+		header    []byte
+		shared    bytes.Buffer // PLTE, tRNS, gAMA, ... every frame needs them to decode
+		plays     int
+		frame     *apngFrame
+		seenData  bool
+		frameSeq  int
+		canvas    = image.NewNRGBA(image.Rect(0, 0, config.Width, config.Height))
+		chunkHead = make([]byte, 8)
+	)
+	flush := func() error {
+		if frame == nil {
+			return nil
+		}
+		defer func() { frame = nil }()
+		// acTL, which CheckDecodeLimits went by, may understate the frames that follow
+		if frameSeq++; frameSeq > limitsFor("png").MaxFrames {
+			return invalid(ValidationFrameLimit, "APNG has more than %d frames", limitsFor("png").MaxFrames)
+		}
+		return composeAPNGFrame(canvas, frame, header, shared.Bytes(), frameSeq == 1, emit)
+	}
+
+	for {
+		if _, err := io.ReadFull(r, chunkHead); err != nil {
+			return 0, fmt.Errorf("truncated PNG: %v", err)
+		}
+		length := int64(binary.BigEndian.Uint32(chunkHead[:4]))
+		kind := string(chunkHead[4:8])
+		// Chunk lengths come from the file, do not allocate what is not there
+		data, err := io.ReadAll(io.LimitReader(r, length))
+		if err == nil && int64(len(data)) < length {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			_, err = io.CopyN(io.Discard, r, 4) // CRC, image/png checks the chunks it is given
+		}
+		if err != nil {
+			return 0, fmt.Errorf("truncated PNG %s chunk: %v", kind, err)
+		}
+
+		switch kind {
+		case "IHDR":
+			header = data
+		case "acTL":
+			if len(data) >= 8 {
+				plays = int(binary.BigEndian.Uint32(data[4:8]))
+			}
+		case "fcTL":
+			if err := flush(); err != nil {
+				return 0, err
+			}
+			if frame, err = parseFCTL(data); err != nil {
+				return 0, err
+			}
+		case "IDAT":
+			seenData = true
+			// Without an fcTL before it the default image is not part of the animation
+			if frame != nil {
+				frame.data.Write(data)
+			}
+		case "fdAT":
+			if frame != nil && len(data) >= 4 {
+				frame.data.Write(data[4:])
+			}
+		case "IEND":
+			if err := flush(); err != nil {
+				return 0, err
+			}
+			if frameSeq == 0 {
+				return 0, errors.New("APNG has no frames")
+			}
+			return plays, nil
+		default:
+			if !seenData && header != nil {
+				writePNGChunk(&shared, kind, data)
+			}
+		}
+	}
+}
+
+func parseFCTL(data []byte) (*apngFrame, error) {
+	if len(data) < 26 {
+		return nil, errors.New("short APNG fcTL chunk")
+	}
+	be := binary.BigEndian
+	return &apngFrame{
+		width:     int(be.Uint32(data[4:])),
+		height:    int(be.Uint32(data[8:])),
+		x:         int(be.Uint32(data[12:])),
+		y:         int(be.Uint32(data[16:])),
+		delayNum:  be.Uint16(data[20:]),
+		delayDen:  be.Uint16(data[22:]),
+		disposeOp: data[24],
+		blendOp:   data[25],
+	}, nil
+}
+
+func composeAPNGFrame(canvas *image.NRGBA, frame *apngFrame, header []byte, shared []byte, first bool, emit frameFunc) error {
+	region := image.Rect(frame.x, frame.y, frame.x+frame.width, frame.y+frame.height)
+	if len(header) != 13 || frame.width <= 0 || frame.height <= 0 || !region.In(canvas.Bounds()) {
+		return fmt.Errorf("APNG frame %v lies outside the %v canvas", region, canvas.Bounds())
+	}
+
+	// A PNG of just this frame: the file header with the frame size, the shared chunks, its data
+	var single bytes.Buffer
+	single.WriteString("\x89PNG\r\n\x1a\n")
+	frameHeader := bytes.Clone(header)
+	binary.BigEndian.PutUint32(frameHeader[0:], uint32(frame.width))
+	binary.BigEndian.PutUint32(frameHeader[4:], uint32(frame.height))
+	writePNGChunk(&single, "IHDR", frameHeader)
+	single.Write(shared)
+	writePNGChunk(&single, "IDAT", frame.data.Bytes())
+	writePNGChunk(&single, "IEND", nil)
+	img, err := png.Decode(&single)
+	if err != nil {
+		return fmt.Errorf("failed to decode APNG frame: %v", err)
+	}
+
+	disposeOp := frame.disposeOp
+	if first && disposeOp == apngDisposePrevious {
+		disposeOp = apngDisposeBackground
+	}
+	var previous *image.NRGBA
+	if disposeOp == apngDisposePrevious {
+		previous = image.NewNRGBA(region)
+		draw.Draw(previous, region, canvas, region.Min, draw.Src)
+	}
+	op := draw.Src
+	if frame.blendOp == apngBlendOver {
+		op = draw.Over
+	}
+	draw.Draw(canvas, region, img, img.Bounds().Min, op)
+
+	delayDen := int(frame.delayDen)
+	if delayDen == 0 {
+		delayDen = 100
+	}
+	if err := emit(canvas, frameDuration(int(frame.delayNum)*1000/delayDen)); err != nil {
+		return err
+	}
+
+	switch disposeOp {
+	case apngDisposeBackground:
+		draw.Draw(canvas, region, image.Transparent, image.Point{}, draw.Src)
+	case apngDisposePrevious:
+		draw.Draw(canvas, region, previous, region.Min, draw.Src)
+	}
+	return nil
+}
+
+func writePNGChunk(w *bytes.Buffer, kind string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	w.Write(length[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
+
+// frameDuration applies the browser minimum to a frame delay in milliseconds.
+func frameDuration(ms int) int {
+	if ms <= 10 {
+		return defaultFrameDuration
+	}
+	return min(ms, 1<<24-1) // WebP stores 24 bits
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	out := image.NewNRGBA(img.Rect)
+	copy(out.Pix, img.Pix)
+	return out
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package business
+
+// limitedCommand runs program directly; resource limits need ulimit, which only exists on unix.
+func limitedCommand(program string, memoryMB int, cpuSeconds int, args []string) (string, []string) {
+	return program, args
+}
+
+func converterLimitError(program string, err error, stderr string) error {
+	return nil
+}
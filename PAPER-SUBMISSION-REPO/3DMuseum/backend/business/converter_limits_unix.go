@@ -0,0 +1,36 @@
+//go:build unix
+
+package business
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// limitedCommand starts program under a shell that first lowers its address-space limit to
+// memoryMB and its CPU-time limit to cpuSeconds. Wall-clock limits come from the caller's context.
+func limitedCommand(program string, memoryMB int, cpuSeconds int, args []string) (string, []string) {
+	script := fmt.Sprintf(`ulimit -v %d && ulimit -t %d && exec %s "$@"`, memoryMB*1024, cpuSeconds, program)
+	return "/bin/sh", append([]string{"-c", script, program}, args...)
+}
+
+// converterLimitError recognises a limitedCommand run that was stopped by one of its resource limits.
+func converterLimitError(program string, err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return nil
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() && status.Signal() == syscall.SIGXCPU {
+		return fmt.Errorf("%w: %s exceeded its CPU time limit", ErrConverterLimit, program)
+	}
+	lower := strings.ToLower(stderr)
+	if (ok && status.Signaled() && status.Signal() == syscall.SIGABRT) ||
+		strings.Contains(lower, "bad_alloc") || strings.Contains(lower, "out of memory") {
+		return fmt.Errorf("%w: %s exceeded its memory limit", ErrConverterLimit, program)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultConverterTimeout = 5 * time.Minute
+
+// ConverterPool bounds how many conversions (toktx processes, WebP encodes) run at
+// once and how long each of them may take.
+type ConverterPool struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func NewConverterPool(workers int, timeout time.Duration) *ConverterPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &ConverterPool{slots: make(chan struct{}, workers), timeout: timeout}
+}
+
+var (
+	converters     *ConverterPool
+	convertersOnce sync.Once
+)
+
+// Converters returns the shared pool, sized by CONVERTER_WORKERS (default: number of CPUs)
+// with a per-conversion limit of CONVERTER_TIMEOUT_SECONDS (default 300).
+// It is created lazily so the values from .env are already loaded.
+func Converters() *ConverterPool {
+	convertersOnce.Do(func() {
+		workers := envInt("CONVERTER_WORKERS", runtime.NumCPU())
+		timeout := time.Duration(envInt("CONVERTER_TIMEOUT_SECONDS", int(defaultConverterTimeout/time.Second))) * time.Second
+		converters = NewConverterPool(workers, timeout)
+	})
+	return converters
+}
+
+// Run waits for a free slot and runs convert with a context that is cancelled on timeout
+// or when ctx is cancelled. External processes must be started with exec.CommandContext
+// so they are killed along with it.
+func (p *ConverterPool) Run(ctx context.Context, convert func(ctx context.Context) error) error {
+	return p.RunWithTimeout(ctx, p.timeout, convert)
+}
+
+// RunWithTimeout is Run with its own time limit, for conversions such as video transcodes
+// that legitimately take longer than the pool default.
+func (p *ConverterPool) RunWithTimeout(ctx context.Context, timeout time.Duration, convert func(ctx context.Context) error) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for converter slot: %w", ctx.Err())
+	}
+	defer func() { <-p.slots }()
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := convert(runCtx)
+	if err != nil && runCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("conversion timed out after %s: %w", timeout, err)
+	}
+	return err
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		fmt.Printf("[WARN] invalid %s=%q, using default %d\n", key, v, fallback)
+	}
+	return fallback
+}
@@ -0,0 +1,234 @@
+package business
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"io"
+	"os"
+	"sync"
+)
+
+// Decode limit error codes, reported as 413 by the upload handlers.
+const (
+	ValidationPixelLimit = "pixel_limit_exceeded"
+	ValidationFrameLimit = "frame_limit_exceeded"
+	ValidationByteLimit  = "byte_limit_exceeded"
+)
+
+// DecodeLimits bound what a single image may cost to decode.
+type DecodeLimits struct {
+	MaxPixels int64 // width x height of one frame
+	MaxFrames int   // frames of an animated image
+	MaxBytes  int64 // decoded RGBA bytes over all frames
+}
+
+var (
+	decodeLimits     map[string]DecodeLimits
+	decodeLimitsOnce sync.Once
+)
+
+// limitsFor returns the limits of an image.DecodeConfig format name. The pixel limit can be
+// raised with DECODE_MAX_MEGAPIXELS (default 100, 50 for animations), the frame limit with
+// DECODE_MAX_FRAMES (default 300) and the decoded size with DECODE_MAX_MB (default 1024).
+func limitsFor(format string) DecodeLimits {
+	decodeLimitsOnce.Do(func() {
+		megapixels := int64(envInt("DECODE_MAX_MEGAPIXELS", 100))
+		frames := envInt("DECODE_MAX_FRAMES", 300)
+		decodedBytes := int64(envInt("DECODE_MAX_MB", 1024)) << 20
+
+		decodeLimits = map[string]DecodeLimits{
+			"png":  {MaxPixels: megapixels * 1_000_000, MaxFrames: frames, MaxBytes: decodedBytes},
+			"jpeg": {MaxPixels: megapixels * 1_000_000, MaxFrames: 1, MaxBytes: decodedBytes},
+			// heif-convert writes the primary image only
+			"heif": {MaxPixels: megapixels * 1_000_000, MaxFrames: 1, MaxBytes: decodedBytes},
+			// WebP is capped at 16383x16383 by the format itself
+			"webp": {MaxPixels: min(megapixels*1_000_000, 16383*16383), MaxFrames: frames, MaxBytes: decodedBytes},
+			// Animations are decoded frame by frame into full canvases, keep them smaller
+			"gif": {MaxPixels: megapixels * 1_000_000 / 2, MaxFrames: frames, MaxBytes: decodedBytes},
+		}
+	})
+	if limits, ok := decodeLimits[format]; ok {
+		return limits
+	}
+	return decodeLimits["png"]
+}
+
+// CheckDecodeLimits reads only the header (and, for animations, the frame table) of the image
+// at path and returns a *ValidationError when decoding it would exceed the limits of its format.
+// Call it before image.Decode on anything that came from a client.
+func CheckDecodeLimits(path string) (image.Config, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return image.Config{}, "", invalid(ValidationCorruptImage, "unreadable image header: %v", err)
+	}
+	limits := limitsFor(format)
+
+	pixels := int64(config.Width) * int64(config.Height)
+	if config.Width <= 0 || config.Height <= 0 {
+		return config, format, invalid(ValidationCorruptImage, "image has invalid dimensions %dx%d", config.Width, config.Height)
+	}
+	if pixels > limits.MaxPixels {
+		return config, format, invalid(ValidationPixelLimit, "%s image is %dx%d (%.1f MP), the limit is %.1f MP",
+			format, config.Width, config.Height, float64(pixels)/1e6, float64(limits.MaxPixels)/1e6)
+	}
+
+	frames, err := countFrames(file, format)
+	if err != nil {
+		return config, format, invalid(ValidationCorruptImage, "unreadable %s frame table: %v", format, err)
+	}
+	if frames > limits.MaxFrames {
+		return config, format, invalid(ValidationFrameLimit, "%s image has %d frames, the limit is %d", format, frames, limits.MaxFrames)
+	}
+	if decoded := pixels * 4 * int64(frames); decoded > limits.MaxBytes {
+		return config, format, invalid(ValidationByteLimit, "%s image would take %d MB to decode, the limit is %d MB",
+			format, decoded>>20, limits.MaxBytes>>20)
+	}
+	return config, format, nil
+}
+
+// countFrames counts animation frames without decoding any pixels.
+func countFrames(file io.ReadSeeker, format string) (int, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	switch format {
+	case "gif":
+		return countGIFFrames(file)
+	case "png":
+		return countAPNGFrames(file)
+	case "webp":
+		return countWebPFrames(file)
+	}
+	return 1, nil
+}
+
+// countGIFFrames walks the GIF block structure and counts image descriptors.
+func countGIFFrames(r io.Reader) (int, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	// Skip the global colour table
+	if header[10]&0x80 != 0 {
+		if _, err := io.CopyN(io.Discard, r, 3<<(int(header[10]&0x07)+1)); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return frames, err
+		}
+		switch b[0] {
+		case 0x3B: // trailer
+			return frames, nil
+		case 0x21: // extension: label, then sub-blocks
+			if _, err := io.ReadFull(r, b); err != nil {
+				return frames, err
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return frames, err
+			}
+		case 0x2C: // image descriptor, optional local colour table, LZW min code size, sub-blocks
+			frames++
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return frames, err
+			}
+			if descriptor[8]&0x80 != 0 {
+				if _, err := io.CopyN(io.Discard, r, 3<<(int(descriptor[8]&0x07)+1)); err != nil {
+					return frames, err
+				}
+			}
+			if _, err := io.ReadFull(r, b); err != nil {
+				return frames, err
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return frames, err
+			}
+		default:
+			return frames, fmt.Errorf("unexpected GIF block 0x%02x", b[0])
+		}
+	}
+}
+
+func skipGIFSubBlocks(r io.Reader) error {
+	size := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return err
+		}
+		if size[0] == 0 {
+			return nil
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size[0])); err != nil {
+			return err
+		}
+	}
+}
+
+// countAPNGFrames reads num_frames from the acTL chunk, which must precede the image data.
+func countAPNGFrames(r io.Reader) (int, error) {
+	if _, err := io.CopyN(io.Discard, r, 8); err != nil {
+		return 0, err
+	}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:8]) {
+		case "acTL":
+			frames := make([]byte, 4)
+			if _, err := io.ReadFull(r, frames); err != nil {
+				return 0, err
+			}
+			return int(min(binary.BigEndian.Uint32(frames), 1<<30)), nil
+		case "IDAT", "IEND":
+			return 1, nil
+		}
+		if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// countWebPFrames counts ANMF chunks of an animated WebP; still images have one frame.
+func countWebPFrames(r io.Reader) (int, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	frames := 0
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, err
+		}
+		if bytes.Equal(chunk[:4], []byte("ANMF")) {
+			frames++
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		// Chunks are padded to an even size
+		if _, err := io.CopyN(io.Discard, r, size+size&1); err != nil {
+			break
+		}
+	}
+	return max(frames, 1), nil
+}
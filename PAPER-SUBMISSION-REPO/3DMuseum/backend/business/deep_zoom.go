@@ -0,0 +1,137 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// DZI layout as read by OpenSeadragon and most deep-zoom viewers: 254px tiles with a 1px
+// overlap make 256px tiles everywhere but at the edges.
+const (
+	deepZoomTileSize = 254
+	deepZoomOverlap  = 1
+	deepZoomQuality  = 80
+	deepZoomName     = "image"
+)
+
+// DeepZoomPyramid is a tile pyramid on disk. Dir holds image.dzi and image_files/<level>/<x>_<y>.webp;
+// level MaxLevel is the full-size image and every level below halves it, down to 1x1 at level 0.
+type DeepZoomPyramid struct {
+	Dir      string
+	Width    int
+	Height   int
+	TileSize int
+	Overlap  int
+	MaxLevel int
+	Tiles    int
+}
+
+// Descriptor is the path of the .dzi file relative to Dir.
+func (p *DeepZoomPyramid) Descriptor() string {
+	return deepZoomName + ".dzi"
+}
+
+// TileTemplate is the path of a tile relative to Dir with {level}, {x} and {y} placeholders.
+func (p *DeepZoomPyramid) TileTemplate() string {
+	return deepZoomName + "_files/{level}/{x}_{y}.webp"
+}
+
+// TilePath is the path of a tile relative to Dir.
+func (p *DeepZoomPyramid) TilePath(level, x, y int) string {
+	return ExpandTilePath(p.TileTemplate(), level, x, y)
+}
+
+// ExpandTilePath fills in a tile path template such as TileTemplate.
+func ExpandTilePath(template string, level, x, y int) string {
+	return strings.NewReplacer("{level}", strconv.Itoa(level), "{x}", strconv.Itoa(x), "{y}", strconv.Itoa(y)).Replace(template)
+}
+
+// DeepZoomLevelSize returns the size of a pyramid level of a width x height image.
+func DeepZoomLevelSize(width, height, maxLevel, level int) (int, int) {
+	scale := math.Pow(2, float64(maxLevel-level))
+	return max(int(math.Ceil(float64(width)/scale)), 1), max(int(math.Ceil(float64(height)/scale)), 1)
+}
+
+// GenerateDeepZoom cuts the full-resolution image at inputPath into a WebP tile pyramid under
+// outputDir/deepzoom. It reads the upload itself rather than the normalized copy, which is
+// capped for the GPU, and only converts it to sRGB and applies the EXIF orientation.
+func GenerateDeepZoom(ctx context.Context, inputPath string, outputDir string) (*DeepZoomPyramid, error) {
+	pyramid := &DeepZoomPyramid{Dir: filepath.Join(outputDir, "deepzoom"), TileSize: deepZoomTileSize, Overlap: deepZoomOverlap}
+
+	err := Converters().Run(ctx, func(ctx context.Context) error {
+		decoded, err := decodeImageFile(ctx, inputPath)
+		if err != nil {
+			return err
+		}
+		img := toNRGBA(decoded, &NormalizationReport{})
+		if metadata, err := readImageMetadata(inputPath); err == nil {
+			convertToSRGB(img, metadata)
+			img = applyOrientation(img, metadata.Orientation)
+		}
+		pyramid.Width, pyramid.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		pyramid.MaxLevel = int(math.Ceil(math.Log2(float64(max(pyramid.Width, pyramid.Height)))))
+
+		for level := pyramid.MaxLevel; level >= 0; level-- {
+			if level < pyramid.MaxLevel {
+				// Each level is scaled from the one above, which is cheaper than from the original
+				width, height := DeepZoomLevelSize(pyramid.Width, pyramid.Height, pyramid.MaxLevel, level)
+				next := image.NewNRGBA(image.Rect(0, 0, width, height))
+				draw.BiLinear.Scale(next, next.Bounds(), img, img.Bounds(), draw.Src, nil)
+				img = next
+			}
+			if err := pyramid.writeLevel(ctx, img, level); err != nil {
+				return err
+			}
+		}
+		return pyramid.writeDescriptor()
+	})
+	if err != nil {
+		_ = os.RemoveAll(pyramid.Dir)
+		return nil, err
+	}
+	return pyramid, nil
+}
+
+// writeLevel cuts one level into tiles, each extended by the overlap on the sides that have
+// a neighbour.
+func (p *DeepZoomPyramid) writeLevel(ctx context.Context, img *image.NRGBA, level int) error {
+	dir := filepath.Join(p.Dir, deepZoomName+"_files", strconv.Itoa(level))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	for y := 0; y*p.TileSize < height; y++ {
+		for x := 0; x*p.TileSize < width; x++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rect := image.Rect(x*p.TileSize-p.Overlap, y*p.TileSize-p.Overlap, (x+1)*p.TileSize+p.Overlap, (y+1)*p.TileSize+p.Overlap).
+				Intersect(img.Bounds())
+			// Copy the tile out, the encoder expects an image starting at 0,0
+			tile := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+			draw.Copy(tile, image.Point{}, img, rect, draw.Src, nil)
+			if err := encodeWebPFile(filepath.Join(p.Dir, filepath.FromSlash(p.TilePath(level, x, y))), tile, deepZoomQuality); err != nil {
+				return err
+			}
+			p.Tiles++
+		}
+	}
+	return nil
+}
+
+func (p *DeepZoomPyramid) writeDescriptor() error {
+	descriptor := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="webp" Overlap="%d" TileSize="%d">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, p.Overlap, p.TileSize, p.Width, p.Height)
+	return os.WriteFile(filepath.Join(p.Dir, p.Descriptor()), []byte(descriptor), 0o644)
+}
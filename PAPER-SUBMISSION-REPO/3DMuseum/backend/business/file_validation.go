@@ -0,0 +1,575 @@
+package business
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidContent is wrapped by every *ValidationError.
+var ErrInvalidContent = errors.New("invalid file content")
+
+// Validation error codes
+const (
+	ValidationEmptyFile    = "empty_file"
+	ValidationTypeMismatch = "type_mismatch" // the content is not what the extension claims
+	ValidationCorruptImage = "corrupt_image"
+	ValidationCorruptModel = "corrupt_model"
+	ValidationCorruptVideo = "corrupt_video"
+)
+
+// ValidationError explains why an uploaded file was rejected before conversion.
+type ValidationError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidContent
+}
+
+func invalid(code string, format string, args ...any) *ValidationError {
+	return &ValidationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ConverterLimitCode reports an upload whose conversion was stopped by ErrConverterLimit.
+const ConverterLimitCode = "converter_limit_exceeded"
+
+// ErrorCode returns the code that tells clients why an upload failed: the code of a
+// *ValidationError, ConverterLimitCode, or "" for anything else.
+func ErrorCode(err error) string {
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return validationErr.Code
+	case errors.Is(err, ErrConverterLimit):
+		return ConverterLimitCode
+	}
+	return ""
+}
+
+// Largest glTF JSON document we are willing to parse.
+const maxGLTFJSONSize = 64 << 20
+
+// ValidateFile sniffs the magic bytes of the file at path and checks its structure against
+// the type claimed by fileName's extension. It returns a *ValidationError on mismatch.
+func ValidateFile(path string, fileName string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+	if size == 0 {
+		return invalid(ValidationEmptyFile, "%s is empty", fileName)
+	}
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	header = header[:n]
+
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	detected := sniffContent(header)
+	if !extensionMatches(ext, detected) {
+		if detected == "" {
+			return invalid(ValidationTypeMismatch, "%s does not contain %s data", fileName, strings.ToUpper(ext))
+		}
+		return invalid(ValidationTypeMismatch, "%s is named .%s but contains %s data", fileName, ext, detected)
+	}
+
+	switch detected {
+	case "PNG", "JPEG", "WebP", "GIF", "TIFF", "BMP":
+		// Header and frame table only, the pixels are decoded later by the converters
+		if _, _, err := CheckDecodeLimits(path); err != nil {
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				validationErr.Message = fileName + ": " + validationErr.Message
+			}
+			return err
+		}
+		return nil
+	case "KTX2":
+		return validateKTX2(file, size, fileName)
+	case "GLB":
+		return validateGLB(file, size, fileName)
+	case "glTF JSON":
+		return validateGLTFJSON(io.NewSectionReader(file, 0, size), size, fileName)
+	case "ISO media":
+		if ext == "heic" || ext == "heif" {
+			return validateHEIF(file, size, fileName)
+		}
+		return validateISOMedia(file, size, fileName, ext)
+	case "XML":
+		return validateSVG(io.NewSectionReader(file, 0, size), fileName)
+	case "AVI":
+		return validateAVI(file, size, fileName)
+	}
+	return nil
+}
+
+// sniffContent names the format found in the first bytes of a file, or "" when unknown.
+func sniffContent(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "PNG"
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return "JPEG"
+	case bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a")):
+		return "GIF"
+	case bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "TIFF"
+	case bytes.HasPrefix(header, []byte("BM")):
+		return "BMP"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "WebP"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "AVI ":
+		return "AVI"
+	case bytes.HasPrefix(header, []byte("\xABKTX 20\xBB\r\n\x1A\n")):
+		return "KTX2"
+	case bytes.HasPrefix(header, []byte("glTF")):
+		return "GLB"
+	case len(header) >= 8 && isISOMediaBox(string(header[4:8])):
+		return "ISO media"
+	case bytes.HasPrefix(bytes.TrimLeft(header, " \t\r\n\xEF\xBB\xBF"), []byte("{")):
+		return "glTF JSON"
+	case bytes.HasPrefix(bytes.TrimLeft(header, " \t\r\n\xEF\xBB\xBF"), []byte("<")):
+		return "XML"
+	}
+	return ""
+}
+
+func extensionMatches(ext string, detected string) bool {
+	switch ext {
+	case "png":
+		return detected == "PNG"
+	case "jpg", "jpeg":
+		return detected == "JPEG"
+	case "webp":
+		return detected == "WebP"
+	case "gif":
+		return detected == "GIF"
+	case "tif", "tiff":
+		return detected == "TIFF"
+	case "bmp":
+		return detected == "BMP"
+	case "heic", "heif":
+		return detected == "ISO media"
+	case "svg":
+		return detected == "XML"
+	case "ktx2":
+		return detected == "KTX2"
+	case "glb":
+		return detected == "GLB"
+	case "gltf":
+		return detected == "glTF JSON"
+	case "mp4", "mov":
+		return detected == "ISO media"
+	case "avi":
+		return detected == "AVI"
+	}
+	return false
+}
+
+// validateKTX2 checks the fixed KTX2 header and that every mip level lies inside the file.
+func validateKTX2(r io.ReaderAt, size int64, fileName string) error {
+	header := make([]byte, 80)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return invalid(ValidationCorruptImage, "%s has a truncated KTX2 header", fileName)
+	}
+	le := binary.LittleEndian
+	width := le.Uint32(header[20:])
+	faceCount := le.Uint32(header[36:])
+	levelCount := max(le.Uint32(header[40:]), 1)
+	supercompression := le.Uint32(header[44:])
+
+	switch {
+	case width == 0:
+		return invalid(ValidationCorruptImage, "%s has a zero pixel width", fileName)
+	case faceCount != 1 && faceCount != 6:
+		return invalid(ValidationCorruptImage, "%s has %d faces, expected 1 or 6", fileName, faceCount)
+	case supercompression > 3:
+		return invalid(ValidationCorruptImage, "%s uses unknown supercompression scheme %d", fileName, supercompression)
+	case levelCount > 32:
+		return invalid(ValidationCorruptImage, "%s declares %d mip levels", fileName, levelCount)
+	}
+
+	index := make([]byte, 24*levelCount)
+	if _, err := r.ReadAt(index, 80); err != nil {
+		return invalid(ValidationCorruptImage, "%s has a truncated level index", fileName)
+	}
+	for level := uint32(0); level < levelCount; level++ {
+		offset := le.Uint64(index[level*24:])
+		length := le.Uint64(index[level*24+8:])
+		if offset > uint64(size) || length > uint64(size)-offset {
+			return invalid(ValidationCorruptImage, "%s mip level %d lies outside the file", fileName, level)
+		}
+	}
+	return nil
+}
+
+const (
+	glbChunkJSON = 0x4E4F534A
+	glbChunkBIN  = 0x004E4942
+)
+
+// validateGLB walks the GLB container: header, JSON chunk, optional BIN chunk.
+func validateGLB(r io.ReaderAt, size int64, fileName string) error {
+	header := make([]byte, 20)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return invalid(ValidationCorruptModel, "%s has a truncated GLB header", fileName)
+	}
+	le := binary.LittleEndian
+	if version := le.Uint32(header[4:]); version != 2 {
+		return invalid(ValidationCorruptModel, "%s is GLB version %d, only version 2 is supported", fileName, version)
+	}
+	if length := int64(le.Uint32(header[8:])); length != size {
+		return invalid(ValidationCorruptModel, "%s declares %d bytes but is %d bytes long", fileName, length, size)
+	}
+
+	jsonLength := int64(le.Uint32(header[12:]))
+	if le.Uint32(header[16:]) != glbChunkJSON {
+		return invalid(ValidationCorruptModel, "%s does not start with a JSON chunk", fileName)
+	}
+	if jsonLength > maxGLTFJSONSize || 20+jsonLength > size {
+		return invalid(ValidationCorruptModel, "%s has a JSON chunk of %d bytes that does not fit the file", fileName, jsonLength)
+	}
+
+	var binLength int64 = -1
+	next := 20 + jsonLength
+	if next+8 <= size {
+		chunk := make([]byte, 8)
+		if _, err := r.ReadAt(chunk, next); err != nil {
+			return invalid(ValidationCorruptModel, "%s has a truncated chunk header", fileName)
+		}
+		if le.Uint32(chunk[4:]) == glbChunkBIN {
+			binLength = int64(le.Uint32(chunk))
+			if next+8+binLength > size {
+				return invalid(ValidationCorruptModel, "%s has a BIN chunk that runs past the end of the file", fileName)
+			}
+		}
+	}
+
+	return checkGLTFDocument(io.NewSectionReader(r, 20, jsonLength), fileName, binLength)
+}
+
+func validateGLTFJSON(r io.Reader, size int64, fileName string) error {
+	if size > maxGLTFJSONSize {
+		return invalid(ValidationCorruptModel, "%s is larger than the %d MB glTF JSON limit", fileName, maxGLTFJSONSize>>20)
+	}
+	return checkGLTFDocument(r, fileName, -1)
+}
+
+// gltfDocument holds just the parts of a glTF document that are cross-checked.
+type gltfDocument struct {
+	Asset *struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	Buffers []struct {
+		ByteLength int64  `json:"byteLength"`
+		URI        string `json:"uri"`
+	} `json:"buffers"`
+	BufferViews []struct {
+		Buffer     int   `json:"buffer"`
+		ByteOffset int64 `json:"byteOffset"`
+		ByteLength int64 `json:"byteLength"`
+	} `json:"bufferViews"`
+	Accessors []struct {
+		BufferView *int `json:"bufferView"`
+	} `json:"accessors"`
+}
+
+// checkGLTFDocument parses the glTF JSON and checks that buffer views and accessors point at
+// things that exist. binLength is the GLB BIN chunk size, or -1 when there is none.
+func checkGLTFDocument(r io.Reader, fileName string, binLength int64) error {
+	var doc gltfDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return invalid(ValidationCorruptModel, "%s has malformed glTF JSON: %v", fileName, err)
+	}
+	if doc.Asset == nil || !strings.HasPrefix(doc.Asset.Version, "2.") {
+		return invalid(ValidationCorruptModel, "%s is not a glTF 2.x document (asset.version missing or unsupported)", fileName)
+	}
+
+	for i, buffer := range doc.Buffers {
+		if buffer.ByteLength < 0 {
+			return invalid(ValidationCorruptModel, "%s buffer %d has a negative byteLength", fileName, i)
+		}
+		if i == 0 && buffer.URI == "" && binLength >= 0 && buffer.ByteLength > binLength {
+			return invalid(ValidationCorruptModel, "%s buffer 0 needs %d bytes but the BIN chunk has %d", fileName, buffer.ByteLength, binLength)
+		}
+	}
+	for i, view := range doc.BufferViews {
+		if view.Buffer < 0 || view.Buffer >= len(doc.Buffers) {
+			return invalid(ValidationCorruptModel, "%s bufferView %d references missing buffer %d", fileName, i, view.Buffer)
+		}
+		if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > doc.Buffers[view.Buffer].ByteLength {
+			return invalid(ValidationCorruptModel, "%s bufferView %d lies outside buffer %d", fileName, i, view.Buffer)
+		}
+	}
+	for i, accessor := range doc.Accessors {
+		if accessor.BufferView != nil && (*accessor.BufferView < 0 || *accessor.BufferView >= len(doc.BufferViews)) {
+			return invalid(ValidationCorruptModel, "%s accessor %d references missing bufferView %d", fileName, i, *accessor.BufferView)
+		}
+	}
+	return nil
+}
+
+// Top-level box types that may open an MP4/QuickTime file.
+var isoMediaLeadingBoxes = []string{"ftyp", "moov", "mdat", "free", "skip", "wide", "pnot"}
+
+func isISOMediaBox(kind string) bool {
+	return slices.Contains(isoMediaLeadingBoxes, kind)
+}
+
+// validateISOMedia walks the top-level boxes of an MP4/MOV file; every box must fit the file
+// and a moov box (the movie header) must be present.
+func validateISOMedia(r io.ReaderAt, size int64, fileName string, ext string) error {
+	boxes, err := readISOBoxes(r, size, fileName, ValidationCorruptVideo)
+	if err != nil {
+		return err
+	}
+
+	if ext == "mp4" && boxes[0] != "ftyp" {
+		return invalid(ValidationCorruptVideo, "%s does not start with an ftyp box", fileName)
+	}
+	if slices.Contains(boxes, "moov") {
+		return nil
+	}
+	return invalid(ValidationCorruptVideo, "%s has no moov box, the movie header is missing", fileName)
+}
+
+// Brands in the ftyp box of HEIC/HEIF still images and sequences
+var heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1"}
+
+// validateHEIF checks the box structure of a HEIC/HEIF image, that its ftyp box names a HEIF
+// brand, that the meta box, which holds the image items, is present and that the largest image
+// item stays within the decode limits, before heif-convert gets to decode it.
+func validateHEIF(r io.ReaderAt, size int64, fileName string) error {
+	boxes, err := readISOBoxes(r, size, fileName, ValidationCorruptImage)
+	if err != nil {
+		return err
+	}
+	if boxes[0] != "ftyp" {
+		return invalid(ValidationCorruptImage, "%s does not start with an ftyp box", fileName)
+	}
+
+	ftyp := make([]byte, 64)
+	n, _ := r.ReadAt(ftyp, 0)
+	ftyp = ftyp[:min(n, int(binary.BigEndian.Uint32(ftyp)))]
+	heif := false
+	// Major brand, then the compatible brands after the minor version
+	for offset := 8; offset+4 <= len(ftyp); offset += 4 {
+		if offset != 12 && slices.Contains(heifBrands, string(ftyp[offset:offset+4])) {
+			heif = true
+		}
+	}
+	if !heif {
+		return invalid(ValidationTypeMismatch, "%s is ISO media but not a HEIF image", fileName)
+	}
+	if !slices.Contains(boxes, "meta") {
+		return invalid(ValidationCorruptImage, "%s has no meta box, the image items are missing", fileName)
+	}
+
+	width, height, err := heifImageSize(r, size)
+	if err != nil {
+		return invalid(ValidationCorruptImage, "%s: %v", fileName, err)
+	}
+	limits := limitsFor("heif")
+	// Checked by division first, the extents are 32-bit and their product can overflow
+	if width > limits.MaxPixels/height {
+		return invalid(ValidationPixelLimit, "heif image is %dx%d, the limit is %.1f MP",
+			width, height, float64(limits.MaxPixels)/1e6)
+	}
+	pixels := width * height
+	if pixels > limits.MaxPixels {
+		return invalid(ValidationPixelLimit, "heif image is %dx%d (%.1f MP), the limit is %.1f MP",
+			width, height, float64(pixels)/1e6, float64(limits.MaxPixels)/1e6)
+	}
+	if decoded := pixels * 4; decoded > limits.MaxBytes {
+		return invalid(ValidationByteLimit, "heif image would take %d MB to decode, the limit is %d MB",
+			decoded>>20, limits.MaxBytes>>20)
+	}
+	return nil
+}
+
+// heifImageSize returns the largest image spatial extent (ispe) property in meta/iprp/ipco.
+// A grid image carries the size of the whole grid, its tiles their own.
+func heifImageSize(r io.ReaderAt, size int64) (int64, int64, error) {
+	// meta is a full box, its children start after the version and flags
+	start, end, err := findISOBox(r, 0, size, "meta")
+	if err != nil {
+		return 0, 0, err
+	}
+	if start, end, err = findISOBox(r, start+4, end, "iprp"); err != nil {
+		return 0, 0, err
+	}
+	if start, end, err = findISOBox(r, start, end, "ipco"); err != nil {
+		return 0, 0, err
+	}
+
+	var width, height int64
+	err = walkISOBoxes(r, start, end, func(kind string, body, end int64) error {
+		if kind != "ispe" {
+			return nil
+		}
+		// Version and flags, then 32-bit width and height
+		extent := make([]byte, 12)
+		if end-body < 12 {
+			return errors.New("ispe box is truncated")
+		}
+		if _, err := r.ReadAt(extent, body); err != nil {
+			return err
+		}
+		w, h := int64(binary.BigEndian.Uint32(extent[4:])), int64(binary.BigEndian.Uint32(extent[8:]))
+		if uint64(w)*uint64(h) > uint64(width)*uint64(height) {
+			width, height = w, h
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, errors.New("no image has a size (ispe) property")
+	}
+	return width, height, nil
+}
+
+// findISOBox returns where the body of the first kind box between start and end begins and ends.
+func findISOBox(r io.ReaderAt, start, end int64, kind string) (int64, int64, error) {
+	errFound := errors.New("found")
+	var bodyStart, bodyEnd int64
+	err := walkISOBoxes(r, start, end, func(k string, body, end int64) error {
+		if k != kind {
+			return nil
+		}
+		bodyStart, bodyEnd = body, end
+		return errFound
+	})
+	if err == nil {
+		return 0, 0, fmt.Errorf("no %s box", kind)
+	}
+	if err != errFound {
+		return 0, 0, err
+	}
+	return bodyStart, bodyEnd, nil
+}
+
+// walkISOBoxes calls fn with the type and body bounds of each box between start and end, stopping
+// at the first error. Every box must fit in end.
+func walkISOBoxes(r io.ReaderAt, start, end int64, fn func(kind string, body, end int64) error) error {
+	header := make([]byte, 16)
+	for offset := start; offset < end; {
+		if offset+8 > end {
+			return errors.New("truncated box header")
+		}
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return errors.New("truncated box header")
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		kind := string(header[4:8])
+		switch boxSize {
+		case 0:
+			boxSize = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return errors.New("truncated box header")
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if boxSize < headerSize || boxSize > end-offset {
+			return fmt.Errorf("box %q at offset %d runs past its parent", kind, offset)
+		}
+		if err := fn(kind, offset+headerSize, offset+boxSize); err != nil {
+			return err
+		}
+		offset += boxSize
+	}
+	return nil
+}
+
+// readISOBoxes lists the top-level boxes of an ISO base media file; every box must fit the file.
+// Problems are reported with the given validation code.
+func readISOBoxes(r io.ReaderAt, size int64, fileName string, code string) ([]string, error) {
+	var offset int64
+	var boxes []string
+	header := make([]byte, 16)
+	for offset < size {
+		if offset+8 > size {
+			return nil, invalid(code, "%s has trailing garbage after the last box", fileName)
+		}
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, invalid(code, "%s has a truncated box header", fileName)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		switch boxSize {
+		case 0: // box extends to the end of the file
+			boxSize = size - offset
+		case 1: // 64-bit size follows the type
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, invalid(code, "%s has a truncated box header", fileName)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if boxSize < 8 || boxSize > size-offset {
+			return nil, invalid(code, "%s box %q at offset %d runs past the end of the file (truncated upload?)", fileName, kind, offset)
+		}
+		boxes = append(boxes, kind)
+		offset += boxSize
+	}
+	return boxes, nil
+}
+
+// validateSVG checks that the file is well-formed XML up to its root element, and that the root
+// is <svg>. encoding/xml, which the rasterizer uses as well, never expands declared entities.
+func validateSVG(r io.Reader, fileName string) error {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return invalid(ValidationCorruptImage, "%s is not well-formed XML: %v", fileName, err)
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			if element.Name.Local != "svg" {
+				return invalid(ValidationTypeMismatch, "%s is XML with a <%s> root, not SVG", fileName, element.Name.Local)
+			}
+			return nil
+		}
+	}
+}
+
+// validateAVI checks the RIFF size and that the header list comes first.
+func validateAVI(r io.ReaderAt, size int64, fileName string) error {
+	header := make([]byte, 24)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return invalid(ValidationCorruptVideo, "%s has a truncated AVI header", fileName)
+	}
+	if riffSize := int64(binary.LittleEndian.Uint32(header[4:])) + 8; riffSize > size {
+		return invalid(ValidationCorruptVideo, "%s declares %d bytes but is %d bytes long", fileName, riffSize, size)
+	}
+	if string(header[12:16]) != "LIST" || string(header[20:24]) != "hdrl" {
+		return invalid(ValidationCorruptVideo, "%s has no AVI header list", fileName)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package business
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// isoBox wraps body in a box of the given type.
+func isoBox(kind string, body ...[]byte) []byte {
+	content := bytes.Join(body, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	return append(append(box, kind...), content...)
+}
+
+// testHEIF builds the boxes validateHEIF reads: ftyp and a meta box whose ipco holds one ispe
+// property per size.
+func testHEIF(sizes ...[2]uint32) []byte {
+	var properties [][]byte
+	for _, size := range sizes {
+		extent := binary.BigEndian.AppendUint32(make([]byte, 4), size[0])
+		properties = append(properties, isoBox("ispe", binary.BigEndian.AppendUint32(extent, size[1])))
+	}
+	ftyp := isoBox("ftyp", []byte("heic"), make([]byte, 4), []byte("mif1heic"))
+	meta := isoBox("meta", make([]byte, 4), isoBox("hdlr", make([]byte, 24)), isoBox("iprp", isoBox("ipco", properties...)))
+	return append(ftyp, meta...)
+}
+
+func TestValidateHEIFImageSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes [][2]uint32
+		code  string
+	}{
+		{"small", [][2]uint32{{4032, 3024}}, ""},
+		{"grid of tiles", [][2]uint32{{512, 512}, {512, 512}, {8000, 6000}}, ""},
+		{"too many pixels", [][2]uint32{{512, 512}, {20000, 20000}}, ValidationPixelLimit},
+		{"overflowing extent", [][2]uint32{{0xFFFFFFFF, 0xFFFFFFFF}}, ValidationPixelLimit},
+		{"no ispe", nil, ValidationCorruptImage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := testHEIF(test.sizes...)
+			err := validateHEIF(bytes.NewReader(data), int64(len(data)), "photo.heic")
+			var validationErr *ValidationError
+			switch {
+			case test.code == "" && err != nil:
+				t.Errorf("validateHEIF: %v", err)
+			case test.code != "" && (!errors.As(err, &validationErr) || validationErr.Code != test.code):
+				t.Errorf("validateHEIF: %v, want code %s", err, test.code)
+			}
+		})
+	}
+}
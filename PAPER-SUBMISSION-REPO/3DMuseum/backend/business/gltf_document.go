@@ -0,0 +1,404 @@
+package business
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The glTF types below model the parts of a glTF 2.0 document the optimizer rewrites.
+// Everything it does not need to understand (materials, samplers, cameras, extras,
+// extensions) is carried through as raw JSON so nothing is lost on the way out.
+
+type gltfRoot struct {
+	Asset              json.RawMessage            `json:"asset"`
+	ExtensionsUsed     []string                   `json:"extensionsUsed,omitempty"`
+	ExtensionsRequired []string                   `json:"extensionsRequired,omitempty"`
+	Scene              *int                       `json:"scene,omitempty"`
+	Scenes             []gltfScene                `json:"scenes,omitempty"`
+	Nodes              []gltfNode                 `json:"nodes,omitempty"`
+	Meshes             []gltfMesh                 `json:"meshes,omitempty"`
+	Materials          []json.RawMessage          `json:"materials,omitempty"`
+	Textures           []gltfTexture              `json:"textures,omitempty"`
+	Images             []gltfImage                `json:"images,omitempty"`
+	Samplers           []json.RawMessage          `json:"samplers,omitempty"`
+	Accessors          []gltfAccessor             `json:"accessors,omitempty"`
+	BufferViews        []gltfBufferView           `json:"bufferViews,omitempty"`
+	Buffers            []gltfBuffer               `json:"buffers,omitempty"`
+	Skins              []gltfSkin                 `json:"skins,omitempty"`
+	Animations         []gltfAnimation            `json:"animations,omitempty"`
+	Cameras            []json.RawMessage          `json:"cameras,omitempty"`
+	Extensions         map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras             json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfScene struct {
+	Nodes      []int                      `json:"nodes,omitempty"`
+	Name       string                     `json:"name,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfNode struct {
+	Camera      *int                       `json:"camera,omitempty"`
+	Children    []int                      `json:"children,omitempty"`
+	Skin        *int                       `json:"skin,omitempty"`
+	Matrix      []float64                  `json:"matrix,omitempty"`
+	Mesh        *int                       `json:"mesh,omitempty"`
+	Rotation    []float64                  `json:"rotation,omitempty"`
+	Scale       []float64                  `json:"scale,omitempty"`
+	Translation []float64                  `json:"translation,omitempty"`
+	Weights     []float64                  `json:"weights,omitempty"`
+	Name        string                     `json:"name,omitempty"`
+	Extensions  map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras      json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfMesh struct {
+	Primitives []gltfPrimitive            `json:"primitives"`
+	Weights    []float64                  `json:"weights,omitempty"`
+	Name       string                     `json:"name,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int             `json:"attributes"`
+	Indices    *int                       `json:"indices,omitempty"`
+	Material   *int                       `json:"material,omitempty"`
+	Mode       *int                       `json:"mode,omitempty"`
+	Targets    []map[string]int           `json:"targets,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfTexture struct {
+	Sampler    *int                       `json:"sampler,omitempty"`
+	Source     *int                       `json:"source,omitempty"`
+	Name       string                     `json:"name,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfImage struct {
+	URI        string                     `json:"uri,omitempty"`
+	MimeType   string                     `json:"mimeType,omitempty"`
+	BufferView *int                       `json:"bufferView,omitempty"`
+	Name       string                     `json:"name,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int                       `json:"bufferView,omitempty"`
+	ByteOffset    int                        `json:"byteOffset,omitempty"`
+	ComponentType int                        `json:"componentType"`
+	Normalized    bool                       `json:"normalized,omitempty"`
+	Count         int                        `json:"count"`
+	Type          string                     `json:"type"`
+	Max           []float64                  `json:"max,omitempty"`
+	Min           []float64                  `json:"min,omitempty"`
+	Sparse        *gltfSparse                `json:"sparse,omitempty"`
+	Name          string                     `json:"name,omitempty"`
+	Extensions    map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras        json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfSparse struct {
+	Count   int `json:"count"`
+	Indices struct {
+		BufferView    int             `json:"bufferView"`
+		ByteOffset    int             `json:"byteOffset,omitempty"`
+		ComponentType int             `json:"componentType"`
+		Extras        json.RawMessage `json:"extras,omitempty"`
+	} `json:"indices"`
+	Values struct {
+		BufferView int             `json:"bufferView"`
+		ByteOffset int             `json:"byteOffset,omitempty"`
+		Extras     json.RawMessage `json:"extras,omitempty"`
+	} `json:"values"`
+	Extras json.RawMessage `json:"extras,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int                        `json:"buffer"`
+	ByteOffset int                        `json:"byteOffset,omitempty"`
+	ByteLength int                        `json:"byteLength"`
+	ByteStride int                        `json:"byteStride,omitempty"`
+	Target     int                        `json:"target,omitempty"`
+	Name       string                     `json:"name,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfBuffer struct {
+	URI        string                     `json:"uri,omitempty"`
+	ByteLength int                        `json:"byteLength"`
+	Name       string                     `json:"name,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfSkin struct {
+	InverseBindMatrices *int                       `json:"inverseBindMatrices,omitempty"`
+	Skeleton            *int                       `json:"skeleton,omitempty"`
+	Joints              []int                      `json:"joints"`
+	Name                string                     `json:"name,omitempty"`
+	Extensions          map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras              json.RawMessage            `json:"extras,omitempty"`
+}
+
+type gltfAnimation struct {
+	Channels []struct {
+		Sampler int `json:"sampler"`
+		Target  struct {
+			Node       *int                       `json:"node,omitempty"`
+			Path       string                     `json:"path"`
+			Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+			Extras     json.RawMessage            `json:"extras,omitempty"`
+		} `json:"target"`
+		Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+		Extras     json.RawMessage            `json:"extras,omitempty"`
+	} `json:"channels"`
+	Samplers []struct {
+		Input         int                        `json:"input"`
+		Interpolation string                     `json:"interpolation,omitempty"`
+		Output        int                        `json:"output"`
+		Extensions    map[string]json.RawMessage `json:"extensions,omitempty"`
+		Extras        json.RawMessage            `json:"extras,omitempty"`
+	} `json:"samplers"`
+	Name       string                     `json:"name,omitempty"`
+	Extensions map[string]json.RawMessage `json:"extensions,omitempty"`
+	Extras     json.RawMessage            `json:"extras,omitempty"`
+}
+
+// gltfAsset is a parsed model with the bytes of every buffer resolved.
+type gltfAsset struct {
+	Root    gltfRoot
+	Buffers [][]byte
+}
+
+const (
+	glbMagic   = 0x46546C67
+	glbVersion = 2
+)
+
+// readGLTFAsset loads a .glb or a self-contained .gltf (buffers as data: URIs).
+func readGLTFAsset(path string) (*gltfAsset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jsonChunk, binChunk []byte
+	if len(data) >= 12 && binary.LittleEndian.Uint32(data) == glbMagic {
+		jsonChunk, binChunk, err = splitGLB(data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		jsonChunk = data
+	}
+
+	asset := &gltfAsset{}
+	if err := json.Unmarshal(jsonChunk, &asset.Root); err != nil {
+		return nil, fmt.Errorf("malformed glTF JSON: %w", err)
+	}
+
+	for i, buffer := range asset.Root.Buffers {
+		var content []byte
+		switch {
+		case buffer.URI == "" && i == 0 && binChunk != nil:
+			content = binChunk
+		case strings.HasPrefix(buffer.URI, "data:"):
+			comma := strings.IndexByte(buffer.URI, ',')
+			if comma < 0 || !strings.Contains(buffer.URI[:comma], ";base64") {
+				return nil, fmt.Errorf("buffer %d has an unsupported data URI", i)
+			}
+			content, err = base64.StdEncoding.DecodeString(buffer.URI[comma+1:])
+			if err != nil {
+				return nil, fmt.Errorf("buffer %d has an invalid data URI: %w", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("%w: buffer %d points at external file %q", errExternalGLTFResource, i, buffer.URI)
+		}
+		if len(content) < buffer.ByteLength {
+			return nil, fmt.Errorf("buffer %d holds %d bytes, %d declared", i, len(content), buffer.ByteLength)
+		}
+		asset.Buffers = append(asset.Buffers, content[:buffer.ByteLength])
+	}
+	return asset, nil
+}
+
+var errExternalGLTFResource = errors.New("glTF references an external resource")
+
+func splitGLB(data []byte) ([]byte, []byte, error) {
+	le := binary.LittleEndian
+	if version := le.Uint32(data[4:]); version != glbVersion {
+		return nil, nil, fmt.Errorf("unsupported GLB version %d", version)
+	}
+	var jsonChunk, binChunk []byte
+	for offset := 12; offset+8 <= len(data); {
+		length := int(le.Uint32(data[offset:]))
+		kind := le.Uint32(data[offset+4:])
+		start := offset + 8
+		if length < 0 || start+length > len(data) {
+			return nil, nil, errors.New("GLB chunk runs past the end of the file")
+		}
+		switch {
+		case kind == glbChunkJSON && jsonChunk == nil:
+			jsonChunk = data[start : start+length]
+		case kind == glbChunkBIN && binChunk == nil:
+			binChunk = data[start : start+length]
+		}
+		offset = start + length
+	}
+	if jsonChunk == nil {
+		return nil, nil, errors.New("GLB has no JSON chunk")
+	}
+	return jsonChunk, binChunk, nil
+}
+
+// bufferViewBytes returns the bytes a buffer view covers.
+func (a *gltfAsset) bufferViewBytes(index int) ([]byte, error) {
+	if index < 0 || index >= len(a.Root.BufferViews) {
+		return nil, fmt.Errorf("bufferView %d does not exist", index)
+	}
+	view := a.Root.BufferViews[index]
+	if view.Buffer < 0 || view.Buffer >= len(a.Buffers) {
+		return nil, fmt.Errorf("bufferView %d references missing buffer %d", index, view.Buffer)
+	}
+	buffer := a.Buffers[view.Buffer]
+	// Compared without adding them, huge values from the JSON must not wrap around
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset > len(buffer) || view.ByteLength > len(buffer)-view.ByteOffset {
+		return nil, fmt.Errorf("bufferView %d lies outside buffer %d", index, view.Buffer)
+	}
+	return buffer[view.ByteOffset : view.ByteOffset+view.ByteLength], nil
+}
+
+var gltfComponentSize = map[int]int{5120: 1, 5121: 1, 5122: 2, 5123: 2, 5125: 4, 5126: 4}
+
+var gltfTypeComponents = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT2": 4, "MAT3": 9, "MAT4": 16}
+
+// elementSize is the byte size of one accessor element, including the column padding
+// the spec requires for 1- and 2-byte matrices.
+func (acc gltfAccessor) elementSize() int {
+	size := gltfComponentSize[acc.ComponentType]
+	switch {
+	case acc.Type == "MAT2" && size == 1:
+		return 8
+	case acc.Type == "MAT3" && size == 1:
+		return 12
+	case acc.Type == "MAT3" && size == 2:
+		return 24
+	}
+	return size * gltfTypeComponents[acc.Type]
+}
+
+// accessorBytes returns the tightly packed elements of a non-sparse accessor.
+func (a *gltfAsset) accessorBytes(acc gltfAccessor) ([]byte, error) {
+	if acc.BufferView == nil {
+		return nil, errors.New("accessor has no bufferView")
+	}
+	view, err := a.bufferViewBytes(*acc.BufferView)
+	if err != nil {
+		return nil, err
+	}
+	elementSize := acc.elementSize()
+	if elementSize == 0 {
+		return nil, fmt.Errorf("accessor has unknown type %s/%d", acc.Type, acc.ComponentType)
+	}
+	stride := a.Root.BufferViews[*acc.BufferView].ByteStride
+	if stride == 0 {
+		stride = elementSize
+	}
+	if acc.ByteOffset < 0 || acc.Count < 0 {
+		return nil, errors.New("accessor has a negative byteOffset or count")
+	}
+	if stride < elementSize {
+		return nil, fmt.Errorf("bufferView byteStride %d is smaller than the accessor element", stride)
+	}
+	// The last element has to end inside the view, checked by division so a huge count cannot overflow
+	if acc.Count > 0 {
+		available := len(view) - acc.ByteOffset
+		if available < elementSize || acc.Count-1 > (available-elementSize)/stride {
+			return nil, errors.New("accessor lies outside its bufferView")
+		}
+	}
+
+	if stride == elementSize {
+		return view[acc.ByteOffset : acc.ByteOffset+acc.Count*elementSize], nil
+	}
+	packed := make([]byte, 0, acc.Count*elementSize)
+	for i := 0; i < acc.Count; i++ {
+		start := acc.ByteOffset + i*stride
+		packed = append(packed, view[start:start+elementSize]...)
+	}
+	return packed, nil
+}
+
+// readIndices returns the index list of an index accessor.
+func (a *gltfAsset) readIndices(acc gltfAccessor) ([]uint32, error) {
+	data, err := a.accessorBytes(acc)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]uint32, acc.Count)
+	for i := range indices {
+		switch acc.ComponentType {
+		case 5121:
+			indices[i] = uint32(data[i])
+		case 5123:
+			indices[i] = uint32(binary.LittleEndian.Uint16(data[i*2:]))
+		case 5125:
+			indices[i] = binary.LittleEndian.Uint32(data[i*4:])
+		default:
+			return nil, fmt.Errorf("component type %d is not valid for indices", acc.ComponentType)
+		}
+	}
+	return indices, nil
+}
+
+// writeGLB serialises the document with a single binary buffer as a GLB file.
+func writeGLB(w io.Writer, root gltfRoot, bin []byte) error {
+	jsonChunk, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+	for len(jsonChunk)%4 != 0 {
+		jsonChunk = append(jsonChunk, ' ')
+	}
+	for len(bin)%4 != 0 {
+		bin = append(bin, 0)
+	}
+
+	total := 12 + 8 + len(jsonChunk)
+	if len(bin) > 0 {
+		total += 8 + len(bin)
+	}
+	var header bytes.Buffer
+	le := binary.LittleEndian
+	_ = binary.Write(&header, le, []uint32{glbMagic, glbVersion, uint32(total), uint32(len(jsonChunk)), glbChunkJSON})
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(jsonChunk); err != nil {
+		return err
+	}
+	if len(bin) == 0 {
+		return nil
+	}
+	header.Reset()
+	_ = binary.Write(&header, le, []uint32{uint32(len(bin)), glbChunkBIN})
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	_, err = w.Write(bin)
+	return err
+}
@@ -0,0 +1,641 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrModelNotOptimizable is returned for models the optimizer cannot rewrite safely;
+// callers pin the original file instead.
+var ErrModelNotOptimizable = errors.New("model cannot be optimized")
+
+// These extensions point into nodes, materials, accessors or buffer views on their own,
+// so pruning and repacking would silently break them.
+var gltfOpaqueExtensions = []string{
+	"KHR_draco_mesh_compression",
+	"EXT_meshopt_compression",
+	"EXT_mesh_gpu_instancing",
+	"KHR_materials_variants",
+	"KHR_animation_pointer",
+	"MSFT_lod",
+}
+
+const khrTextureBasisu = "KHR_texture_basisu"
+
+// ModelOptimizationReport compares the uploaded model with the optimized GLB.
+type ModelOptimizationReport struct {
+	OriginalSize       int64    `json:"original_size"`
+	OptimizedSize      int64    `json:"optimized_size"`
+	OriginalTriangles  int      `json:"original_triangles"`
+	OptimizedTriangles int      `json:"optimized_triangles"`
+	TexturesEncoded    int      `json:"textures_encoded"`
+	AccessorsDeduped   int      `json:"accessors_deduplicated"`
+	NodesRemoved       int      `json:"nodes_removed"`
+	MeshesRemoved      int      `json:"meshes_removed"`
+	MaterialsRemoved   int      `json:"materials_removed"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+// OptimizeModel rewrites a GLB or self-contained glTF as an optimized GLB in outputDir:
+// nodes not reachable from a scene, and the meshes and materials only they used, are dropped,
+// byte-identical accessors are merged, embedded PNG/JPEG textures are re-encoded to KTX2
+// (KHR_texture_basisu) and all remaining data is repacked into a single buffer.
+// Textures sampled as normal maps use the "normal-map" profile, all others use profile.
+func OptimizeModel(ctx context.Context, inputPath string, outputDir string, profile KTX2Profile) (string, *ModelOptimizationReport, error) {
+	stat, err := os.Stat(inputPath)
+	if err != nil {
+		return "", nil, err
+	}
+	asset, err := readGLTFAsset(inputPath)
+	if errors.Is(err, errExternalGLTFResource) {
+		return "", nil, fmt.Errorf("%w: %v", ErrModelNotOptimizable, err)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read model: %w", err)
+	}
+	for _, ext := range asset.Root.ExtensionsUsed {
+		if slices.Contains(gltfOpaqueExtensions, ext) {
+			return "", nil, fmt.Errorf("%w: uses %s", ErrModelNotOptimizable, ext)
+		}
+	}
+
+	report := &ModelOptimizationReport{OriginalSize: stat.Size()}
+	if report.OriginalTriangles, err = asset.countTriangles(); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrModelNotOptimizable, err)
+	}
+
+	report.NodesRemoved = asset.pruneNodes()
+	report.MeshesRemoved = asset.pruneMeshes()
+	report.MaterialsRemoved = asset.pruneMaterials()
+	if report.AccessorsDeduped, err = asset.dedupeAccessors(); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrModelNotOptimizable, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+
+	images, err := asset.encodeTextures(ctx, outputDir, profile, report)
+	if err != nil {
+		return "", nil, err
+	}
+	bin, err := asset.repack(images)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrModelNotOptimizable, err)
+	}
+	if report.OptimizedTriangles, err = asset.countTriangles(); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrModelNotOptimizable, err)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	outputPath := filepath.Join(outputDir, base+".glb")
+	if outputPath == inputPath {
+		outputPath = filepath.Join(outputDir, base+"-optimized.glb")
+	}
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create optimized model: %w", err)
+	}
+	if err := writeGLB(out, asset.Root, bin); err != nil {
+		out.Close()
+		_ = os.Remove(outputPath)
+		return "", nil, fmt.Errorf("failed to write optimized model: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(outputPath)
+		return "", nil, err
+	}
+	if stat, err := os.Stat(outputPath); err == nil {
+		report.OptimizedSize = stat.Size()
+	}
+	return outputPath, report, nil
+}
+
+// countTriangles sums the triangles of every primitive of every mesh.
+func (a *gltfAsset) countTriangles() (int, error) {
+	total := 0
+	for _, mesh := range a.Root.Meshes {
+		for _, primitive := range mesh.Primitives {
+			var count int
+			if primitive.Indices != nil {
+				if *primitive.Indices < 0 || *primitive.Indices >= len(a.Root.Accessors) {
+					return 0, fmt.Errorf("primitive references missing accessor %d", *primitive.Indices)
+				}
+				count = a.Root.Accessors[*primitive.Indices].Count
+			} else if position, ok := primitive.Attributes["POSITION"]; ok {
+				if position < 0 || position >= len(a.Root.Accessors) {
+					return 0, fmt.Errorf("primitive references missing accessor %d", position)
+				}
+				count = a.Root.Accessors[position].Count
+			}
+			mode := 4
+			if primitive.Mode != nil {
+				mode = *primitive.Mode
+			}
+			switch mode {
+			case 4: // TRIANGLES
+				total += count / 3
+			case 5, 6: // TRIANGLE_STRIP, TRIANGLE_FAN
+				total += max(count-2, 0)
+			}
+		}
+	}
+	return total, nil
+}
+
+// remapIndices turns a keep-list into old index -> new index, -1 for dropped entries.
+func remapIndices(keep []bool) ([]int, int) {
+	mapping := make([]int, len(keep))
+	next := 0
+	for i, k := range keep {
+		if k {
+			mapping[i] = next
+			next++
+		} else {
+			mapping[i] = -1
+		}
+	}
+	return mapping, len(keep) - next
+}
+
+func remapPtr(ptr *int, mapping []int) *int {
+	if ptr == nil || *ptr < 0 || *ptr >= len(mapping) || mapping[*ptr] < 0 {
+		return nil
+	}
+	v := mapping[*ptr]
+	return &v
+}
+
+func keepFiltered[T any](items []T, keep []bool) []T {
+	kept := make([]T, 0, len(items))
+	for i, item := range items {
+		if keep[i] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// pruneNodes drops nodes that no scene, skin or animation can reach.
+func (a *gltfAsset) pruneNodes() int {
+	root := &a.Root
+	if len(root.Scenes) == 0 || len(root.Nodes) == 0 {
+		return 0
+	}
+
+	keep := make([]bool, len(root.Nodes))
+	var visit func(int)
+	visit = func(n int) {
+		if n < 0 || n >= len(keep) || keep[n] {
+			return
+		}
+		keep[n] = true
+		for _, child := range root.Nodes[n].Children {
+			visit(child)
+		}
+	}
+	for _, scene := range root.Scenes {
+		for _, n := range scene.Nodes {
+			visit(n)
+		}
+	}
+	for _, skin := range root.Skins {
+		for _, joint := range skin.Joints {
+			visit(joint)
+		}
+		if skin.Skeleton != nil {
+			visit(*skin.Skeleton)
+		}
+	}
+	for _, animation := range root.Animations {
+		for _, channel := range animation.Channels {
+			if channel.Target.Node != nil {
+				visit(*channel.Target.Node)
+			}
+		}
+	}
+
+	mapping, removed := remapIndices(keep)
+	if removed == 0 {
+		return 0
+	}
+	root.Nodes = keepFiltered(root.Nodes, keep)
+	for i := range root.Nodes {
+		children := root.Nodes[i].Children[:0]
+		for _, child := range root.Nodes[i].Children {
+			if child >= 0 && child < len(mapping) && mapping[child] >= 0 {
+				children = append(children, mapping[child])
+			}
+		}
+		root.Nodes[i].Children = children
+	}
+	for i := range root.Scenes {
+		nodes := root.Scenes[i].Nodes[:0]
+		for _, n := range root.Scenes[i].Nodes {
+			if n >= 0 && n < len(mapping) && mapping[n] >= 0 {
+				nodes = append(nodes, mapping[n])
+			}
+		}
+		root.Scenes[i].Nodes = nodes
+	}
+	for i := range root.Skins {
+		for j, joint := range root.Skins[i].Joints {
+			if joint >= 0 && joint < len(mapping) {
+				root.Skins[i].Joints[j] = mapping[joint]
+			}
+		}
+		root.Skins[i].Skeleton = remapPtr(root.Skins[i].Skeleton, mapping)
+	}
+	for i := range root.Animations {
+		for j := range root.Animations[i].Channels {
+			target := &root.Animations[i].Channels[j].Target
+			target.Node = remapPtr(target.Node, mapping)
+		}
+	}
+	return removed
+}
+
+// pruneMeshes drops meshes no remaining node instantiates.
+func (a *gltfAsset) pruneMeshes() int {
+	root := &a.Root
+	if len(root.Nodes) == 0 || len(root.Meshes) == 0 {
+		return 0
+	}
+	keep := make([]bool, len(root.Meshes))
+	for _, node := range root.Nodes {
+		if node.Mesh != nil && *node.Mesh >= 0 && *node.Mesh < len(keep) {
+			keep[*node.Mesh] = true
+		}
+	}
+	mapping, removed := remapIndices(keep)
+	if removed == 0 {
+		return 0
+	}
+	root.Meshes = keepFiltered(root.Meshes, keep)
+	for i := range root.Nodes {
+		root.Nodes[i].Mesh = remapPtr(root.Nodes[i].Mesh, mapping)
+	}
+	return removed
+}
+
+// pruneMaterials drops materials no remaining primitive uses.
+func (a *gltfAsset) pruneMaterials() int {
+	root := &a.Root
+	if len(root.Materials) == 0 {
+		return 0
+	}
+	keep := make([]bool, len(root.Materials))
+	for _, mesh := range root.Meshes {
+		for _, primitive := range mesh.Primitives {
+			if primitive.Material != nil && *primitive.Material >= 0 && *primitive.Material < len(keep) {
+				keep[*primitive.Material] = true
+			}
+		}
+	}
+	mapping, removed := remapIndices(keep)
+	if removed == 0 {
+		return 0
+	}
+	root.Materials = keepFiltered(root.Materials, keep)
+	for i := range root.Meshes {
+		for j := range root.Meshes[i].Primitives {
+			primitive := &root.Meshes[i].Primitives[j]
+			primitive.Material = remapPtr(primitive.Material, mapping)
+		}
+	}
+	return removed
+}
+
+// forEachAccessorRef calls fn with a pointer to every accessor reference in the document.
+func (a *gltfAsset) forEachAccessorRef(fn func(ref *int, usage string)) {
+	root := &a.Root
+	for i := range root.Meshes {
+		for j := range root.Meshes[i].Primitives {
+			primitive := &root.Meshes[i].Primitives[j]
+			for name, index := range primitive.Attributes {
+				fn(&index, "vertex")
+				primitive.Attributes[name] = index
+			}
+			if primitive.Indices != nil {
+				fn(primitive.Indices, "index")
+			}
+			for _, target := range primitive.Targets {
+				for name, index := range target {
+					fn(&index, "vertex")
+					target[name] = index
+				}
+			}
+		}
+	}
+	for i := range root.Skins {
+		if root.Skins[i].InverseBindMatrices != nil {
+			fn(root.Skins[i].InverseBindMatrices, "other")
+		}
+	}
+	for i := range root.Animations {
+		for j := range root.Animations[i].Samplers {
+			sampler := &root.Animations[i].Samplers[j]
+			fn(&sampler.Input, "other")
+			fn(&sampler.Output, "other")
+		}
+	}
+}
+
+// dedupeAccessors points every reference to a byte-identical accessor at the first one.
+// Accessors are only merged within the same usage, index data never aliases vertex data.
+func (a *gltfAsset) dedupeAccessors() (int, error) {
+	accessors := a.Root.Accessors
+	usage := make([]string, len(accessors))
+	a.forEachAccessorRef(func(ref *int, use string) {
+		if *ref >= 0 && *ref < len(usage) && usage[*ref] == "" {
+			usage[*ref] = use
+		}
+	})
+
+	canonical := make([]int, len(accessors))
+	seen := make(map[string]int)
+	merged := 0
+	for i, acc := range accessors {
+		canonical[i] = i
+		if acc.Sparse != nil || acc.BufferView == nil || usage[i] == "" {
+			continue
+		}
+		data, err := a.accessorBytes(acc)
+		if err != nil {
+			return 0, fmt.Errorf("accessor %d: %w", i, err)
+		}
+		sum := sha256.Sum256(data)
+		key := fmt.Sprintf("%s/%d/%s/%d/%t/%x", usage[i], acc.ComponentType, acc.Type, acc.Count, acc.Normalized, sum)
+		if first, ok := seen[key]; ok {
+			canonical[i] = first
+			merged++
+		} else {
+			seen[key] = i
+		}
+	}
+
+	if merged > 0 {
+		a.forEachAccessorRef(func(ref *int, _ string) {
+			if *ref >= 0 && *ref < len(canonical) {
+				*ref = canonical[*ref]
+			}
+		})
+	}
+	return merged, nil
+}
+
+// encodeTextures re-encodes embedded PNG/JPEG images to KTX2 and returns the new content
+// of every image that must be written into the binary buffer (re-encoded or decoded from a
+// data URI). Failures keep the original image and are reported as warnings.
+func (a *gltfAsset) encodeTextures(ctx context.Context, workDir string, profile KTX2Profile, report *ModelOptimizationReport) (map[int][]byte, error) {
+	root := &a.Root
+	images := make(map[int][]byte)
+	normalMaps := a.normalMapImages()
+	normalProfile, _ := LookupKTX2Profile("normal-map")
+
+	encoded := make(map[int]bool)
+	for i := range root.Images {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		image := &root.Images[i]
+		data, err := a.imageBytes(*image)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("image %d kept as is: %v", i, err))
+			continue
+		}
+		if strings.HasPrefix(image.URI, "data:") {
+			// GLB output carries every image in the binary buffer
+			if image.MimeType == "" {
+				image.MimeType, _, _ = strings.Cut(strings.TrimPrefix(image.URI, "data:"), ";")
+			}
+			images[i] = data
+			image.URI = ""
+		}
+
+		ext := ""
+		switch http.DetectContentType(data) {
+		case "image/png":
+			ext = ".png"
+		case "image/jpeg":
+			ext = ".jpg"
+		default:
+			continue
+		}
+
+		imageProfile := profile
+		if normalMaps[i] {
+			imageProfile = normalProfile
+		}
+		ktx2, err := encodeTextureImage(ctx, workDir, fmt.Sprintf("texture-%d%s", i, ext), data, imageProfile)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("image %d kept as %s: %v", i, strings.TrimPrefix(ext, "."), err))
+			continue
+		}
+		images[i] = ktx2
+		image.URI = ""
+		image.MimeType = "image/ktx2"
+		encoded[i] = true
+		report.TexturesEncoded++
+	}
+	if len(encoded) == 0 {
+		return images, nil
+	}
+
+	// KTX2 images may only be referenced through the extension, and once the PNG/JPEG
+	// is gone the extension is required to display the model at all.
+	for i := range root.Textures {
+		texture := &root.Textures[i]
+		if texture.Source == nil || !encoded[*texture.Source] {
+			continue
+		}
+		source, _ := json.Marshal(map[string]int{"source": *texture.Source})
+		if texture.Extensions == nil {
+			texture.Extensions = make(map[string]json.RawMessage)
+		}
+		texture.Extensions[khrTextureBasisu] = source
+		texture.Source = nil
+	}
+	if !slices.Contains(root.ExtensionsUsed, khrTextureBasisu) {
+		root.ExtensionsUsed = append(root.ExtensionsUsed, khrTextureBasisu)
+	}
+	if !slices.Contains(root.ExtensionsRequired, khrTextureBasisu) {
+		root.ExtensionsRequired = append(root.ExtensionsRequired, khrTextureBasisu)
+	}
+	return images, nil
+}
+
+// normalMapImages returns the images sampled as normalTexture by any material.
+func (a *gltfAsset) normalMapImages() map[int]bool {
+	images := make(map[int]bool)
+	for _, raw := range a.Root.Materials {
+		var material struct {
+			NormalTexture *struct {
+				Index int `json:"index"`
+			} `json:"normalTexture"`
+		}
+		if json.Unmarshal(raw, &material) != nil || material.NormalTexture == nil {
+			continue
+		}
+		index := material.NormalTexture.Index
+		if index >= 0 && index < len(a.Root.Textures) && a.Root.Textures[index].Source != nil {
+			images[*a.Root.Textures[index].Source] = true
+		}
+	}
+	return images
+}
+
+func (a *gltfAsset) imageBytes(image gltfImage) ([]byte, error) {
+	switch {
+	case image.BufferView != nil:
+		return a.bufferViewBytes(*image.BufferView)
+	case strings.HasPrefix(image.URI, "data:"):
+		comma := strings.IndexByte(image.URI, ',')
+		if comma < 0 || !strings.Contains(image.URI[:comma], ";base64") {
+			return nil, errors.New("unsupported data URI")
+		}
+		return base64.StdEncoding.DecodeString(image.URI[comma+1:])
+	case image.URI != "":
+		return nil, fmt.Errorf("external file %q is not part of the upload", image.URI)
+	}
+	return nil, errors.New("image has no data")
+}
+
+// encodeTextureImage writes one embedded image to disk, checks its decode limits and runs toktx on it.
+func encodeTextureImage(ctx context.Context, workDir string, name string, data []byte, profile KTX2Profile) ([]byte, error) {
+	path := filepath.Join(workDir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	if _, _, err := CheckDecodeLimits(path); err != nil {
+		return nil, err
+	}
+	ktx2Path, err := ConvertToKTX2(ctx, path, workDir, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(ktx2Path)
+	return os.ReadFile(ktx2Path)
+}
+
+// repack drops unreferenced accessors and buffer views and copies everything that is left,
+// plus the given image contents, into one buffer. It returns the new buffer's bytes.
+func (a *gltfAsset) repack(images map[int][]byte) ([]byte, error) {
+	root := &a.Root
+
+	// 1. Accessors still referenced after pruning and deduplication
+	keepAccessors := make([]bool, len(root.Accessors))
+	a.forEachAccessorRef(func(ref *int, _ string) {
+		if *ref >= 0 && *ref < len(keepAccessors) {
+			keepAccessors[*ref] = true
+		}
+	})
+	accessorMapping, _ := remapIndices(keepAccessors)
+	a.forEachAccessorRef(func(ref *int, _ string) {
+		if *ref >= 0 && *ref < len(accessorMapping) {
+			*ref = accessorMapping[*ref]
+		}
+	})
+	root.Accessors = keepFiltered(root.Accessors, keepAccessors)
+
+	// 2. Buffer views still referenced by accessors and images
+	keepViews := make([]bool, len(root.BufferViews))
+	markView := func(index int) error {
+		if index < 0 || index >= len(keepViews) {
+			return fmt.Errorf("bufferView %d does not exist", index)
+		}
+		keepViews[index] = true
+		return nil
+	}
+	for _, acc := range root.Accessors {
+		if acc.BufferView != nil {
+			if err := markView(*acc.BufferView); err != nil {
+				return nil, err
+			}
+		}
+		if acc.Sparse != nil {
+			if err := markView(acc.Sparse.Indices.BufferView); err != nil {
+				return nil, err
+			}
+			if err := markView(acc.Sparse.Values.BufferView); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, image := range root.Images {
+		if _, replaced := images[i]; !replaced && image.BufferView != nil {
+			if err := markView(*image.BufferView); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 3. Copy the kept views into a single buffer, 4-byte aligned
+	var bin bytes.Buffer
+	align := func() {
+		for bin.Len()%4 != 0 {
+			bin.WriteByte(0)
+		}
+	}
+	viewMapping := make([]int, len(root.BufferViews))
+	var views []gltfBufferView
+	for i, view := range root.BufferViews {
+		viewMapping[i] = -1
+		if !keepViews[i] {
+			continue
+		}
+		data, err := a.bufferViewBytes(i)
+		if err != nil {
+			return nil, err
+		}
+		align()
+		view.Buffer = 0
+		view.ByteOffset = bin.Len()
+		bin.Write(data)
+		viewMapping[i] = len(views)
+		views = append(views, view)
+	}
+	for i := range root.Images {
+		image := &root.Images[i]
+		if data, replaced := images[i]; replaced {
+			align()
+			views = append(views, gltfBufferView{Buffer: 0, ByteOffset: bin.Len(), ByteLength: len(data)})
+			bin.Write(data)
+			index := len(views) - 1
+			image.BufferView = &index
+			if image.MimeType == "" {
+				image.MimeType = http.DetectContentType(data)
+			}
+			continue
+		}
+		image.BufferView = remapPtr(image.BufferView, viewMapping)
+	}
+
+	for i := range root.Accessors {
+		acc := &root.Accessors[i]
+		acc.BufferView = remapPtr(acc.BufferView, viewMapping)
+		if acc.Sparse != nil {
+			acc.Sparse.Indices.BufferView = viewMapping[acc.Sparse.Indices.BufferView]
+			acc.Sparse.Values.BufferView = viewMapping[acc.Sparse.Values.BufferView]
+		}
+	}
+	root.BufferViews = views
+
+	root.Buffers = nil
+	if bin.Len() > 0 {
+		root.Buffers = []gltfBuffer{{ByteLength: bin.Len()}}
+	}
+	a.Buffers = [][]byte{bin.Bytes()}
+	return bin.Bytes(), nil
+}
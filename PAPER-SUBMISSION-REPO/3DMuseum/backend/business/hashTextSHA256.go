@@ -3,9 +3,15 @@ package business
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"io"
 )
 
 func HashTextSHA256(text string) string {
 	hash := sha256.Sum256([]byte(text))
 	return hex.EncodeToString(hash[:])
 }
+
+// HashFileSHA256 returns the hex SHA-256 and the size of everything read from r.
+func HashFileSHA256(r io.Reader) (string, int64, error) {
+	return hashContent(r)
+}
@@ -0,0 +1,271 @@
+package business
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// Largest embedded ICC profile that is read, matrix profiles are a few KB
+const maxICCProfileSize = 4 << 20
+
+// The sRGB primaries adapted to the D50 profile connection space, as in the ICC sRGB profile
+var srgbD50 = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// iccTransform converts pixels tagged with an RGB matrix/TRC profile (Display P3, Adobe RGB,
+// ProPhoto, sRGB itself) to sRGB. LUT-based and non-RGB profiles are not supported.
+type iccTransform struct {
+	Description string
+	toLinear    [3][256]float64 // per channel tone curve
+	matrix      [3][3]float64   // linear profile RGB to linear sRGB
+}
+
+// parseICCProfile reads the colorants and tone curves of an RGB display profile.
+func parseICCProfile(profile []byte) (*iccTransform, error) {
+	if len(profile) < 132 || int64(binary.BigEndian.Uint32(profile)) > int64(len(profile)) {
+		return nil, errors.New("truncated profile")
+	}
+	if space, pcs := string(profile[16:20]), string(profile[20:24]); space != "RGB " || pcs != "XYZ " {
+		return nil, fmt.Errorf("%s profile with %s connection space", strings.TrimSpace(space), strings.TrimSpace(pcs))
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return nil, errors.New("truncated tag table")
+		}
+		offset, size := uint64(binary.BigEndian.Uint32(profile[entry+4:])), uint64(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset+size > uint64(len(profile)) {
+			return nil, fmt.Errorf("tag %q lies outside the profile", profile[entry:entry+4])
+		}
+		tags[string(profile[entry:entry+4])] = profile[offset : offset+size]
+	}
+
+	transform := &iccTransform{Description: iccDescription(tags["desc"])}
+	var colorants [3][3]float64
+	for c, name := range []string{"r", "g", "b"} {
+		xyz, curve := tags[name+"XYZ"], tags[name+"TRC"]
+		if xyz == nil || curve == nil {
+			return nil, errors.New("profile has no matrix and tone curves")
+		}
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, fmt.Errorf("invalid %sXYZ tag", name)
+		}
+		for row := 0; row < 3; row++ {
+			colorants[row][c] = s15Fixed16(xyz[8+row*4:])
+		}
+		decode, err := iccCurve(curve)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sTRC tag: %w", name, err)
+		}
+		for v := range transform.toLinear[c] {
+			transform.toLinear[c][v] = min(max(decode(float64(v)/255), 0), 1)
+		}
+	}
+
+	toSRGB, ok := invert3x3(srgbD50)
+	if !ok {
+		return nil, errors.New("sRGB matrix is singular")
+	}
+	transform.matrix = multiply3x3(toSRGB, colorants)
+	return transform, nil
+}
+
+// iccCurve returns the tone curve of a curv or para tag as a function on [0,1].
+func iccCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("truncated curve")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return func(x float64) float64 { return x }, nil
+		case n == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		case n > 1 && len(tag) >= 12+2*n:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+			}
+			return func(x float64) float64 {
+				position := x * float64(n-1)
+				i := min(int(position), n-2)
+				return table[i] + (table[i+1]-table[i])*(position-float64(i))
+			}, nil
+		}
+		return nil, errors.New("truncated curve")
+	case "para":
+		function := int(binary.BigEndian.Uint16(tag[8:]))
+		if function > 4 {
+			return nil, fmt.Errorf("unknown parametric curve type %d", function)
+		}
+		params := []int{1, 3, 4, 5, 7}[function]
+		if len(tag) < 12+4*params {
+			return nil, errors.New("truncated curve")
+		}
+		// g, a, b, c, d, e, f; the unused ones reduce each type to the general form
+		p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := 0; i < params; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch function {
+		case 1, 2:
+			// The power segment starts where aX+b reaches 0, constant c below it
+			d = 0
+			if a != 0 {
+				d = -b / a
+			}
+			c, e, f = 0, c, c
+		}
+		return func(x float64) float64 {
+			if x < d {
+				return c*x + f
+			}
+			return math.Pow(max(a*x+b, 0), g) + e
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported curve type %q", tag[:4])
+}
+
+// iccDescription reads a v2 desc or v4 mluc profile description, "" when it has none.
+func iccDescription(tag []byte) string {
+	switch {
+	case len(tag) >= 12 && string(tag[:4]) == "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n > 0 && 12+n <= len(tag) {
+			return strings.TrimRight(string(tag[12:12+n]), "\x00")
+		}
+	case len(tag) >= 28 && string(tag[:4]) == "mluc":
+		// First record: language, country, length and offset of a UTF-16BE string
+		n, offset := int(binary.BigEndian.Uint32(tag[20:])), int(binary.BigEndian.Uint32(tag[24:]))
+		if n > 0 && offset+n <= len(tag) {
+			units := make([]uint16, n/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+			}
+			return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+		}
+	}
+	return ""
+}
+
+// isSRGB reports whether converting would leave every pixel within one step of where it is.
+func (t *iccTransform) isSRGB() bool {
+	for row := 0; row < 3; row++ {
+		for column := 0; column < 3; column++ {
+			identity := 0.0
+			if row == column {
+				identity = 1
+			}
+			if math.Abs(t.matrix[row][column]-identity) > 0.002 {
+				return false
+			}
+		}
+	}
+	for c := range t.toLinear {
+		for v, linear := range t.toLinear[c] {
+			if math.Abs(srgbEncode(linear)-float64(v)/255) > 0.5/255 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// apply converts img to sRGB in place, colours outside the sRGB gamut are clipped.
+func (t *iccTransform) apply(img *image.NRGBA) {
+	var encode [4096]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(srgbEncode(float64(i)/4095) * 255))
+	}
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+img.Rect.Dx()*4]
+		for i := 0; i+4 <= len(row); i += 4 {
+			r, g, b := t.toLinear[0][row[i]], t.toLinear[1][row[i+1]], t.toLinear[2][row[i+2]]
+			for c := 0; c < 3; c++ {
+				linear := t.matrix[c][0]*r + t.matrix[c][1]*g + t.matrix[c][2]*b
+				row[i+c] = encode[int(math.Round(min(max(linear, 0), 1)*4095))]
+			}
+		}
+	}
+}
+
+// convertToSRGB converts img from the colour space of its embedded ICC profile to sRGB and
+// returns the normalization step describing what happened to the profile, "" without one.
+func convertToSRGB(img *image.NRGBA, meta imageMetadata) string {
+	if !meta.HasICC {
+		return ""
+	}
+	if meta.ICC == nil {
+		return "discarded unreadable embedded ICC profile, pixels are treated as sRGB"
+	}
+	transform, err := parseICCProfile(meta.ICC)
+	if err != nil {
+		return fmt.Sprintf("discarded embedded ICC profile that cannot be converted (%v), pixels are treated as sRGB", err)
+	}
+	name := transform.Description
+	if name == "" {
+		name = "embedded ICC profile"
+	}
+	if transform.isSRGB() {
+		return "dropped embedded ICC profile " + name + ", it is sRGB"
+	}
+	transform.apply(img)
+	return "converted colours from " + name + " to sRGB"
+}
+
+func srgbEncode(linear float64) float64 {
+	if linear <= 0.0031308 {
+		return 12.92 * linear
+	}
+	return 1.055*math.Pow(linear, 1/2.4) - 0.055
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func multiply3x3(a, b [3][3]float64) [3][3]float64 {
+	var product [3][3]float64
+	for row := 0; row < 3; row++ {
+		for column := 0; column < 3; column++ {
+			for k := 0; k < 3; k++ {
+				product[row][column] += a[row][k] * b[k][column]
+			}
+		}
+	}
+	return product
+}
+
+func invert3x3(m [3][3]float64) ([3][3]float64, bool) {
+	var inverse [3][3]float64
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if det == 0 {
+		return inverse, false
+	}
+	for row := 0; row < 3; row++ {
+		for column := 0; column < 3; column++ {
+			// Cofactor of the transposed position
+			r0, r1 := (column+1)%3, (column+2)%3
+			c0, c1 := (row+1)%3, (row+2)%3
+			inverse[row][column] = (m[r0][c0]*m[r1][c1] - m[r0][c1]*m[r1][c0]) / det
+		}
+	}
+	return inverse, true
+}
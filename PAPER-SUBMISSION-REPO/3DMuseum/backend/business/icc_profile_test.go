@@ -0,0 +1,134 @@
+package business
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testProfile builds an RGB matrix/TRC profile with the given D50 colorants (rows r, g, b) and
+// the sRGB tone curve as a parametric curve.
+func testProfile(description string, colorants [3][3]float64) []byte {
+	fixed := func(data []byte, values ...float64) []byte {
+		for _, v := range values {
+			data = binary.BigEndian.AppendUint32(data, uint32(int32(v*65536)))
+		}
+		return data
+	}
+	curve := fixed(append([]byte("para\x00\x00\x00\x00"), 0, 3, 0, 0), 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	desc := binary.BigEndian.AppendUint32([]byte("desc\x00\x00\x00\x00"), uint32(len(description)+1))
+	desc = append(append(desc, description...), 0)
+	tags := map[string][]byte{"desc": desc, "rTRC": curve, "gTRC": curve, "bTRC": curve}
+	for i, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tags[name] = fixed([]byte("XYZ \x00\x00\x00\x00"), colorants[i][:]...)
+	}
+
+	names := []string{"desc", "rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	header := make([]byte, 128)
+	copy(header[16:], "RGB XYZ ")
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(names)))
+	var data []byte
+	offset := len(header) + 4 + 12*len(names)
+	for _, name := range names {
+		table = append(table, name...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tags[name])))
+		data = append(data, tags[name]...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	profile := slices.Concat(header, table, data)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+var (
+	srgbProfile = testProfile("sRGB IEC61966-2.1", [3][3]float64{
+		{0.4360747, 0.2225045, 0.0139322}, {0.3850649, 0.7168786, 0.0971045}, {0.1430804, 0.0606169, 0.7141733},
+	})
+	displayP3Profile = testProfile("Display P3", [3][3]float64{
+		{0.5151, 0.2412, -0.0011}, {0.2919, 0.6922, 0.0419}, {0.1571, 0.0666, 0.7841},
+	})
+)
+
+// writePNGWithProfile writes img as PNG with profile in an iCCP chunk right after IHDR.
+func writePNGWithProfile(t *testing.T, path string, img image.Image, profile []byte) {
+	t.Helper()
+	var encoded, compressed bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		t.Fatal(err)
+	}
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(profile)
+	writer.Close()
+
+	body := append([]byte("iCCP"), append([]byte("icc\x00\x00"), compressed.Bytes()...)...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)-4))
+	chunk = binary.BigEndian.AppendUint32(append(chunk, body...), crc32.ChecksumIEEE(body))
+	// Signature and the 25 byte IHDR chunk
+	data := slices.Concat(encoded.Bytes()[:33], chunk, encoded.Bytes()[33:])
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNormalizeImageConvertsProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile []byte
+		in      color.NRGBA
+		check   func(color.NRGBA) bool
+		step    string
+	}{
+		{"display p3 red is more saturated in sRGB", displayP3Profile, color.NRGBA{200, 0, 0, 255},
+			func(c color.NRGBA) bool { return c.R > 210 && c.G == 0 && c.B == 0 }, "converted colours from Display P3 to sRGB"},
+		{"display p3 gray stays gray", displayP3Profile, color.NRGBA{128, 128, 128, 255},
+			func(c color.NRGBA) bool { return c.R == c.G && c.G == c.B && c.R >= 126 && c.R <= 130 }, "converted colours"},
+		{"srgb is left alone", srgbProfile, color.NRGBA{200, 10, 30, 128},
+			func(c color.NRGBA) bool { return c == color.NRGBA{200, 10, 30, 128} }, "it is sRGB"},
+		{"damaged profile is dropped", []byte("not a profile"), color.NRGBA{200, 10, 30, 255},
+			func(c color.NRGBA) bool { return c == color.NRGBA{200, 10, 30, 255} }, "pixels are treated as sRGB"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+			for i := 0; i < len(img.Pix); i += 4 {
+				copy(img.Pix[i:], []byte{test.in.R, test.in.G, test.in.B, test.in.A})
+			}
+			input := filepath.Join(dir, "texture.png")
+			writePNGWithProfile(t, input, img, test.profile)
+
+			output, report, err := NormalizeImage(context.Background(), input, t.TempDir(), NormalizeNone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.ContainsFunc(report.Steps, func(step string) bool { return strings.Contains(step, test.step) }) {
+				t.Errorf("steps %q, want one mentioning %q", report.Steps, test.step)
+			}
+			file, err := os.Open(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			decoded, err := png.Decode(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := color.NRGBAModel.Convert(decoded.At(1, 1)).(color.NRGBA); !test.check(got) {
+				t.Errorf("%v became %v", test.in, got)
+			}
+		})
+	}
+}
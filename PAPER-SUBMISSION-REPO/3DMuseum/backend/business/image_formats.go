@@ -0,0 +1,124 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+// ErrSVGNoSize is returned for SVGs with neither a viewBox nor a width and height.
+var ErrSVGNoSize = errors.New("svg has no viewBox or size")
+
+// Longest edge SVGs are rasterized to, SVG_RASTER_EDGE overrides it. Normalization still caps
+// the result at NORMALIZE_MAX_EDGE.
+const defaultSVGRasterEdge = 2048
+
+// encoderInputTypes are read by toktx and the WebP ladder as they are; the other image types
+// are decoded to PNG by NormalizeImage first.
+var encoderInputTypes = []string{"png", "jpg", "jpeg"}
+
+// sniffImageFile names the format of the file at path as sniffContent does.
+func sniffImageFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return sniffContent(header[:n]), nil
+}
+
+// sourceConversionStep describes how a type the encoders cannot read became pixels, for the
+// normalization report; "" for PNG and JPEG.
+func sourceConversionStep(inputPath string, bounds image.Rectangle) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(inputPath)), ".")
+	switch {
+	case ext == "svg":
+		return fmt.Sprintf("rasterized SVG at %dx%d", bounds.Dx(), bounds.Dy())
+	case ext == "" || slices.Contains(encoderInputTypes, ext):
+		return ""
+	}
+	return "converted " + strings.ToUpper(ext) + " to PNG"
+}
+
+// decodeHEIF converts a HEIC/HEIF image with heif-convert (libheif) to a temporary PNG and
+// decodes that. heif-convert applies the rotation and mirroring stored in the container and runs
+// with its address-space and CPU-time limits lowered: HEIF_CONVERT_MAX_MEMORY_MB (default 2048)
+// and HEIF_CONVERT_MAX_CPU_SECONDS (default 120). The image size was checked by validateHEIF.
+func decodeHEIF(ctx context.Context, inputPath string) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "heif-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	outputFile := filepath.Join(dir, "image.png")
+	name, args := limitedCommand("heif-convert", envInt("HEIF_CONVERT_MAX_MEMORY_MB", 2048),
+		envInt("HEIF_CONVERT_MAX_CPU_SECONDS", 120), []string{inputPath, outputFile})
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = 5 * time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if limitErr := converterLimitError("heif-convert", err, stderr.String()); limitErr != nil {
+			return nil, limitErr
+		}
+		return nil, fmt.Errorf("heif-convert failed: %v\n%s", err, stderr.String())
+	}
+	// Files with several top-level images come out numbered, the first one is the primary image
+	if _, err := os.Stat(outputFile); err != nil {
+		outputFile = filepath.Join(dir, "image-1.png")
+	}
+	return decodeImageFile(ctx, outputFile)
+}
+
+// rasterizeSVG draws the SVG at inputPath with its longest edge at SVG_RASTER_EDGE pixels on a
+// transparent background. Only the static subset oksvg understands is drawn: shapes, paths,
+// gradients and styles; text, images and filters are skipped.
+func rasterizeSVG(inputPath string) (image.Image, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	icon, err := oksvg.ReadIconStream(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse svg: %v", err)
+	}
+	viewWidth, viewHeight := icon.ViewBox.W, icon.ViewBox.H
+	if viewWidth <= 0 || viewHeight <= 0 || math.IsInf(viewWidth, 0) || math.IsInf(viewHeight, 0) {
+		return nil, ErrSVGNoSize
+	}
+
+	edge := float64(envInt("SVG_RASTER_EDGE", defaultSVGRasterEdge))
+	scale := edge / max(viewWidth, viewHeight)
+	width := max(1, int(math.Round(viewWidth*scale)))
+	height := max(1, int(math.Round(viewHeight*scale)))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
@@ -0,0 +1,281 @@
+package business
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// imageMetadata is what we learn about embedded metadata without decoding pixels.
+type imageMetadata struct {
+	Orientation int      // EXIF orientation 1-8, 0 when absent
+	HasICC      bool     // an ICC profile is embedded
+	ICC         []byte   // the profile itself, nil when it is unreadable or too large
+	Blocks      []string // kinds of metadata found, e.g. "EXIF", "XMP", "ICC"
+}
+
+// readImageMetadata scans JPEG segments, PNG chunks or the first TIFF directory for EXIF, XMP,
+// ICC and text blocks. Unknown formats return empty metadata.
+func readImageMetadata(path string) (imageMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return imageMetadata{}, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return imageMetadata{}, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return imageMetadata{}, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}):
+		return readJPEGMetadata(file)
+	case bytes.Equal(header, []byte("\x89PNG\r\n\x1a\n")):
+		return readPNGMetadata(file)
+	case bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")):
+		return readTIFFMetadata(file)
+	}
+	return imageMetadata{}, nil
+}
+
+func readJPEGMetadata(r io.Reader) (meta imageMetadata, err error) {
+	if _, err := io.CopyN(io.Discard, r, 2); err != nil { // SOI
+		return meta, err
+	}
+
+	// A large profile is split over numbered APP2 segments
+	var iccChunks [][]byte
+	defer func() { meta.ICC = joinICCChunks(iccChunks) }()
+
+	marker := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return meta, nil
+		}
+		if marker[0] != 0xFF {
+			return meta, nil
+		}
+		kind := marker[1]
+		// Start of scan: the header segments are over
+		if kind == 0xDA || kind == 0xD9 {
+			return meta, nil
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return meta, nil
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return meta, nil
+		}
+
+		switch {
+		case kind == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			meta.Blocks = appendUnique(meta.Blocks, "EXIF")
+			if o := tiffOrientation(segment[6:]); o > 0 {
+				meta.Orientation = o
+			}
+		case kind == 0xE1 && bytes.HasPrefix(segment, []byte("http://ns.adobe.com/xap/")):
+			meta.Blocks = appendUnique(meta.Blocks, "XMP")
+		case kind == 0xE2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")):
+			meta.HasICC = true
+			meta.Blocks = appendUnique(meta.Blocks, "ICC")
+			// Sequence number from 1 and the number of segments follow the name
+			if len(segment) > 14 {
+				if iccChunks == nil {
+					iccChunks = make([][]byte, segment[13])
+				}
+				if sequence := int(segment[12]); sequence >= 1 && sequence <= len(iccChunks) {
+					iccChunks[sequence-1] = segment[14:]
+				}
+			}
+		case kind == 0xED:
+			meta.Blocks = appendUnique(meta.Blocks, "IPTC")
+		case kind == 0xFE:
+			meta.Blocks = appendUnique(meta.Blocks, "comment")
+		}
+	}
+}
+
+func readPNGMetadata(r io.Reader) (imageMetadata, error) {
+	var meta imageMetadata
+	if _, err := io.CopyN(io.Discard, r, 8); err != nil { // signature
+		return meta, err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return meta, nil
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+
+		switch kind {
+		case "IDAT", "IEND":
+			return meta, nil
+		case "eXIf":
+			chunk := make([]byte, length)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return meta, nil
+			}
+			meta.Blocks = appendUnique(meta.Blocks, "EXIF")
+			meta.Orientation = tiffOrientation(chunk)
+			length = 0
+		case "iCCP":
+			meta.HasICC = true
+			meta.Blocks = appendUnique(meta.Blocks, "ICC")
+			if length <= maxICCProfileSize {
+				chunk := make([]byte, length)
+				if _, err := io.ReadFull(r, chunk); err != nil {
+					return meta, nil
+				}
+				meta.ICC = inflateICCP(chunk)
+				length = 0
+			}
+		case "tEXt", "zTXt", "iTXt":
+			meta.Blocks = appendUnique(meta.Blocks, "text")
+		case "tIME":
+			meta.Blocks = appendUnique(meta.Blocks, "timestamp")
+		}
+		// skip the remaining chunk data and its CRC
+		if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+			return meta, nil
+		}
+	}
+}
+
+// readTIFFMetadata reads IFD0 of a TIFF file, which may sit anywhere in it, for the orientation,
+// ICC profile and XMP tags.
+func readTIFFMetadata(r io.ReadSeeker) (imageMetadata, error) {
+	var meta imageMetadata
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return meta, nil
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+	if _, err := r.Seek(int64(order.Uint32(header[4:8])), io.SeekStart); err != nil {
+		return meta, nil
+	}
+	count := make([]byte, 2)
+	if _, err := io.ReadFull(r, count); err != nil {
+		return meta, nil
+	}
+	entries := make([]byte, int(order.Uint16(count))*12)
+	if _, err := io.ReadFull(r, entries); err != nil {
+		return meta, nil
+	}
+
+	var iccOffset, iccSize int64
+	for i := 0; i+12 <= len(entries); i += 12 {
+		switch order.Uint16(entries[i:]) {
+		case 0x8773: // InterColorProfile, an UNDEFINED byte array stored at an offset
+			meta.HasICC = true
+			meta.Blocks = appendUnique(meta.Blocks, "ICC")
+			iccSize, iccOffset = int64(order.Uint32(entries[i+4:])), int64(order.Uint32(entries[i+8:]))
+		case 0x02BC:
+			meta.Blocks = appendUnique(meta.Blocks, "XMP")
+		case 0x8769:
+			meta.Blocks = appendUnique(meta.Blocks, "EXIF")
+		}
+	}
+	if iccSize > 4 && iccSize <= maxICCProfileSize {
+		profile := make([]byte, iccSize)
+		if _, err := r.Seek(iccOffset, io.SeekStart); err == nil {
+			if _, err := io.ReadFull(r, profile); err == nil {
+				meta.ICC = profile
+			}
+		}
+	}
+	// Rebuilt with IFD0 right after the header, the orientation value is stored inline
+	ifd := append(header[:4:4], 0, 0, 0, 0)
+	order.PutUint32(ifd[4:], 8)
+	ifd = append(append(ifd, count...), entries...)
+	meta.Orientation = tiffOrientation(ifd)
+	return meta, nil
+}
+
+// joinICCChunks puts the APP2 segments of a JPEG ICC profile back together, nil when one is missing.
+func joinICCChunks(chunks [][]byte) []byte {
+	var profile []byte
+	for _, chunk := range chunks {
+		if chunk == nil || len(profile)+len(chunk) > maxICCProfileSize {
+			return nil
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile
+}
+
+// inflateICCP decompresses the profile of a PNG iCCP chunk: a name, a compression method byte
+// and zlib data. It returns nil for a damaged chunk.
+func inflateICCP(chunk []byte) []byte {
+	name := bytes.IndexByte(chunk, 0)
+	if name < 0 || name+2 > len(chunk) {
+		return nil
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+	profile, err := io.ReadAll(io.LimitReader(reader, maxICCProfileSize+1))
+	if err != nil || len(profile) > maxICCProfileSize {
+		return nil
+	}
+	return profile
+}
+
+// tiffOrientation reads tag 0x0112 from IFD0 of a TIFF-structured EXIF block.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
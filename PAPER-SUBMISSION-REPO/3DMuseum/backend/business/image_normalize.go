@@ -0,0 +1,276 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+var ErrUnknownNormalizeMode = errors.New("unknown normalize mode")
+
+// Block-size handling applied after the longest edge is capped.
+const (
+	NormalizePad4 = "pad4" // pad to a multiple of 4, the block size of ETC1S/UASTC (default)
+	NormalizePOT  = "pot"  // resize to power-of-two dimensions
+	NormalizeNone = "none" // keep the dimensions as they are
+)
+
+const defaultNormalizeMaxEdge = 4096
+
+// NormalizationReport records what was done to an image before conversion so
+// curators can see why the stored texture differs from the file they uploaded.
+type NormalizationReport struct {
+	OriginalWidth  int      `json:"original_width"`
+	OriginalHeight int      `json:"original_height"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	Steps          []string `json:"steps"`
+}
+
+// normalizableTypes are the image formats decoded for normalization; ktx2 and webp are
+// already encoded for the viewer and pass through untouched. Everything but PNG and JPEG is
+// always written out as PNG, the encoders cannot read it.
+var normalizableTypes = []string{"png", "jpg", "jpeg", "tif", "tiff", "bmp", "heic", "heif", "svg"}
+
+// CanNormalize reports whether NormalizeImage handles this file.
+func CanNormalize(fileName string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	return slices.Contains(normalizableTypes, ext)
+}
+
+// ValidateNormalizeMode accepts "", pad4, pot and none.
+func ValidateNormalizeMode(mode string) error {
+	switch mode {
+	case "", NormalizePad4, NormalizePOT, NormalizeNone:
+		return nil
+	}
+	return fmt.Errorf("%w %q, expected pad4, pot or none", ErrUnknownNormalizeMode, mode)
+}
+
+// NormalizeImage prepares a raster upload for the texture encoders: it applies the EXIF
+// orientation, converts to 8-bit RGBA, converts colours from an embedded RGB matrix profile
+// (Display P3, Adobe RGB, ...) to sRGB, drops all metadata, caps the longest edge at
+// NORMALIZE_MAX_EDGE (default 4096) and applies the block-size mode. The result is written
+// as PNG into outputDir. When nothing needs to change the input path is returned as is.
+func NormalizeImage(ctx context.Context, inputPath string, outputDir string, mode string) (string, *NormalizationReport, error) {
+	if mode == "" {
+		mode = NormalizePad4
+	}
+	if err := ValidateNormalizeMode(mode); err != nil {
+		return "", nil, err
+	}
+
+	meta, err := readImageMetadata(inputPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read image metadata: %w", err)
+	}
+
+	var outputPath string
+	var report *NormalizationReport
+	err = Converters().Run(ctx, func(ctx context.Context) error {
+		src, err := decodeImageFile(ctx, inputPath)
+		if err != nil {
+			return err
+		}
+
+		bounds := src.Bounds()
+		report = &NormalizationReport{OriginalWidth: bounds.Dx(), OriginalHeight: bounds.Dy()}
+
+		if step := sourceConversionStep(inputPath, bounds); step != "" {
+			report.Steps = append(report.Steps, step)
+		}
+		img := toNRGBA(src, report)
+		if meta.Orientation > 1 {
+			img = applyOrientation(img, meta.Orientation)
+			report.Steps = append(report.Steps, fmt.Sprintf("applied EXIF orientation %d (%s)", meta.Orientation, orientationNames[meta.Orientation]))
+		}
+		if step := convertToSRGB(img, meta); step != "" {
+			report.Steps = append(report.Steps, step)
+		}
+		if len(meta.Blocks) > 0 {
+			report.Steps = append(report.Steps, "stripped metadata: "+strings.Join(meta.Blocks, ", "))
+		}
+
+		img = capLongestEdge(img, envInt("NORMALIZE_MAX_EDGE", defaultNormalizeMaxEdge), report)
+		switch mode {
+		case NormalizePOT:
+			img = resizeToPowerOfTwo(img, report)
+		case NormalizePad4:
+			img = padToMultipleOf4(img, report)
+		}
+
+		report.Width, report.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		if len(report.Steps) == 0 {
+			outputPath = inputPath
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+		outputPath = filepath.Join(outputDir, base+".png")
+		if outputPath == inputPath {
+			outputPath = filepath.Join(outputDir, base+"-normalized.png")
+		}
+		out, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create normalized image: %v", err)
+		}
+		// Intermediate file only, favour speed over size
+		encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err := encoder.Encode(out, img); err != nil {
+			out.Close()
+			return fmt.Errorf("failed to encode normalized image: %v", err)
+		}
+		return out.Close()
+	})
+	if err != nil {
+		if outputPath != "" && outputPath != inputPath {
+			_ = os.Remove(outputPath)
+		}
+		return "", nil, err
+	}
+	return outputPath, report, nil
+}
+
+// toNRGBA converts any decoded image to 8-bit non-premultiplied RGBA, noting unusual sources.
+func toNRGBA(src image.Image, report *NormalizationReport) *image.NRGBA {
+	switch src.(type) {
+	case *image.CMYK:
+		report.Steps = append(report.Steps, "converted CMYK to sRGB")
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		report.Steps = append(report.Steps, "reduced 16-bit samples to 8-bit")
+	case *image.Gray:
+		report.Steps = append(report.Steps, "expanded grayscale to RGB")
+	case *image.Paletted:
+		report.Steps = append(report.Steps, "expanded palette to RGBA")
+	}
+	if nrgba, ok := src.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	return dst
+}
+
+var orientationNames = map[int]string{
+	2: "mirrored horizontally",
+	3: "rotated 180°",
+	4: "mirrored vertically",
+	5: "transposed",
+	6: "rotated 90° clockwise",
+	7: "transversed",
+	8: "rotated 90° counter-clockwise",
+}
+
+// applyOrientation turns the stored pixels into the upright image the camera intended.
+func applyOrientation(src *image.NRGBA, orientation int) *image.NRGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-sx, sy
+			case 3:
+				dx, dy = w-1-sx, h-1-sy
+			case 4:
+				dx, dy = sx, h-1-sy
+			case 5:
+				dx, dy = sy, sx
+			case 6:
+				dx, dy = h-1-sy, sx
+			case 7:
+				dx, dy = h-1-sy, w-1-sx
+			case 8:
+				dx, dy = sy, w-1-sx
+			default:
+				dx, dy = sx, sy
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+func resizeNRGBA(src *image.NRGBA, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func capLongestEdge(img *image.NRGBA, maxEdge int, report *NormalizationReport) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	longest := max(w, h)
+	if maxEdge <= 0 || longest <= maxEdge {
+		return img
+	}
+	scale := float64(maxEdge) / float64(longest)
+	nw, nh := max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))
+	report.Steps = append(report.Steps, fmt.Sprintf("downscaled %dx%d to %dx%d (longest edge capped at %d)", w, h, nw, nh, maxEdge))
+	return resizeNRGBA(img, nw, nh)
+}
+
+// nearestPowerOfTwo rounds in log space, so 1500 becomes 1024 and 1600 becomes 2048.
+func nearestPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << int(math.Round(math.Log2(float64(n))))
+}
+
+func resizeToPowerOfTwo(img *image.NRGBA, report *NormalizationReport) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	nw, nh := nearestPowerOfTwo(w), nearestPowerOfTwo(h)
+	if nw == w && nh == h {
+		return img
+	}
+	report.Steps = append(report.Steps, fmt.Sprintf("resized %dx%d to power-of-two %dx%d", w, h, nw, nh))
+	return resizeNRGBA(img, nw, nh)
+}
+
+// padToMultipleOf4 extends the right and bottom edges by repeating the last row/column,
+// so mipmaps do not bleed a border colour into the artwork.
+func padToMultipleOf4(img *image.NRGBA, report *NormalizationReport) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	nw, nh := (w+3)/4*4, (h+3)/4*4
+	if nw == w && nh == h {
+		return img
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		sy := min(y, h-1)
+		row := rowPixels(img, sy)
+		copy(dst.Pix[dst.PixOffset(0, y):], row)
+		last := row[len(row)-4:]
+		for x := w; x < nw; x++ {
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], last)
+		}
+	}
+	report.Steps = append(report.Steps, fmt.Sprintf("padded %dx%d to %dx%d (multiple of 4)", w, h, nw, nh))
+	return dst
+}
+
+// rowPixels returns the pixel bytes of row y.
+func rowPixels(img *image.NRGBA, y int) []byte {
+	start := img.PixOffset(0, y)
+	return img.Pix[start : start+img.Bounds().Dx()*4]
+}
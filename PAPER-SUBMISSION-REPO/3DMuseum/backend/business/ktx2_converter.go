@@ -2,42 +2,54 @@ package business
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
-func ConvertToKTX2(inputPath string) ([]byte, string, error) {
-	outputFile := filepath.Join(os.TempDir(), "temp_output.ktx2")
+// ErrConverterLimit is returned when a converter was stopped by its memory or CPU limit.
+var ErrConverterLimit = errors.New("converter resource limit exceeded")
 
-	// Delete the outputFile from system so it not account the computer memory 
-	// Because later on the content of the output file is copy and this deletion just executed before it return 
-	// so that deleting the outputFile is totally safe
-	defer func() {
-		_ = os.Remove(outputFile)
-	}()
-
-	cmd := exec.Command("toktx",
-		"--t2", "--encode", "etc1s", "--genmipmap",
-		outputFile,
-		inputPath,
-	)
-
-
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return nil, "", fmt.Errorf("toktx failed: %v\n%s", err, stderr.String())
-	}
+// toktxCommand runs toktx with its address-space and CPU-time limits lowered:
+// TOKTX_MAX_MEMORY_MB (default 4096) and TOKTX_MAX_CPU_SECONDS (default 300).
+// The wall-clock limit comes from the converter pool.
+func toktxCommand(args []string) (string, []string) {
+	return limitedCommand("toktx", envInt("TOKTX_MAX_MEMORY_MB", 4096), envInt("TOKTX_MAX_CPU_SECONDS", 300), args)
+}
 
-	// Read result
-	data, err := os.ReadFile(outputFile)
+// ConvertToKTX2 encodes inputPath with toktx into outputDir using the given profile and
+// returns the path of the .ktx2 file. outputDir must be private to the caller (see
+// NewWorkspace) so concurrent uploads never overwrite each other's output.
+func ConvertToKTX2(ctx context.Context, inputPath string, outputDir string, profile KTX2Profile) (string, error) {
+	outputFile := filepath.Join(outputDir, strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))+".ktx2")
+
+	err := Converters().Run(ctx, func(ctx context.Context) error {
+		name, args := toktxCommand(append(profile.toktxArgs(inputPath), outputFile, inputPath))
+		cmd := exec.CommandContext(ctx, name, args...)
+		// Give toktx a moment to exit after being killed before giving up on its pipes
+		cmd.WaitDelay = 5 * time.Second
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			if limitErr := converterLimitError("toktx", err, stderr.String()); limitErr != nil {
+				return limitErr
+			}
+			return fmt.Errorf("toktx failed: %v\n%s", err, stderr.String())
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read KTX2 file: %v", err)
+		// Never leave a half-written texture behind for the uploader to pick up
+		_ = os.Remove(outputFile)
+		return "", err
 	}
 
-	return data, filepath.Base(outputFile), nil
+	return outputFile, nil
 }
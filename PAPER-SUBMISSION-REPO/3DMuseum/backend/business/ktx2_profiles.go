@@ -0,0 +1,133 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"sort"
+	"strconv"
+)
+
+var ErrUnknownEncodingProfile = errors.New("unknown encoding profile")
+
+// DefaultKTX2Profile is used when neither the upload, its room nor its category chooses one.
+const DefaultKTX2Profile = "artwork-standard"
+
+// KTX2Profile describes how toktx encodes a texture.
+type KTX2Profile struct {
+	Name string `json:"name"`
+	// Codec is "etc1s" (small, lossy) or "uastc" (high quality, larger).
+	Codec string `json:"codec"`
+	// Quality is --qlevel (1-255) for ETC1S and --uastc_quality (0-4) for UASTC.
+	Quality int `json:"quality"`
+	// RDO enables rate-distortion optimisation; RDOLambda only applies to UASTC.
+	RDO       bool    `json:"rdo"`
+	RDOLambda float64 `json:"rdo_lambda,omitempty"`
+	// ZstdLevel supercompresses UASTC output, 0 disables it.
+	ZstdLevel int  `json:"zstd_level,omitempty"`
+	GenMipmap bool `json:"gen_mipmap"`
+	// ColorSpace is "srgb" for colour data or "linear" for data textures such as normal maps.
+	ColorSpace string `json:"color_space"`
+	NormalMap  bool   `json:"normal_map,omitempty"`
+	// MaxDimension caps the longest edge in pixels, 0 keeps the source size.
+	MaxDimension int `json:"max_dimension"`
+}
+
+var ktx2Profiles = map[string]KTX2Profile{
+	"artwork-high": {
+		Name: "artwork-high", Codec: "uastc", Quality: 3,
+		RDO: true, RDOLambda: 0.5, ZstdLevel: 18,
+		GenMipmap: true, ColorSpace: "srgb", MaxDimension: 4096,
+	},
+	"artwork-standard": {
+		Name: "artwork-standard", Codec: "etc1s", Quality: 128,
+		RDO:       true,
+		GenMipmap: true, ColorSpace: "srgb", MaxDimension: 4096,
+	},
+	"normal-map": {
+		Name: "normal-map", Codec: "uastc", Quality: 2,
+		RDO: false, ZstdLevel: 18,
+		GenMipmap: true, ColorSpace: "linear", NormalMap: true, MaxDimension: 2048,
+	},
+	"thumbnail": {
+		Name: "thumbnail", Codec: "etc1s", Quality: 64,
+		RDO:       true,
+		GenMipmap: false, ColorSpace: "srgb", MaxDimension: 512,
+	},
+}
+
+// LookupKTX2Profile returns the named profile or ErrUnknownEncodingProfile.
+func LookupKTX2Profile(name string) (KTX2Profile, error) {
+	profile, ok := ktx2Profiles[name]
+	if !ok {
+		return KTX2Profile{}, fmt.Errorf("%w %q, expected one of %v", ErrUnknownEncodingProfile, name, KTX2ProfileNames())
+	}
+	return profile, nil
+}
+
+func KTX2ProfileNames() []string {
+	names := make([]string, 0, len(ktx2Profiles))
+	for name := range ktx2Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// toktxArgs builds the encoder flags for the profile. inputPath is only read to work out
+// the --resize target when the image is larger than MaxDimension.
+func (p KTX2Profile) toktxArgs(inputPath string) []string {
+	args := []string{"--t2", "--encode", p.Codec}
+
+	switch p.Codec {
+	case "uastc":
+		args = append(args, "--uastc_quality", strconv.Itoa(p.Quality))
+		if p.RDO {
+			args = append(args, "--uastc_rdo_l", strconv.FormatFloat(p.RDOLambda, 'f', -1, 64))
+		}
+		if p.ZstdLevel > 0 {
+			args = append(args, "--zcmp", strconv.Itoa(p.ZstdLevel))
+		}
+	default:
+		args = append(args, "--qlevel", strconv.Itoa(p.Quality))
+		if !p.RDO {
+			args = append(args, "--no_endpoint_rdo", "--no_selector_rdo")
+		}
+	}
+
+	if p.GenMipmap {
+		args = append(args, "--genmipmap")
+	}
+	args = append(args, "--assign_oetf", p.ColorSpace)
+	if p.NormalMap {
+		args = append(args, "--normal_mode")
+	}
+	if width, height, ok := p.fitWithin(inputPath); ok {
+		args = append(args, "--resize", fmt.Sprintf("%dx%d", width, height))
+	}
+	return args
+}
+
+// fitWithin returns the downscaled size when the image exceeds MaxDimension.
+func (p KTX2Profile) fitWithin(inputPath string) (int, int, bool) {
+	if p.MaxDimension <= 0 {
+		return 0, 0, false
+	}
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, false
+	}
+	longest := max(config.Width, config.Height)
+	if longest <= p.MaxDimension {
+		return 0, 0, false
+	}
+	scale := float64(p.MaxDimension) / float64(longest)
+	return max(1, int(float64(config.Width)*scale)), max(1, int(float64(config.Height)*scale)), true
+}
@@ -0,0 +1,478 @@
+package business
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"main/model"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kubo defaults, override with KUBO_API_URL, KUBO_GATEWAY_URL, KUBO_CID_VERSION,
+// KUBO_CONNECT_TIMEOUT_SECONDS and KUBO_RESPONSE_TIMEOUT_SECONDS
+const (
+	defaultKuboAPIURL          = "http://127.0.0.1:5001"
+	defaultKuboGatewayURL      = "http://127.0.0.1:8080"
+	defaultKuboCIDVersion      = 1
+	defaultKuboConnectTimeout  = 10 * time.Second
+	defaultKuboResponseTimeout = 2 * time.Minute // pin/add answers once the whole DAG is pinned
+)
+
+// kuboClient is shared by every RPC call. Only connecting and waiting for the response headers are
+// bounded, reading a response may take as long as the file it streams.
+var kuboClient = sync.OnceValue(func() *http.Client {
+	connectTimeout := time.Duration(envInt("KUBO_CONNECT_TIMEOUT_SECONDS", int(defaultKuboConnectTimeout/time.Second))) * time.Second
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: time.Duration(envInt("KUBO_RESPONSE_TIMEOUT_SECONDS", int(defaultKuboResponseTimeout/time.Second))) * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	}}
+})
+
+// MFS folders uploads are organized in, the same keyvalues the Pinata uploads are tagged with
+var kuboFolders = []string{"Asset_Image", "Asset_Video", "Asset_3D", "Audio"}
+
+// KuboStorage pins to a self-hosted IPFS node through the Kubo HTTP RPC API. Content is
+// added with /api/v0/add, pinned with /api/v0/pin/add and copied into the MFS folder of its
+// category so the node's files view looks like the Pinata dashboard.
+type KuboStorage struct {
+	APIURL     string
+	GatewayURL string
+	CIDVersion int
+	Auth       string // KUBO_API_AUTH, sent as the Authorization header when the API is behind a proxy
+}
+
+func NewKuboStorage(apiURL string, gatewayURL string, cidVersion int, auth string) (*KuboStorage, error) {
+	if cidVersion != 0 && cidVersion != 1 {
+		return nil, fmt.Errorf("invalid KUBO_CID_VERSION %d, must be 0 or 1", cidVersion)
+	}
+	s := &KuboStorage{
+		APIURL:     strings.TrimSuffix(apiURL, "/"),
+		GatewayURL: strings.TrimSuffix(strings.TrimSuffix(gatewayURL, "/"), "/ipfs"),
+		CIDVersion: cidVersion,
+		Auth:       auth,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var version struct{ Version string }
+	if err := s.call(ctx, "version", nil, &version); err != nil {
+		return nil, fmt.Errorf("failed to reach Kubo at %s: %w", s.APIURL, err)
+	}
+	fmt.Printf("Connected to Kubo %s at %s\n", version.Version, s.APIURL)
+	return s, nil
+}
+
+func (s *KuboStorage) Name() string {
+	return StorageDriverKubo
+}
+
+func (s *KuboStorage) PutAsset(ctx context.Context, file io.Reader, size int64, originalFileName string, progressChannel string) (model.AssetStruct, error) {
+	categoryID, folderName, err := categorizePinnedFile(originalFileName)
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+	extensionFileName := filepath.Ext(originalFileName)
+	basename := strings.TrimSuffix(originalFileName, extensionFileName)
+	timestamp := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(time.Now().Format(time.RFC3339Nano), ":", "-"), ".", "-"), "Z", "")
+	newFileName := fmt.Sprintf("%s_%s%s", basename, timestamp, extensionFileName)
+
+	cid, err := s.addFile(ctx, file, size, newFileName, progressChannel, "upload")
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+	if err := s.pinAndFile(ctx, cid, folderName, newFileName); err != nil {
+		return model.AssetStruct{}, err
+	}
+	reportStored(ctx, progressChannel, "upload", "")
+	return model.AssetStruct{Filename: basename, IpfsHash: cid, CategoryID: categoryID}, nil
+}
+
+func (s *KuboStorage) PutAudio(ctx context.Context, audio io.Reader, size int64, fileName string, progressChannel string) (model.AudioStruct, error) {
+	cid, err := s.addFile(ctx, audio, size, fileName, progressChannel, "tts")
+	if err != nil {
+		return model.AudioStruct{}, err
+	}
+	if err := s.pinAndFile(ctx, cid, "Audio", fileName); err != nil {
+		return model.AudioStruct{}, err
+	}
+	reportStored(ctx, progressChannel, "tts", cid)
+	return model.AudioStruct{IpfsHash: cid}, nil
+}
+
+func (s *KuboStorage) PutDirectory(ctx context.Context, dir string, progressChannel string) (model.AssetStruct, error) {
+	root := filepath.Base(dir)
+
+	type entry struct {
+		path string
+		rel  string
+		dir  bool
+	}
+	var entries []entry
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		entries = append(entries, entry{path: path, rel: filepath.ToSlash(rel), dir: d.IsDir()})
+		return nil
+	})
+	if err != nil {
+		return model.AssetStruct{}, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	if len(entries) < 2 {
+		return model.AssetStruct{}, fmt.Errorf("directory %s is empty", root)
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		defer pw.Close()
+
+		// Kubo rebuilds the tree from the part names and needs a directory part before the
+		// parts inside it; WalkDir already lists them in that order.
+		progR := &progressReader{total: total, ch: progressChannel, typ: "upload", jobID: jobIDFromContext(ctx)}
+		for _, e := range entries {
+			name := root
+			if e.rel != "." {
+				name += "/" + e.rel
+			}
+			if e.dir {
+				if _, err := createKuboPart(writer, name, "application/x-directory"); err != nil {
+					_ = pw.CloseWithError(err)
+					return
+				}
+				continue
+			}
+			part, err := createKuboPart(writer, name, "application/octet-stream")
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			file, err := os.Open(e.path)
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			progR.r = file
+			_, err = io.Copy(part, progR)
+			file.Close()
+			if err != nil {
+				_ = pw.CloseWithError(fmt.Errorf("copy file failed: %w", err))
+				return
+			}
+		}
+		if err := writer.Close(); err != nil {
+			_ = pw.CloseWithError(fmt.Errorf("writer close failed: %w", err))
+		}
+	}()
+
+	added, err := s.add(ctx, pr, writer.FormDataContentType())
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+	// Kubo reports every file and directory, the root is the one named after it
+	var cid string
+	for _, a := range added {
+		if a.Name == root {
+			cid = a.Hash
+		}
+	}
+	if cid == "" {
+		return model.AssetStruct{}, fmt.Errorf("Kubo did not return the CID of %s", root)
+	}
+	if err := s.pinAndFile(ctx, cid, "Asset_Image", root); err != nil {
+		return model.AssetStruct{}, err
+	}
+	reportStored(ctx, progressChannel, "upload", "")
+	return model.AssetStruct{Filename: root, IpfsHash: cid, CategoryID: 1}, nil
+}
+
+// Get streams a file with /api/v0/cat, the gateway may not be reachable from the backend.
+func (s *KuboStorage) Get(ctx context.Context, cid string, path string) (io.ReadCloser, *StorageObject, error) {
+	object, err := s.Stat(ctx, cid, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.post(ctx, "cat", url.Values{"arg": {ipfsPath(cid, path)}}, nil, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	body := bufio.NewReader(resp.Body)
+	if object.ContentType == "" {
+		head, _ := body.Peek(512)
+		object.ContentType = http.DetectContentType(head)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{body, resp.Body}, object, nil
+}
+
+func (s *KuboStorage) GetRange(ctx context.Context, cid string, path string, offset int64, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	params := url.Values{"arg": {ipfsPath(cid, path)}, "offset": {strconv.FormatInt(offset, 10)}}
+	if length >= 0 {
+		params.Set("length", strconv.FormatInt(length, 10))
+	}
+	resp, err := s.post(ctx, "cat", params, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *KuboStorage) Stat(ctx context.Context, cid string, path string) (*StorageObject, error) {
+	var stat struct {
+		Size int64
+		Type string
+	}
+	if err := s.call(ctx, "files/stat", url.Values{"arg": {ipfsPath(cid, path)}}, &stat); err != nil {
+		return nil, err
+	}
+	if stat.Type != "file" {
+		return nil, ErrObjectNotFound
+	}
+	return &StorageObject{ID: cid, Path: path, Size: stat.Size, ContentType: contentTypeByName(path)}, nil
+}
+
+// Delete unpins cid and removes it from MFS. The blocks are freed by the node's next GC.
+func (s *KuboStorage) Delete(ctx context.Context, cid string) error {
+	err := s.call(ctx, "pin/rm", url.Values{"arg": {cid}}, nil)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	notPinned := err != nil
+
+	// MFS entries keep their blocks from being collected just like a pin does
+	removed := false
+	for _, folder := range kuboFolders {
+		var listing struct {
+			Entries []struct {
+				Name string
+				Hash string
+			}
+		}
+		if err := s.call(ctx, "files/ls", url.Values{"arg": {"/" + folder}, "long": {"true"}}, &listing); err != nil {
+			continue
+		}
+		for _, entry := range listing.Entries {
+			if entry.Hash != cid {
+				continue
+			}
+			if err := s.call(ctx, "files/rm", url.Values{"arg": {"/" + folder + "/" + entry.Name}, "recursive": {"true"}}, nil); err != nil {
+				return err
+			}
+			removed = true
+		}
+	}
+	if notPinned && !removed {
+		return ErrObjectNotFound
+	}
+	return nil
+}
+
+func (s *KuboStorage) URL(cid string, path string) string {
+	link := s.GatewayURL + "/ipfs/" + cid
+	if path != "" {
+		link += "/" + strings.TrimPrefix(path, "/")
+	}
+	return link
+}
+
+// ContentID computes the CID the node will give the content with the same CID version.
+func (s *KuboStorage) ContentID(content io.Reader) (string, error) {
+	return ComputeCID(content, s.CIDVersion)
+}
+
+type kuboAdded struct {
+	Name string
+	Hash string
+	Size string
+}
+
+// addFile streams r to /api/v0/add as a single file and returns its CID.
+func (s *KuboStorage) addFile(ctx context.Context, r io.Reader, size int64, fileName string, progressChannel string, typ string) (string, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		defer pw.Close()
+		part, err := createKuboPart(writer, fileName, "application/octet-stream")
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		progR := &progressReader{r: r, total: size, ch: progressChannel, typ: typ, jobID: jobIDFromContext(ctx)}
+		if _, err := io.Copy(part, progR); err != nil {
+			_ = pw.CloseWithError(fmt.Errorf("copy file failed: %w", err))
+			return
+		}
+		if err := writer.Close(); err != nil {
+			_ = pw.CloseWithError(fmt.Errorf("writer close failed: %w", err))
+		}
+	}()
+
+	added, err := s.add(ctx, pr, writer.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+	if len(added) == 0 || added[len(added)-1].Hash == "" {
+		return "", fmt.Errorf("Kubo did not return the CID of %s", fileName)
+	}
+	return added[len(added)-1].Hash, nil
+}
+
+// add posts a multipart body to /api/v0/add without pinning; pinAndFile pins what it returns.
+func (s *KuboStorage) add(ctx context.Context, body io.Reader, contentType string) ([]kuboAdded, error) {
+	params := url.Values{
+		"cid-version": {strconv.Itoa(s.CIDVersion)},
+		"pin":         {"false"},
+		"progress":    {"false"},
+	}
+	resp, err := s.post(ctx, "add", params, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// One JSON object per added file or directory
+	var added []kuboAdded
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var a kuboAdded
+		if err := decoder.Decode(&a); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse Kubo add response: %w", err)
+		}
+		added = append(added, a)
+	}
+	return added, nil
+}
+
+// pinAndFile pins cid and copies it into /<folder>/<name> in MFS. The pin is what keeps the
+// content, so a name already taken in MFS only gets a warning.
+func (s *KuboStorage) pinAndFile(ctx context.Context, cid string, folder string, name string) error {
+	if err := s.call(ctx, "pin/add", url.Values{"arg": {cid}}, nil); err != nil {
+		return fmt.Errorf("failed to pin %s: %w", cid, err)
+	}
+	mfsPath := "/" + folder + "/" + strings.ReplaceAll(name, "/", "_")
+	if err := s.call(ctx, "files/cp", url.Values{"arg": {"/ipfs/" + cid, mfsPath}, "parents": {"true"}}, nil); err != nil {
+		fmt.Printf("[WARN] failed to copy %s to MFS %s: %v\n", cid, mfsPath, err)
+	}
+	return nil
+}
+
+// call runs an RPC command and decodes its JSON response into out, which may be nil.
+func (s *KuboStorage) call(ctx context.Context, command string, params url.Values, out interface{}) error {
+	resp, err := s.post(ctx, command, params, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse Kubo %s response: %w", command, err)
+	}
+	return nil
+}
+
+// post sends an RPC request (Kubo only accepts POST) and returns the response if it succeeded.
+func (s *KuboStorage) post(ctx context.Context, command string, params url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	apiURL := s.APIURL + "/api/v0/" + command
+	if len(params) > 0 {
+		apiURL += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.Auth != "" {
+		req.Header.Set("Authorization", s.Auth)
+	}
+
+	resp, err := kuboClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to Kubo: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var rpcErr struct{ Message string }
+	respBytes, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(respBytes, &rpcErr) != nil || rpcErr.Message == "" {
+		rpcErr.Message = string(respBytes)
+	}
+	if isKuboNotFound(rpcErr.Message) {
+		return nil, ErrObjectNotFound
+	}
+	return nil, fmt.Errorf("Kubo %s returned %d - %s", command, resp.StatusCode, rpcErr.Message)
+}
+
+func isKuboNotFound(message string) bool {
+	for _, text := range []string{"not pinned", "does not exist", "no link named"} {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// createKuboPart starts a part the way Kubo's own client does: a query-escaped path as the file name.
+func createKuboPart(writer *multipart.Writer, name string, contentType string) (io.Writer, error) {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, url.QueryEscape(name)))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, fmt.Errorf("create form file failed: %w", err)
+	}
+	return part, nil
+}
+
+func ipfsPath(cid string, objectPath string) string {
+	p := "/ipfs/" + cid
+	if objectPath != "" {
+		p += path.Clean("/" + objectPath)
+	}
+	return p
+}
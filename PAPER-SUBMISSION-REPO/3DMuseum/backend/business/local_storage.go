@@ -0,0 +1,335 @@
+package business
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"main/model"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"main/websocket"
+)
+
+// LocalStorage keeps objects on the local disk, addressed by the SHA-256 of their content,
+// so the backend runs without a Pinata account. Root is served over HTTP under URL.
+//
+// A file is stored as Root/<sha256>, a directory as Root/<id>/... where id hashes the
+// relative paths and hashes of its files.
+type LocalStorage struct {
+	Root    string
+	BaseURL string
+}
+
+func NewLocalStorage(root string, baseURL string) (*LocalStorage, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage root: %w", err)
+	}
+	return &LocalStorage{Root: root, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *LocalStorage) Name() string {
+	return StorageDriverLocal
+}
+
+func (s *LocalStorage) PutAsset(ctx context.Context, file io.Reader, size int64, originalFileName string, progressChannel string) (model.AssetStruct, error) {
+	categoryID, _, err := categorizePinnedFile(originalFileName)
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+	id, err := s.putFile(ctx, file, size, progressChannel, "upload")
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+	reportStored(ctx, progressChannel, "upload", "")
+	return model.AssetStruct{
+		Filename:   strings.TrimSuffix(originalFileName, filepath.Ext(originalFileName)),
+		IpfsHash:   id,
+		CategoryID: categoryID,
+	}, nil
+}
+
+func (s *LocalStorage) PutAudio(ctx context.Context, audio io.Reader, size int64, fileName string, progressChannel string) (model.AudioStruct, error) {
+	id, err := s.putFile(ctx, audio, size, progressChannel, "tts")
+	if err != nil {
+		return model.AudioStruct{}, err
+	}
+	reportStored(ctx, progressChannel, "tts", id)
+	return model.AudioStruct{IpfsHash: id}, nil
+}
+
+func (s *LocalStorage) PutDirectory(ctx context.Context, dir string, progressChannel string) (model.AssetStruct, error) {
+	root := filepath.Base(dir)
+
+	var files []string
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, path)
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return model.AssetStruct{}, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	if len(files) == 0 {
+		return model.AssetStruct{}, fmt.Errorf("directory %s is empty", root)
+	}
+	sort.Strings(files)
+
+	tmp, err := os.MkdirTemp(s.Root, ".put-*")
+	if err != nil {
+		return model.AssetStruct{}, fmt.Errorf("failed to create local storage temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The directory ID covers both names and content, like a directory CID does
+	dirHash := sha256.New()
+	progR := &progressReader{total: total, ch: progressChannel, typ: "upload", jobID: jobIDFromContext(ctx)}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return model.AssetStruct{}, err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return model.AssetStruct{}, err
+		}
+		fileHash, err := copyHashed(file, filepath.Join(tmp, rel), progR)
+		if err != nil {
+			return model.AssetStruct{}, err
+		}
+		fmt.Fprintf(dirHash, "%s\x00%s\n", filepath.ToSlash(rel), fileHash)
+	}
+
+	id := hex.EncodeToString(dirHash.Sum(nil))
+	if err := storeObject(tmp, filepath.Join(s.Root, id)); err != nil {
+		return model.AssetStruct{}, fmt.Errorf("failed to store %s: %w", root, err)
+	}
+	reportStored(ctx, progressChannel, "upload", "")
+	return model.AssetStruct{Filename: root, IpfsHash: id, CategoryID: 1}, nil
+}
+
+func (s *LocalStorage) Get(ctx context.Context, id string, path string) (io.ReadCloser, *StorageObject, error) {
+	name, err := s.objectPath(id, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrObjectNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	object, err := localObjectInfo(file, id, path)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, object, nil
+}
+
+func (s *LocalStorage) GetRange(ctx context.Context, id string, path string, offset int64, length int64) (io.ReadCloser, error) {
+	file, _, err := s.Get(ctx, id, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.(*os.File).Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return limitReadCloser(file, length), nil
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, id string, path string) (*StorageObject, error) {
+	file, object, err := s.Get(ctx, id, path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return object, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, id string) error {
+	name, err := s.objectPath(id, "")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return os.RemoveAll(name)
+}
+
+func (s *LocalStorage) URL(id string, path string) string {
+	link := s.BaseURL + "/" + id
+	if path != "" {
+		link += "/" + strings.TrimPrefix(path, "/")
+	}
+	return link
+}
+
+func (s *LocalStorage) ContentID(content io.Reader) (string, error) {
+	id, _, err := hashContent(content)
+	return id, err
+}
+
+// putFile streams r into the store and returns the hex SHA-256 it is stored under.
+func (s *LocalStorage) putFile(ctx context.Context, r io.Reader, size int64, progressChannel string, typ string) (string, error) {
+	tmp, err := os.CreateTemp(s.Root, ".put-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create local storage temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	progR := &progressReader{r: r, total: size, ch: progressChannel, typ: typ, jobID: jobIDFromContext(ctx)}
+	_, err = io.Copy(io.MultiWriter(tmp, hash), progR)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write to local storage: %w", err)
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))
+	if err := storeObject(tmp.Name(), filepath.Join(s.Root, id)); err != nil {
+		return "", fmt.Errorf("failed to store %s: %w", id, err)
+	}
+	return id, nil
+}
+
+// objectPath maps an ID and a path inside it to the file on disk, refusing anything that
+// would escape Root.
+func (s *LocalStorage) objectPath(id string, objectPath string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != sha256.Size*2 {
+		return "", ErrObjectNotFound
+	}
+	name := filepath.Join(s.Root, id)
+	if objectPath != "" {
+		name = filepath.Join(name, filepath.FromSlash(path.Clean("/"+objectPath)))
+	}
+	return name, nil
+}
+
+// copyHashed copies src to dst through progR and returns the hex SHA-256 of the content.
+func copyHashed(src string, dst string, progR *progressReader) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	progR.r = in
+	_, err = io.Copy(io.MultiWriter(out, hash), progR)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("copy file failed: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// storeObject moves a finished temp file or directory to its ID. An object that already
+// exists under that ID has the same content, so failing to replace it is not an error.
+func storeObject(tmp string, target string) error {
+	err := os.Rename(tmp, target)
+	if err != nil {
+		if _, statErr := os.Stat(target); statErr == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// localObjectInfo describes an opened object and leaves file positioned at its start.
+func localObjectInfo(file *os.File, id string, path string) (*StorageObject, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, ErrObjectNotFound
+	}
+
+	contentType := contentTypeByName(path)
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		contentType = http.DetectContentType(head[:n])
+	}
+	return &StorageObject{ID: id, Path: path, Size: info.Size(), ContentType: contentType, ModTime: info.ModTime()}, nil
+}
+
+// contentTypeByName covers the formats the pipeline writes that content sniffing misses.
+func contentTypeByName(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".webp":
+		return "image/webp"
+	case ".ktx2":
+		return "image/ktx2"
+	case ".glb":
+		return "model/gltf-binary"
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".dzi", ".xml":
+		return "application/xml"
+	}
+	return ""
+}
+
+// reportStored tells progressChannel an upload has finished, the way the Pinata uploads do.
+func reportStored(ctx context.Context, progressChannel string, typ string, cid string) {
+	if progressChannel == "" {
+		return
+	}
+	msg := map[string]interface{}{
+		"type":     typ,
+		"status":   "completed",
+		"progress": 100,
+	}
+	if cid != "" {
+		msg["cid"] = cid
+	}
+	if jobID := jobIDFromContext(ctx); jobID != "" {
+		msg["job_id"] = jobID
+	}
+	websocket.GlobalHub.BroadcastProgress(progressChannel, msg)
+}
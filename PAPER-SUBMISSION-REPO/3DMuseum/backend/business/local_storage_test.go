@@ -0,0 +1,182 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestLocalStorage(t *testing.T) *LocalStorage {
+	t.Helper()
+	storage, err := NewLocalStorage(t.TempDir(), "http://localhost/storage/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func readAll(t *testing.T, rc io.ReadCloser) []byte {
+	t.Helper()
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLocalStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestLocalStorage(t)
+	content := []byte("glTF local storage round trip")
+	sum := sha256.Sum256(content)
+
+	stored, err := storage.PutAsset(ctx, bytes.NewReader(content), int64(len(content)), "statue.glb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := stored.IpfsHash
+	if id != hex.EncodeToString(sum[:]) || stored.Filename != "statue" || stored.CategoryID != 3 {
+		t.Fatalf("got %+v, want the SHA-256 of the content in category 3", stored)
+	}
+	if contentID, err := storage.ContentID(bytes.NewReader(content)); err != nil || contentID != id {
+		t.Errorf("ContentID = %s, %v, want %s", contentID, err, id)
+	}
+	if got, want := storage.URL(id, ""), "http://localhost/storage/"+id; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+
+	body, object, err := storage.Get(ctx, id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, body); !bytes.Equal(got, content) {
+		t.Errorf("Get = %q, want %q", got, content)
+	}
+	if object.ID != id || object.Size != int64(len(content)) {
+		t.Errorf("Get object = %+v", object)
+	}
+
+	object, err = storage.Stat(ctx, id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Size != int64(len(content)) || object.ContentType == "" {
+		t.Errorf("Stat = %+v", object)
+	}
+
+	ranged, err := storage.GetRange(ctx, id, "", 5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, ranged); !bytes.Equal(got, content[5:10]) {
+		t.Errorf("GetRange = %q, want %q", got, content[5:10])
+	}
+
+	// Identical content is stored once
+	again, err := storage.PutAsset(ctx, bytes.NewReader(content), int64(len(content)), "copy.glb", "")
+	if err != nil || again.IpfsHash != id {
+		t.Errorf("second PutAsset = %+v, %v, want ID %s", again, err, id)
+	}
+
+	if err := storage.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.Get(ctx, id, ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get after Delete: %v, want ErrObjectNotFound", err)
+	}
+	if _, err := storage.Stat(ctx, id, ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Stat after Delete: %v, want ErrObjectNotFound", err)
+	}
+	if err := storage.Delete(ctx, id); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("second Delete: %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestLocalStorageDirectory(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestLocalStorage(t)
+
+	dir := filepath.Join(t.TempDir(), "tiles")
+	files := map[string]string{
+		"image.dzi":             "<Image/>",
+		"image_files/0/0_0.jpg": "tile 0",
+		"image_files/1/0_0.jpg": "tile 1",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored, err := storage.PutDirectory(ctx, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		body, object, err := storage.Get(ctx, stored.IpfsHash, name)
+		if err != nil {
+			t.Fatalf("Get %s: %v", name, err)
+		}
+		if got := readAll(t, body); string(got) != content {
+			t.Errorf("Get %s = %q, want %q", name, got, content)
+		}
+		if object.Path != name {
+			t.Errorf("Get %s: object path %s", name, object.Path)
+		}
+	}
+	// A directory itself is not a file that can be served
+	if _, err := storage.Stat(ctx, stored.IpfsHash, "image_files"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Stat of a subdirectory: %v, want ErrObjectNotFound", err)
+	}
+
+	if err := storage.Delete(ctx, stored.IpfsHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(storage.Root, stored.IpfsHash)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("directory left behind after Delete: %v", err)
+	}
+}
+
+func TestLocalStorageObjectPath(t *testing.T) {
+	storage := newTestLocalStorage(t)
+	id := strings.Repeat("ab", sha256.Size)
+
+	for _, badID := range []string{"", "..", "../" + id, id[:10], strings.Repeat("zz", sha256.Size), id + "/x"} {
+		if _, err := storage.objectPath(badID, ""); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("objectPath(%q) = %v, want ErrObjectNotFound", badID, err)
+		}
+	}
+
+	objectDir := filepath.Join(storage.Root, id)
+	for _, path := range []string{"a.jpg", "/a.jpg", "../a.jpg", "../../a.jpg", "x/../../../a.jpg", `..\..\a.jpg`} {
+		name, err := storage.objectPath(id, path)
+		if err != nil {
+			t.Errorf("objectPath(%q): %v", path, err)
+			continue
+		}
+		if !strings.HasPrefix(name, objectDir+string(filepath.Separator)) {
+			t.Errorf("objectPath(%q) = %s escapes %s", path, name, objectDir)
+		}
+	}
+
+	// A file next to the store is out of reach through any path
+	secret := filepath.Join(filepath.Dir(storage.Root), "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.Get(context.Background(), id, "../../secret.txt"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get outside Root: %v, want ErrObjectNotFound", err)
+	}
+}
@@ -0,0 +1,317 @@
+package business
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Vertex clustering: snap every vertex to a uniform grid over the primitive's bounds, merge the
+// vertices that share a cell and drop triangles that collapse. It is fast and memory-friendly
+// for million-triangle scans, at the cost of some detail at seams, which is fine for LODs seen
+// from a distance.
+
+// simplifyMesh rewrites every triangle primitive of the mesh with about ratio of its triangles.
+// New vertex and index data is appended to the asset as a new buffer; the old accessors become
+// unreferenced and are dropped by repack. Primitives with morph targets or sparse positions
+// are left as they are.
+func (a *gltfAsset) simplifyMesh(meshIndex int, ratio float64) error {
+	var out bytes.Buffer
+	bufferIndex := len(a.Buffers)
+	mesh := &a.Root.Meshes[meshIndex]
+
+	for p := range mesh.Primitives {
+		primitive := &mesh.Primitives[p]
+		if (primitive.Mode != nil && *primitive.Mode != 4) || len(primitive.Targets) > 0 {
+			continue
+		}
+		positionIndex, ok := primitive.Attributes["POSITION"]
+		if !ok || !a.hasAccessors(primitive) {
+			continue
+		}
+		positionAccessor := a.Root.Accessors[positionIndex]
+		if positionAccessor.Sparse != nil || positionAccessor.ComponentType != 5126 || positionAccessor.Type != "VEC3" {
+			continue
+		}
+
+		positions, err := a.readPositions(positionAccessor)
+		if err != nil {
+			return err
+		}
+		var indices []uint32
+		if primitive.Indices != nil {
+			if indices, err = a.readIndices(a.Root.Accessors[*primitive.Indices]); err != nil {
+				return err
+			}
+		} else {
+			indices = make([]uint32, len(positions))
+			for i := range indices {
+				indices[i] = uint32(i)
+			}
+		}
+		for _, index := range indices {
+			if int(index) >= len(positions) {
+				return errors.New("index points past the vertex data")
+			}
+		}
+
+		target := int(float64(len(indices)/3) * ratio)
+		clusters, triangles := clusterVertices(positions, indices, target)
+		if len(triangles) == 0 {
+			continue
+		}
+		if err := a.writeSimplifiedPrimitive(primitive, &out, bufferIndex, positions, clusters, triangles); err != nil {
+			return err
+		}
+	}
+
+	if out.Len() > 0 {
+		a.Buffers = append(a.Buffers, out.Bytes())
+		a.Root.Buffers = append(a.Root.Buffers, gltfBuffer{ByteLength: out.Len()})
+	}
+	return nil
+}
+
+// hasAccessors reports whether every attribute and the indices of the primitive name an accessor
+// of the asset, primitives that do not are left as they are.
+func (a *gltfAsset) hasAccessors(primitive *gltfPrimitive) bool {
+	valid := func(index int) bool { return index >= 0 && index < len(a.Root.Accessors) }
+	for _, index := range primitive.Attributes {
+		if !valid(index) {
+			return false
+		}
+	}
+	return primitive.Indices == nil || valid(*primitive.Indices)
+}
+
+func (a *gltfAsset) readPositions(acc gltfAccessor) ([][3]float32, error) {
+	data, err := a.accessorBytes(acc)
+	if err != nil {
+		return nil, err
+	}
+	positions := make([][3]float32, acc.Count)
+	for i := range positions {
+		for c := 0; c < 3; c++ {
+			positions[i][c] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*12+c*4:]))
+		}
+	}
+	return positions, nil
+}
+
+// vertexClusters is the result of snapping vertices to a grid.
+type vertexClusters struct {
+	representative []uint32     // one original vertex per cluster, its other attributes are kept
+	position       [][3]float32 // mean position of the cluster
+}
+
+// clusterVertices searches the grid resolution whose simplified mesh comes closest to target
+// triangles without exceeding it and returns the clusters and the remapped triangles.
+func clusterVertices(positions [][3]float32, indices []uint32, target int) (vertexClusters, []uint32) {
+	var lo, hi [3]float32
+	for c := 0; c < 3; c++ {
+		lo[c], hi[c] = math.MaxFloat32, -math.MaxFloat32
+	}
+	for _, index := range indices {
+		for c := 0; c < 3; c++ {
+			lo[c] = min(lo[c], positions[index][c])
+			hi[c] = max(hi[c], positions[index][c])
+		}
+	}
+
+	// Triangle count grows with the resolution, binary search the largest one under target
+	low, high := 1, 2048
+	var bestCells []int64
+	var bestTriangles []uint32
+	for low <= high {
+		resolution := (low + high) / 2
+		cells, triangles := clusterAtResolution(positions, indices, lo, hi, resolution)
+		if len(triangles)/3 <= target || bestTriangles == nil {
+			bestCells, bestTriangles = cells, triangles
+		}
+		if len(triangles)/3 <= target {
+			low = resolution + 1
+		} else {
+			high = resolution - 1
+		}
+	}
+
+	// Give every surviving cell a new vertex index
+	var clusters vertexClusters
+	newIndex := make(map[int64]uint32)
+	counts := []float32{}
+	remapped := make([]uint32, len(bestTriangles))
+	for i, vertex := range bestTriangles {
+		cell := bestCells[vertex]
+		n, ok := newIndex[cell]
+		if !ok {
+			n = uint32(len(clusters.representative))
+			newIndex[cell] = n
+			clusters.representative = append(clusters.representative, vertex)
+			clusters.position = append(clusters.position, [3]float32{})
+			counts = append(counts, 0)
+		}
+		remapped[i] = n
+	}
+	// Mean position over every original vertex of a surviving cell
+	for vertex, cell := range bestCells {
+		if n, ok := newIndex[cell]; ok && cell >= 0 {
+			for c := 0; c < 3; c++ {
+				clusters.position[n][c] += positions[vertex][c]
+			}
+			counts[n]++
+		}
+	}
+	for n := range clusters.position {
+		for c := 0; c < 3; c++ {
+			clusters.position[n][c] /= counts[n]
+		}
+	}
+	return clusters, remapped
+}
+
+// clusterAtResolution assigns each vertex a cell (-1 when unused) and returns the triangles that
+// survive, still as original vertex indices, using the first vertex of each cell as its stand-in.
+func clusterAtResolution(positions [][3]float32, indices []uint32, lo, hi [3]float32, resolution int) ([]int64, []uint32) {
+	cells := make([]int64, len(positions))
+	for i := range cells {
+		cells[i] = -1
+	}
+	var scale [3]float32
+	for c := 0; c < 3; c++ {
+		if extent := hi[c] - lo[c]; extent > 0 {
+			scale[c] = float32(resolution) / extent
+		}
+	}
+	cellOf := func(vertex uint32) int64 {
+		var cell [3]int64
+		for c := 0; c < 3; c++ {
+			cell[c] = min(int64((positions[vertex][c]-lo[c])*scale[c]), int64(resolution-1))
+		}
+		return cell[0] + cell[1]*int64(resolution) + cell[2]*int64(resolution)*int64(resolution)
+	}
+
+	standIn := make(map[int64]uint32)
+	seen := make(map[[3]uint32]bool)
+	var triangles []uint32
+	for t := 0; t+2 < len(indices); t += 3 {
+		var tri [3]uint32
+		for k := 0; k < 3; k++ {
+			vertex := indices[t+k]
+			cell := cellOf(vertex)
+			cells[vertex] = cell
+			first, ok := standIn[cell]
+			if !ok {
+				first = vertex
+				standIn[cell] = vertex
+			}
+			tri[k] = first
+		}
+		if tri[0] == tri[1] || tri[1] == tri[2] || tri[0] == tri[2] {
+			continue
+		}
+		key := sortedTriangle(tri)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		triangles = append(triangles, tri[:]...)
+	}
+	return cells, triangles
+}
+
+func sortedTriangle(tri [3]uint32) [3]uint32 {
+	if tri[0] > tri[1] {
+		tri[0], tri[1] = tri[1], tri[0]
+	}
+	if tri[1] > tri[2] {
+		tri[1], tri[2] = tri[2], tri[1]
+	}
+	if tri[0] > tri[1] {
+		tri[0], tri[1] = tri[1], tri[0]
+	}
+	return tri
+}
+
+// writeSimplifiedPrimitive appends the clustered vertex attributes and indices to out and points
+// the primitive at new accessors for them.
+func (a *gltfAsset) writeSimplifiedPrimitive(primitive *gltfPrimitive, out *bytes.Buffer, bufferIndex int, positions [][3]float32, clusters vertexClusters, triangles []uint32) error {
+	root := &a.Root
+	addView := func(data []byte, stride int, target int) int {
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+		root.BufferViews = append(root.BufferViews, gltfBufferView{
+			Buffer: bufferIndex, ByteOffset: out.Len(), ByteLength: len(data), ByteStride: stride, Target: target,
+		})
+		out.Write(data)
+		return len(root.BufferViews) - 1
+	}
+	addAccessor := func(acc gltfAccessor) int {
+		root.Accessors = append(root.Accessors, acc)
+		return len(root.Accessors) - 1
+	}
+
+	attributes := make(map[string]int, len(primitive.Attributes))
+	for name, index := range primitive.Attributes {
+		source := root.Accessors[index]
+		if name == "POSITION" {
+			data := make([]byte, 0, len(clusters.position)*12)
+			lo := []float64{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64}
+			hi := []float64{-math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64}
+			for _, p := range clusters.position {
+				for c := 0; c < 3; c++ {
+					data = binary.LittleEndian.AppendUint32(data, math.Float32bits(p[c]))
+					lo[c] = min(lo[c], float64(p[c]))
+					hi[c] = max(hi[c], float64(p[c]))
+				}
+			}
+			view := addView(data, 0, 34962)
+			attributes[name] = addAccessor(gltfAccessor{BufferView: &view, ComponentType: 5126, Count: len(clusters.position), Type: "VEC3", Min: lo, Max: hi})
+			continue
+		}
+		if source.Sparse != nil || source.Count != len(positions) {
+			return errors.New("attribute " + name + " cannot be simplified")
+		}
+
+		elements, err := a.accessorBytes(source)
+		if err != nil {
+			return err
+		}
+		// Vertex attribute elements must start on a 4-byte boundary
+		elementSize := source.elementSize()
+		stride := (elementSize + 3) &^ 3
+		data := make([]byte, 0, len(clusters.representative)*stride)
+		for _, vertex := range clusters.representative {
+			data = append(data, elements[int(vertex)*elementSize:int(vertex+1)*elementSize]...)
+			data = append(data, make([]byte, stride-elementSize)...)
+		}
+		if stride == elementSize {
+			stride = 0
+		}
+		view := addView(data, stride, 34962)
+		attributes[name] = addAccessor(gltfAccessor{
+			BufferView: &view, ComponentType: source.ComponentType, Normalized: source.Normalized,
+			Count: len(clusters.representative), Type: source.Type, Name: source.Name,
+		})
+	}
+
+	componentType := 5123
+	var data []byte
+	if len(clusters.representative) > math.MaxUint16 {
+		componentType = 5125
+		for _, index := range triangles {
+			data = binary.LittleEndian.AppendUint32(data, index)
+		}
+	} else {
+		for _, index := range triangles {
+			data = binary.LittleEndian.AppendUint16(data, uint16(index))
+		}
+	}
+	view := addView(data, 0, 34963)
+	indices := addAccessor(gltfAccessor{BufferView: &view, ComponentType: componentType, Count: len(triangles), Type: "SCALAR"})
+
+	primitive.Attributes = attributes
+	primitive.Indices = &indices
+	return nil
+}
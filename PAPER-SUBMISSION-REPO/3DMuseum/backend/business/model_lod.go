@@ -0,0 +1,283 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"main/model"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ModelLOD is one level of detail of a model. Level 0 is the input itself and has no Path.
+type ModelLOD struct {
+	Label     string
+	Ratio     float64
+	Path      string
+	Triangles int
+	Bounds    *model.BoundingBox
+}
+
+// modelLODLevels are the LODs generated for every model, as a share of its triangles.
+var modelLODLevels = []struct {
+	Label string
+	Ratio float64
+}{
+	{"lod0", 1},
+	{"lod1", 0.25},
+	{"lod2", 0.05},
+}
+
+// Levels that would have fewer triangles than this are not worth a separate download.
+const minLODTriangles = 500
+
+// GenerateModelLODs simplifies the GLB at inputPath into the levels of modelLODLevels, written
+// into outputDir as <name>-lod1.glb etc. Textures, materials and the node hierarchy are shared
+// with the input; only the mesh data is simplified.
+func GenerateModelLODs(ctx context.Context, inputPath string, outputDir string) ([]ModelLOD, error) {
+	var lods []ModelLOD
+	err := Converters().Run(ctx, func(ctx context.Context) error {
+		source, err := readGLTFAsset(inputPath)
+		if err != nil {
+			return fmt.Errorf("failed to read model: %w", err)
+		}
+		for _, ext := range source.Root.ExtensionsUsed {
+			if slices.Contains(gltfOpaqueExtensions, ext) {
+				return fmt.Errorf("%w: uses %s", ErrModelNotOptimizable, ext)
+			}
+		}
+		triangles, err := source.countTriangles()
+		if err != nil {
+			return err
+		}
+		lods = append(lods, ModelLOD{Label: modelLODLevels[0].Label, Ratio: 1, Triangles: triangles, Bounds: source.sceneBounds()})
+
+		base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+		for _, level := range modelLODLevels[1:] {
+			if float64(triangles)*level.Ratio < minLODTriangles {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// Every level starts again from the full model, so errors do not accumulate
+			asset, err := readGLTFAsset(inputPath)
+			if err != nil {
+				return err
+			}
+			for i := range asset.Root.Meshes {
+				if err := asset.simplifyMesh(i, level.Ratio); err != nil {
+					return fmt.Errorf("%s: mesh %d: %w", level.Label, i, err)
+				}
+			}
+			bin, err := asset.repack(nil)
+			if err != nil {
+				return fmt.Errorf("%s: %w", level.Label, err)
+			}
+
+			lod := ModelLOD{Label: level.Label, Ratio: level.Ratio, Path: filepath.Join(outputDir, base+"-"+level.Label+".glb")}
+			if lod.Triangles, err = asset.countTriangles(); err != nil {
+				return err
+			}
+			lod.Bounds = asset.sceneBounds()
+			out, err := os.Create(lod.Path)
+			if err != nil {
+				return err
+			}
+			lods = append(lods, lod)
+			if err := writeGLB(out, asset.Root, bin); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, lod := range lods {
+			if lod.Path != "" {
+				_ = os.Remove(lod.Path)
+			}
+		}
+		return nil, err
+	}
+	return lods, nil
+}
+
+// sceneBounds returns the axis-aligned box around every mesh instance of the default scene,
+// with node transforms applied. Nil when the model has no positions.
+func (a *gltfAsset) sceneBounds() *model.BoundingBox {
+	root := &a.Root
+	box := model.BoundingBox{
+		Min: [3]float64{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64},
+		Max: [3]float64{-math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64},
+	}
+	found := false
+
+	a.walkScene(func(mesh int, world [16]float64) {
+		for _, primitive := range root.Meshes[mesh].Primitives {
+			lo, hi, ok := a.positionRange(primitive)
+			if !ok {
+				continue
+			}
+			for corner := 0; corner < 8; corner++ {
+				p := [3]float64{lo[0], lo[1], lo[2]}
+				for c := 0; c < 3; c++ {
+					if corner&(1<<c) != 0 {
+						p[c] = hi[c]
+					}
+				}
+				v := transformPoint(world, p)
+				for c := 0; c < 3; c++ {
+					box.Min[c] = min(box.Min[c], v[c])
+					box.Max[c] = max(box.Max[c], v[c])
+				}
+				found = true
+			}
+		}
+	})
+	if !found {
+		return nil
+	}
+	return &box
+}
+
+// walkScene calls fn for every mesh instance of the default scene with its world transform.
+// Without a scene every node that is nobody's child is treated as a root.
+func (a *gltfAsset) walkScene(fn func(mesh int, world [16]float64)) {
+	root := &a.Root
+	var visit func(node int, parent [16]float64, depth int)
+	visit = func(node int, parent [16]float64, depth int) {
+		if node < 0 || node >= len(root.Nodes) || depth > 64 {
+			return
+		}
+		world := multiplyMatrix(parent, root.Nodes[node].localMatrix())
+		if mesh := root.Nodes[node].Mesh; mesh != nil && *mesh >= 0 && *mesh < len(root.Meshes) {
+			fn(*mesh, world)
+		}
+		for _, child := range root.Nodes[node].Children {
+			visit(child, world, depth+1)
+		}
+	}
+
+	identity := [16]float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	scene := 0
+	if root.Scene != nil {
+		scene = *root.Scene
+	}
+	if scene >= 0 && scene < len(root.Scenes) {
+		for _, node := range root.Scenes[scene].Nodes {
+			visit(node, identity, 0)
+		}
+	} else {
+		child := make([]bool, len(root.Nodes))
+		for _, node := range root.Nodes {
+			for _, c := range node.Children {
+				if c >= 0 && c < len(child) {
+					child[c] = true
+				}
+			}
+		}
+		for node := range root.Nodes {
+			if !child[node] {
+				visit(node, identity, 0)
+			}
+		}
+	}
+}
+
+// positionRange returns the POSITION min/max of a primitive, from the accessor bounds when
+// present (they are required by the spec) or from the data otherwise.
+func (a *gltfAsset) positionRange(primitive gltfPrimitive) ([3]float64, [3]float64, bool) {
+	var lo, hi [3]float64
+	index, ok := primitive.Attributes["POSITION"]
+	if !ok || index < 0 || index >= len(a.Root.Accessors) {
+		return lo, hi, false
+	}
+	acc := a.Root.Accessors[index]
+	if len(acc.Min) == 3 && len(acc.Max) == 3 {
+		copy(lo[:], acc.Min)
+		copy(hi[:], acc.Max)
+		return lo, hi, true
+	}
+	if acc.ComponentType != 5126 || acc.Type != "VEC3" || acc.Sparse != nil {
+		return lo, hi, false
+	}
+	positions, err := a.readPositions(acc)
+	if err != nil || len(positions) == 0 {
+		return lo, hi, false
+	}
+	for c := 0; c < 3; c++ {
+		lo[c], hi[c] = math.MaxFloat64, -math.MaxFloat64
+	}
+	for _, p := range positions {
+		for c := 0; c < 3; c++ {
+			lo[c] = min(lo[c], float64(p[c]))
+			hi[c] = max(hi[c], float64(p[c]))
+		}
+	}
+	return lo, hi, true
+}
+
+// localMatrix returns the node transform as a column-major 4x4 matrix.
+func (n gltfNode) localMatrix() [16]float64 {
+	var m [16]float64
+	if len(n.Matrix) == 16 {
+		copy(m[:], n.Matrix)
+		return m
+	}
+	t := [3]float64{0, 0, 0}
+	r := [4]float64{0, 0, 0, 1}
+	s := [3]float64{1, 1, 1}
+	if len(n.Translation) == 3 {
+		copy(t[:], n.Translation)
+	}
+	if len(n.Rotation) == 4 {
+		copy(r[:], n.Rotation)
+	}
+	if len(n.Scale) == 3 {
+		copy(s[:], n.Scale)
+	}
+	x, y, z, w := r[0], r[1], r[2], r[3]
+	// T * R * S
+	m[0] = (1 - 2*(y*y+z*z)) * s[0]
+	m[1] = (2 * (x*y + z*w)) * s[0]
+	m[2] = (2 * (x*z - y*w)) * s[0]
+	m[4] = (2 * (x*y - z*w)) * s[1]
+	m[5] = (1 - 2*(x*x+z*z)) * s[1]
+	m[6] = (2 * (y*z + x*w)) * s[1]
+	m[8] = (2 * (x*z + y*w)) * s[2]
+	m[9] = (2 * (y*z - x*w)) * s[2]
+	m[10] = (1 - 2*(x*x+y*y)) * s[2]
+	m[12], m[13], m[14], m[15] = t[0], t[1], t[2], 1
+	return m
+}
+
+// transformPoint applies a column-major 4x4 matrix to a point.
+func transformPoint(m [16]float64, p [3]float64) [3]float64 {
+	var v [3]float64
+	for c := 0; c < 3; c++ {
+		v[c] = m[c]*p[0] + m[4+c]*p[1] + m[8+c]*p[2] + m[12+c]
+	}
+	return v
+}
+
+// multiplyMatrix returns a*b for column-major 4x4 matrices.
+func multiplyMatrix(a, b [16]float64) [16]float64 {
+	var m [16]float64
+	for col := 0; col < 4; col++ {
+		for row := 0; row < 4; row++ {
+			var sum float64
+			for k := 0; k < 4; k++ {
+				sum += a[k*4+row] * b[col*4+k]
+			}
+			m[col*4+row] = sum
+		}
+	}
+	return m
+}
@@ -0,0 +1,305 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrNothingToRender is returned for models without triangles in their default scene.
+var ErrNothingToRender = errors.New("model has no renderable geometry")
+
+const (
+	thumbnailSize        = 512
+	thumbnailSupersample = 2 // rendered at 2x and scaled down, the rasterizer itself does not anti-alias
+	thumbnailFOV         = 35 * math.Pi / 180
+
+	// Canonical camera: in front of the model (+Z), turned to its right and looking down a little
+	thumbnailAzimuth   = 35 * math.Pi / 180
+	thumbnailElevation = 25 * math.Pi / 180
+)
+
+// RenderModelThumbnail rasterizes a shaded preview of the glTF/GLB at inputPath on the CPU and
+// writes it into outputDir as <name>-thumbnail.webp with a transparent background. Materials
+// contribute their base color factor only; textures are not sampled.
+func RenderModelThumbnail(ctx context.Context, inputPath string, outputDir string) (WebPRendition, error) {
+	base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	rendition := WebPRendition{
+		Label:  "thumbnail",
+		Width:  thumbnailSize,
+		Height: thumbnailSize,
+		Path:   filepath.Join(outputDir, base+"-thumbnail.webp"),
+	}
+
+	err := Converters().Run(ctx, func(ctx context.Context) error {
+		asset, err := readGLTFAsset(inputPath)
+		if err != nil {
+			return fmt.Errorf("failed to read model: %w", err)
+		}
+		for _, ext := range asset.Root.ExtensionsUsed {
+			if slices.Contains(gltfOpaqueExtensions, ext) {
+				return fmt.Errorf("%w: uses %s", ErrModelNotOptimizable, ext)
+			}
+		}
+
+		triangles, err := asset.worldTriangles(ctx)
+		if err != nil {
+			return err
+		}
+		if len(triangles) == 0 {
+			return ErrNothingToRender
+		}
+
+		size := thumbnailSize * thumbnailSupersample
+		canvas := rasterize(triangles, size)
+		thumbnail := image.NewNRGBA(image.Rect(0, 0, thumbnailSize, thumbnailSize))
+		draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), canvas, canvas.Bounds(), draw.Src, nil)
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return encodeWebPFile(rendition.Path, thumbnail, 85)
+	})
+	if err != nil {
+		_ = os.Remove(rendition.Path)
+		return WebPRendition{}, err
+	}
+	return rendition, nil
+}
+
+// shadedTriangle is a triangle in world space with the base color of its material.
+type shadedTriangle struct {
+	v     [3][3]float64
+	color [3]float64
+}
+
+// worldTriangles collects every triangle of the default scene with node transforms applied.
+// Primitives that cannot be read (sparse or quantized positions, points, lines) are skipped.
+func (a *gltfAsset) worldTriangles(ctx context.Context) ([]shadedTriangle, error) {
+	colors := make([][3]float64, len(a.Root.Materials))
+	for i, raw := range a.Root.Materials {
+		colors[i] = materialBaseColor(raw)
+	}
+
+	var triangles []shadedTriangle
+	var walkErr error
+	a.walkScene(func(mesh int, world [16]float64) {
+		if walkErr != nil {
+			return
+		}
+		if walkErr = ctx.Err(); walkErr != nil {
+			return
+		}
+		for _, primitive := range a.Root.Meshes[mesh].Primitives {
+			mode := 4
+			if primitive.Mode != nil {
+				mode = *primitive.Mode
+			}
+			positionIndex, ok := primitive.Attributes["POSITION"]
+			if !ok || positionIndex < 0 || positionIndex >= len(a.Root.Accessors) || mode < 4 || mode > 6 {
+				continue
+			}
+			positionAccessor := a.Root.Accessors[positionIndex]
+			if positionAccessor.Sparse != nil || positionAccessor.ComponentType != 5126 || positionAccessor.Type != "VEC3" {
+				continue
+			}
+			positions, err := a.readPositions(positionAccessor)
+			if err != nil {
+				walkErr = err
+				return
+			}
+			var indices []uint32
+			if primitive.Indices != nil && *primitive.Indices >= 0 && *primitive.Indices < len(a.Root.Accessors) {
+				if indices, err = a.readIndices(a.Root.Accessors[*primitive.Indices]); err != nil {
+					walkErr = err
+					return
+				}
+			} else {
+				indices = make([]uint32, len(positions))
+				for i := range indices {
+					indices[i] = uint32(i)
+				}
+			}
+
+			baseColor := [3]float64{0.8, 0.8, 0.8}
+			if primitive.Material != nil && *primitive.Material >= 0 && *primitive.Material < len(colors) {
+				baseColor = colors[*primitive.Material]
+			}
+			points := make([][3]float64, len(positions))
+			for i, p := range positions {
+				points[i] = transformPoint(world, [3]float64{float64(p[0]), float64(p[1]), float64(p[2])})
+			}
+			for _, tri := range triangleList(indices, mode) {
+				if int(tri[0]) >= len(points) || int(tri[1]) >= len(points) || int(tri[2]) >= len(points) {
+					continue
+				}
+				triangles = append(triangles, shadedTriangle{v: [3][3]float64{points[tri[0]], points[tri[1]], points[tri[2]]}, color: baseColor})
+			}
+		}
+	})
+	return triangles, walkErr
+}
+
+// triangleList expands TRIANGLES, TRIANGLE_STRIP and TRIANGLE_FAN indices into triangles.
+func triangleList(indices []uint32, mode int) [][3]uint32 {
+	var triangles [][3]uint32
+	switch mode {
+	case 4:
+		for i := 0; i+2 < len(indices); i += 3 {
+			triangles = append(triangles, [3]uint32{indices[i], indices[i+1], indices[i+2]})
+		}
+	case 5:
+		for i := 0; i+2 < len(indices); i++ {
+			triangles = append(triangles, [3]uint32{indices[i], indices[i+1], indices[i+2]})
+		}
+	case 6:
+		for i := 1; i+1 < len(indices); i++ {
+			triangles = append(triangles, [3]uint32{indices[0], indices[i], indices[i+1]})
+		}
+	}
+	return triangles
+}
+
+// materialBaseColor reads pbrMetallicRoughness.baseColorFactor, light grey when unset.
+func materialBaseColor(raw json.RawMessage) [3]float64 {
+	var material struct {
+		PbrMetallicRoughness struct {
+			BaseColorFactor []float64 `json:"baseColorFactor"`
+		} `json:"pbrMetallicRoughness"`
+	}
+	baseColor := [3]float64{0.8, 0.8, 0.8}
+	if err := json.Unmarshal(raw, &material); err == nil && len(material.PbrMetallicRoughness.BaseColorFactor) >= 3 {
+		copy(baseColor[:], material.PbrMetallicRoughness.BaseColorFactor)
+	}
+	return baseColor
+}
+
+// rasterize draws the triangles from the canonical camera, framed to fit their bounding sphere,
+// into a size x size image with a depth buffer and flat Lambert shading.
+func rasterize(triangles []shadedTriangle, size int) *image.NRGBA {
+	// Frame the bounding sphere of the model
+	lo := [3]float64{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64}
+	hi := [3]float64{-math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64}
+	for _, t := range triangles {
+		for _, v := range t.v {
+			for c := 0; c < 3; c++ {
+				lo[c] = min(lo[c], v[c])
+				hi[c] = max(hi[c], v[c])
+			}
+		}
+	}
+	var center [3]float64
+	for c := 0; c < 3; c++ {
+		center[c] = (lo[c] + hi[c]) / 2
+	}
+	radius := max(math.Sqrt(sq(hi[0]-lo[0])+sq(hi[1]-lo[1])+sq(hi[2]-lo[2]))/2, 1e-6)
+	distance := radius / math.Sin(thumbnailFOV/2) * 1.05
+
+	// Camera basis, looking at the center from the canonical direction with +Y up
+	back := [3]float64{
+		math.Cos(thumbnailElevation) * math.Sin(thumbnailAzimuth),
+		math.Sin(thumbnailElevation),
+		math.Cos(thumbnailElevation) * math.Cos(thumbnailAzimuth),
+	}
+	eye := [3]float64{center[0] + back[0]*distance, center[1] + back[1]*distance, center[2] + back[2]*distance}
+	forward := [3]float64{-back[0], -back[1], -back[2]}
+	right := normalize(cross(forward, [3]float64{0, 1, 0}))
+	up := cross(right, forward)
+	// Key light from the upper left of the camera
+	light := normalize([3]float64{back[0] - right[0]*0.5 + up[0]*0.7, back[1] - right[1]*0.5 + up[1]*0.7, back[2] - right[2]*0.5 + up[2]*0.7})
+
+	focal := 1 / math.Tan(thumbnailFOV/2) * float64(size) / 2
+	half := float64(size) / 2
+	project := func(p [3]float64) (x, y, invDepth float64, ok bool) {
+		v := [3]float64{p[0] - eye[0], p[1] - eye[1], p[2] - eye[2]}
+		depth := dot(v, forward)
+		if depth <= 1e-9 {
+			return 0, 0, 0, false
+		}
+		return half + dot(v, right)/depth*focal, half - dot(v, up)/depth*focal, 1 / depth, true
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, size, size))
+	// Stores 1/depth, larger is closer; 0 is the background
+	depthBuffer := make([]float64, size*size)
+	for _, t := range triangles {
+		var sx, sy, sz [3]float64
+		visible := true
+		for k := 0; k < 3; k++ {
+			var ok bool
+			if sx[k], sy[k], sz[k], ok = project(t.v[k]); !ok {
+				visible = false
+			}
+		}
+		if !visible {
+			continue
+		}
+		area := (sx[1]-sx[0])*(sy[2]-sy[0]) - (sx[2]-sx[0])*(sy[1]-sy[0])
+		if math.Abs(area) < 1e-12 {
+			continue
+		}
+
+		// Two-sided flat shading, scanned models often have inconsistent winding
+		normal := normalize(cross(sub3(t.v[1], t.v[0]), sub3(t.v[2], t.v[0])))
+		intensity := 0.3 + 0.7*math.Abs(dot(normal, light))
+		shade := color.NRGBA{
+			R: uint8(math.Min(t.color[0]*intensity, 1) * 255),
+			G: uint8(math.Min(t.color[1]*intensity, 1) * 255),
+			B: uint8(math.Min(t.color[2]*intensity, 1) * 255),
+			A: 255,
+		}
+
+		minX := max(int(math.Floor(min(sx[0], sx[1], sx[2]))), 0)
+		maxX := min(int(math.Ceil(max(sx[0], sx[1], sx[2]))), size-1)
+		minY := max(int(math.Floor(min(sy[0], sy[1], sy[2]))), 0)
+		maxY := min(int(math.Ceil(max(sy[0], sy[1], sy[2]))), size-1)
+		for y := minY; y <= maxY; y++ {
+			py := float64(y) + 0.5
+			for x := minX; x <= maxX; x++ {
+				px := float64(x) + 0.5
+				w0 := ((sx[1]-px)*(sy[2]-py) - (sx[2]-px)*(sy[1]-py)) / area
+				w1 := ((sx[2]-px)*(sy[0]-py) - (sx[0]-px)*(sy[2]-py)) / area
+				w2 := 1 - w0 - w1
+				if w0 < 0 || w1 < 0 || w2 < 0 {
+					continue
+				}
+				// 1/depth interpolates linearly in screen space
+				z := w0*sz[0] + w1*sz[1] + w2*sz[2]
+				if z <= depthBuffer[y*size+x] {
+					continue
+				}
+				depthBuffer[y*size+x] = z
+				canvas.SetNRGBA(x, y, shade)
+			}
+		}
+	}
+	return canvas
+}
+
+func sq(v float64) float64 { return v * v }
+
+func dot(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+func sub3(a, b [3]float64) [3]float64 { return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize(v [3]float64) [3]float64 {
+	length := math.Sqrt(dot(v, v))
+	if length == 0 {
+		return v
+	}
+	return [3]float64{v[0] / length, v[1] / length, v[2] / length}
+}
@@ -1,23 +1,38 @@
 package business
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"main/model"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"main/websocket"
-
-	"golang.org/x/exp/slices"
 )
 
+type jobIDKey struct{}
+
+// WithJobID tags ctx so progress events sent while uploading carry the job ID.
+func WithJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, jobIDKey{}, jobID)
+}
+
+func jobIDFromContext(ctx context.Context) string {
+	jobID, _ := ctx.Value(jobIDKey{}).(string)
+	return jobID
+}
+
 // progressReader reports upload progress via websocket channel
 type progressReader struct {
 	r            io.Reader
@@ -27,6 +42,7 @@ type progressReader struct {
 	lastReportTs time.Time
 	ch           string
 	typ          string // "upload" or "tts"
+	jobID        string
 }
 
 func (pr *progressReader) Read(p []byte) (int, error) {
@@ -40,11 +56,15 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 				pr.lastPercent = percent
 				pr.lastReportTs = now
 				if pr.ch != "" {
-					websocket.GlobalHub.BroadcastProgress(pr.ch, map[string]interface{}{
+					msg := map[string]interface{}{
 						"type":     pr.typ,
 						"status":   "uploading",
 						"progress": percent,
-					})
+					}
+					if pr.jobID != "" {
+						msg["job_id"] = pr.jobID
+					}
+					websocket.GlobalHub.BroadcastProgress(pr.ch, msg)
 				}
 			}
 		}
@@ -59,15 +79,11 @@ type PinataUploadResponse struct {
 	IsDuplicate bool   `json:"isDuplicate"`
 }
 
-// PinataRepository interface for uploaders
-type PinataRepository interface {
-	UploadAssetToPinata(fileBuffer []byte, originalFileName string, progressChannel string) (model.AssetStruct, error)
-	UploadAudioToPinata(fileBuffer []byte, fileName string, progressChannel string) (model.AudioStruct, error)
-}
-
 // ------------------------
 // PinataRepo + Service
 // ------------------------
+
+// PinataRepo is the Pinata storage driver: objects are pinned to IPFS and addressed by CID.
 type PinataRepo struct {
 	PinataService *PinataService
 }
@@ -75,10 +91,34 @@ type PinataRepo struct {
 type PinataService struct {
 	JWT        string
 	GatewayURL string
+	CIDVersion int // 0 (Qm...) or 1 (bafy...), sent as pinataOptions.cidVersion
+	// Gateway timeouts, e.g. for content not yet on IPFS, must not stop uploads: each has its own breaker
+	API     *PinataClient
+	Gateway *PinataClient
 }
 
-func NewPinataService(jwt, gatewayURL string) *PinataService {
-	return &PinataService{JWT: jwt, GatewayURL: gatewayURL}
+func NewPinataService(jwt, gatewayURL string, cidVersion int) *PinataService {
+	return &PinataService{
+		JWT:        jwt,
+		GatewayURL: gatewayURL,
+		CIDVersion: cidVersion,
+		API:        NewPinataClient("pinata_api"),
+		Gateway:    NewPinataClient("pinata_gateway"),
+	}
+}
+
+// GatewayLink returns the gateway URL of a path inside a pinned CID, e.g. a tile of a
+// pinned directory. Falls back to the public Pinata gateway when none is configured.
+func (s *PinataService) GatewayLink(cid string, path string) string {
+	gateway := "https://gateway.pinata.cloud"
+	if s != nil && s.GatewayURL != "" {
+		gateway = strings.TrimSuffix(strings.TrimSuffix(s.GatewayURL, "/"), "/ipfs")
+	}
+	link := gateway + "/ipfs/" + cid
+	if path != "" {
+		link += "/" + strings.TrimPrefix(path, "/")
+	}
+	return link
 }
 
 func NewPinataRepo(PinataService *PinataService) *PinataRepo {
@@ -86,12 +126,27 @@ func NewPinataRepo(PinataService *PinataService) *PinataRepo {
 }
 
 // Allowed file types
-var allowImageType = []string{"webp", "png", "jpg", "jpeg", "ktx2"}
+var allowImageType = []string{"webp", "png", "jpg", "jpeg", "gif", "tif", "tiff", "bmp", "heic", "heif", "svg", "ktx2"}
 var allowVideoType = []string{"mp4", "mov", "avi"}
 var allow3DType = []string{"glb", "gltf"}
 
+// HLS playlists and segments are pinned by the video pipeline, they are never accepted as uploads
+var allowStreamType = []string{"m3u8", "ts"}
+
+const pinFileURL = "https://api.pinata.cloud/pinning/pinFileToIPFS"
+
+// categorizePinnedFile is CategorizeFile plus the files the upload pipeline generates itself.
+func categorizePinnedFile(fileName string) (int, string, error) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	if slices.Contains(allowStreamType, ext) {
+		return 2, "Asset_Video", nil
+	}
+	return CategorizeFile(fileName)
+}
+
 // UploadAssetToPinata streams the file to Pinata and reports progress to frontend.
-func (r *PinataRepo) UploadAssetToPinata(fileBuffer []byte, originalFileName string, progressChannel string) (model.AssetStruct, error) {
+// size is only used for progress reporting; pass 0 when it is unknown.
+func (r *PinataRepo) UploadAssetToPinata(ctx context.Context, file io.Reader, size int64, originalFileName string, progressChannel string) (model.AssetStruct, error) {
 	now := time.Now()
 	var assetInfo model.AssetStruct
 
@@ -99,43 +154,30 @@ func (r *PinataRepo) UploadAssetToPinata(fileBuffer []byte, originalFileName str
 	timestamp := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(now.Format(time.RFC3339Nano), ":", "-"), ".", "-"), "Z", "")
 	extensionFileName := filepath.Ext(originalFileName)
 	basename := strings.TrimSuffix(originalFileName, extensionFileName)
-	ext := strings.TrimPrefix(strings.ToLower(extensionFileName), ".")
-	var folderName string
-
-	if slices.Contains(allowImageType, ext) {
-		assetInfo.CategoryID = 1
-		folderName = "Asset_Image"
-	} else if slices.Contains(allowVideoType, ext) {
-		assetInfo.CategoryID = 2
-		folderName = "Asset_Video"
-	} else if slices.Contains(allow3DType, ext) {
-		assetInfo.CategoryID = 3
-		folderName = "Asset_3D"
-	} else {
-		return model.AssetStruct{}, fmt.Errorf("invalid file type: only png, webp, jpg, jpeg, mp4, mov, avi, glb, gltf are allowed")
+	categoryID, folderName, err := categorizePinnedFile(originalFileName)
+	if err != nil {
+		return model.AssetStruct{}, err
 	}
+	assetInfo.CategoryID = categoryID
 
 	newFileName := fmt.Sprintf("%s_%s%s", basename, timestamp, extensionFileName)
-	apiURL := "https://api.pinata.cloud/pinning/pinFileToIPFS"
-
-	// --- Build streaming multipart form ---
-	pr, pw := io.Pipe()
-	writer := multipart.NewWriter(pw)
-
-	go func() {
-		defer pw.Close()
 
+	// A file that can be rewound is sent again when Pinata fails transiently
+	rewind, replayable := replayableReader(file)
+	// localCID is the CID the file should get, computed from the same bytes while they are streamed
+	respBytes, localCID, err := r.pinMultipart(ctx, replayable, func(writer *multipart.Writer) (string, error) {
+		if err := rewind(); err != nil {
+			return "", fmt.Errorf("rewind file failed: %w", err)
+		}
 		part, err := writer.CreateFormFile("file", newFileName)
 		if err != nil {
-			_ = pw.CloseWithError(fmt.Errorf("create form file failed: %w", err))
-			return
+			return "", fmt.Errorf("create form file failed: %w", err)
 		}
 
-		rdr := bytes.NewReader(fileBuffer)
-		progR := &progressReader{r: rdr, total: int64(len(fileBuffer)), ch: progressChannel, typ: "upload"}
-		if _, err = io.Copy(part, progR); err != nil {
-			_ = pw.CloseWithError(fmt.Errorf("copy file failed: %w", err))
-			return
+		progR := &progressReader{r: file, total: size, ch: progressChannel, typ: "upload", jobID: jobIDFromContext(ctx)}
+		builder := NewCIDBuilder(r.cidVersion())
+		if _, err = io.Copy(io.MultiWriter(part, builder), progR); err != nil {
+			return "", fmt.Errorf("copy file failed: %w", err)
 		}
 
 		meta := map[string]interface{}{
@@ -146,45 +188,11 @@ func (r *PinataRepo) UploadAssetToPinata(fileBuffer []byte, originalFileName str
 		}
 		metaJSON, _ := json.Marshal(meta)
 		_ = writer.WriteField("pinataMetadata", string(metaJSON))
-
-		if err := writer.Close(); err != nil {
-			_ = pw.CloseWithError(fmt.Errorf("writer close failed: %w", err))
-		}
-	}()
-
-	req, err := http.NewRequest("POST", apiURL, pr)
-	if err != nil {
-		return model.AssetStruct{}, fmt.Errorf("failed to create HTTP request: %w", err)
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	// --- Authentication handling ---
-	if r.PinataService != nil && r.PinataService.JWT != "" {
-		req.Header.Set("Authorization", "Bearer "+r.PinataService.JWT)
-	} else {
-		apiKey := strings.TrimSpace(os.Getenv("PINATA_API_KEY"))
-		apiSecret := strings.TrimSpace(os.Getenv("PINATA_API_SECRET"))
-		if apiKey == "" || apiSecret == "" {
-			return model.AssetStruct{}, fmt.Errorf("missing Pinata credentials: please set JWT or API key/secret")
-		}
-		req.Header.Set("pinata_api_key", apiKey)
-		req.Header.Set("pinata_secret_api_key", apiSecret)
-	}
-
-	client := &http.Client{Timeout: 0}
-	resp, err := client.Do(req)
+		_ = writer.WriteField("pinataOptions", r.pinataOptions())
+		return builder.CID(), nil
+	})
 	if err != nil {
-		return model.AssetStruct{}, fmt.Errorf("failed to send request to Pinata: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return model.AssetStruct{}, fmt.Errorf("failed to read Pinata response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return model.AssetStruct{}, fmt.Errorf("Pinata API returned %d - %s", resp.StatusCode, string(respBytes))
+		return model.AssetStruct{}, err
 	}
 
 	var pinataResp PinataUploadResponse
@@ -194,37 +202,41 @@ func (r *PinataRepo) UploadAssetToPinata(fileBuffer []byte, originalFileName str
 
 	assetInfo.Filename = basename
 	assetInfo.IpfsHash = pinataResp.IpfsHash
+	assetInfo.LocalCID = localCID
+	assetInfo.CIDMismatch = cidMismatch(newFileName, assetInfo.IpfsHash, assetInfo.LocalCID)
 
 	if progressChannel != "" {
-		websocket.GlobalHub.BroadcastProgress(progressChannel, map[string]interface{}{
+		msg := map[string]interface{}{
 			"type":     "upload",
 			"status":   "completed",
 			"progress": 100,
-		})
+		}
+		if jobID := jobIDFromContext(ctx); jobID != "" {
+			msg["job_id"] = jobID
+		}
+		websocket.GlobalHub.BroadcastProgress(progressChannel, msg)
 	}
 	return assetInfo, nil
 }
 
 // UploadAudioToPinata — same JWT logic as above
-func (r *PinataRepo) UploadAudioToPinata(audioData []byte, fileName string, progressChannel string) (model.AudioStruct, error) {
-	apiURL := "https://api.pinata.cloud/pinning/pinFileToIPFS"
-	pr, pw := io.Pipe()
-	writer := multipart.NewWriter(pw)
+func (r *PinataRepo) UploadAudioToPinata(ctx context.Context, audio io.Reader, size int64, fileName string, progressChannel string) (model.AudioStruct, error) {
 	var folderName = "Audio"
+	rewind, replayable := replayableReader(audio)
 
-	go func() {
-		defer pw.Close()
+	respBytes, localCID, err := r.pinMultipart(ctx, replayable, func(writer *multipart.Writer) (string, error) {
+		if err := rewind(); err != nil {
+			return "", fmt.Errorf("rewind audio: %w", err)
+		}
 		part, err := writer.CreateFormFile("file", fileName)
 		if err != nil {
-			_ = pw.CloseWithError(fmt.Errorf("create form file: %w", err))
-			return
+			return "", fmt.Errorf("create form file: %w", err)
 		}
 
-		rdr := bytes.NewReader(audioData)
-		progR := &progressReader{r: rdr, total: int64(len(audioData)), ch: progressChannel, typ: "tts"}
-		if _, err := io.Copy(part, progR); err != nil {
-			_ = pw.CloseWithError(fmt.Errorf("copy audio failed: %w", err))
-			return
+		progR := &progressReader{r: audio, total: size, ch: progressChannel, typ: "tts", jobID: jobIDFromContext(ctx)}
+		builder := NewCIDBuilder(r.cidVersion())
+		if _, err := io.Copy(io.MultiWriter(part, builder), progR); err != nil {
+			return "", fmt.Errorf("copy audio failed: %w", err)
 		}
 
 		meta := map[string]interface{}{
@@ -235,60 +247,383 @@ func (r *PinataRepo) UploadAudioToPinata(audioData []byte, fileName string, prog
 		}
 		metaJSON, _ := json.Marshal(meta)
 		_ = writer.WriteField("pinataMetadata", string(metaJSON))
+		_ = writer.WriteField("pinataOptions", r.pinataOptions())
+		return builder.CID(), nil
+	})
+	if err != nil {
+		return model.AudioStruct{}, err
+	}
+
+	var audioResp model.AudioStruct
+	if err := json.Unmarshal(respBytes, &audioResp); err != nil {
+		return model.AudioStruct{}, fmt.Errorf("failed to unmarshal Pinata audio response: %w", err)
+	}
+	audioResp.LocalCID = localCID
+	audioResp.CIDMismatch = cidMismatch(fileName, audioResp.IpfsHash, audioResp.LocalCID)
 
-		if err := writer.Close(); err != nil {
-			_ = pw.CloseWithError(fmt.Errorf("writer close: %w", err))
-			return
+	if progressChannel != "" {
+		msg := map[string]interface{}{
+			"type":     "tts",
+			"status":   "completed",
+			"progress": 100,
+			"cid":      audioResp.IpfsHash,
 		}
-	}()
+		if jobID := jobIDFromContext(ctx); jobID != "" {
+			msg["job_id"] = jobID
+		}
+		websocket.GlobalHub.BroadcastProgress(progressChannel, msg)
+	}
+
+	return audioResp, nil
+}
+
+// UploadDirectoryToPinata pins every file under dir as one directory and returns its CID.
+// Files keep their path relative to dir, so <cid>/a/b.webp resolves to dir/a/b.webp.
+func (r *PinataRepo) UploadDirectoryToPinata(ctx context.Context, dir string, progressChannel string) (model.AssetStruct, error) {
+	root := filepath.Base(dir)
 
-	req, err := http.NewRequest("POST", apiURL, pr)
+	var files []string
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, path)
+		total += info.Size()
+		return nil
+	})
 	if err != nil {
-		return model.AudioStruct{}, fmt.Errorf("failed to create HTTP request: %w", err)
+		return model.AssetStruct{}, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	if len(files) == 0 {
+		return model.AssetStruct{}, fmt.Errorf("directory %s is empty", root)
+	}
+	// Sharded directories cannot be verified, they are pinned all the same
+	localCID, err := ComputeDirectoryCID(dir, r.cidVersion())
+	if err != nil && !errors.Is(err, ErrCIDUnsupported) {
+		return model.AssetStruct{}, fmt.Errorf("failed to compute CID of %s: %w", root, err)
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
+	// Files are read again from disk on every attempt, the CID was computed from them up front
+	respBytes, _, err := r.pinMultipart(ctx, true, func(writer *multipart.Writer) (string, error) {
+		// One reader for all files so progress covers the whole directory
+		progR := &progressReader{total: total, ch: progressChannel, typ: "upload", jobID: jobIDFromContext(ctx)}
+		for _, path := range files {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return "", err
+			}
+			// Pinata builds the directory from the part file names, which must share the root folder
+			part, err := writer.CreateFormFile("file", root+"/"+filepath.ToSlash(rel))
+			if err != nil {
+				return "", fmt.Errorf("create form file failed: %w", err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return "", err
+			}
+			progR.r = file
+			_, err = io.Copy(part, progR)
+			file.Close()
+			if err != nil {
+				return "", fmt.Errorf("copy file failed: %w", err)
+			}
+		}
+
+		meta := map[string]interface{}{
+			"name": root,
+			"keyvalues": map[string]string{
+				"folder": "Asset_Image",
+			},
+		}
+		metaJSON, _ := json.Marshal(meta)
+		_ = writer.WriteField("pinataMetadata", string(metaJSON))
+		_ = writer.WriteField("pinataOptions", r.pinataOptions())
+		return "", nil
+	})
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+
+	var pinataResp PinataUploadResponse
+	if err := json.Unmarshal(respBytes, &pinataResp); err != nil {
+		return model.AssetStruct{}, fmt.Errorf("failed to parse Pinata response JSON: %w", err)
+	}
+	return model.AssetStruct{
+		Filename:    root,
+		IpfsHash:    pinataResp.IpfsHash,
+		CategoryID:  1,
+		LocalCID:    localCID,
+		CIDMismatch: cidMismatch(root, pinataResp.IpfsHash, localCID),
+	}, nil
+}
+
+func (r *PinataRepo) Name() string {
+	return StorageDriverPinata
+}
+
+func (r *PinataRepo) PutAsset(ctx context.Context, file io.Reader, size int64, originalFileName string, progressChannel string) (model.AssetStruct, error) {
+	return r.UploadAssetToPinata(ctx, file, size, originalFileName, progressChannel)
+}
+
+func (r *PinataRepo) PutAudio(ctx context.Context, audio io.Reader, size int64, fileName string, progressChannel string) (model.AudioStruct, error) {
+	return r.UploadAudioToPinata(ctx, audio, size, fileName, progressChannel)
+}
+
+func (r *PinataRepo) PutDirectory(ctx context.Context, dir string, progressChannel string) (model.AssetStruct, error) {
+	return r.UploadDirectoryToPinata(ctx, dir, progressChannel)
+}
+
+// Get fetches a pinned file through the gateway.
+func (r *PinataRepo) Get(ctx context.Context, cid string, path string) (io.ReadCloser, *StorageObject, error) {
+	resp, err := r.fetchFromGateway(ctx, http.MethodGet, cid, path, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, gatewayObjectInfo(resp, cid, path), nil
+}
+
+func (r *PinataRepo) GetRange(ctx context.Context, cid string, path string, offset int64, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := r.fetchFromGateway(ctx, http.MethodGet, cid, path, byteRange)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		// The gateway ignored the range and sent the whole file
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to skip to offset %d of %s: %w", offset, cid, err)
+		}
+	}
+	return limitReadCloser(resp.Body, length), nil
+}
+
+func (r *PinataRepo) Stat(ctx context.Context, cid string, path string) (*StorageObject, error) {
+	resp, err := r.fetchFromGateway(ctx, http.MethodHead, cid, path, "")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return gatewayObjectInfo(resp, cid, path), nil
+}
+
+// Delete unpins cid from the account. The content stays on IPFS as long as anyone else pins it.
+func (r *PinataRepo) Delete(ctx context.Context, cid string) error {
+	resp, err := r.PinataService.API.Do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, "https://api.pinata.cloud/pinning/unpin/"+url.PathEscape(cid), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		if err := r.authorize(req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send request to Pinata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrObjectNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Pinata API returned %d - %s", resp.StatusCode, string(respBytes))
+	}
+	return nil
+}
+
+// Health reports the circuit breakers of the API and gateway clients; an open API breaker means
+// uploads currently fail fast.
+func (r *PinataRepo) Health() StorageHealth {
+	health := StorageHealth{Driver: r.Name(), Healthy: true}
+	for _, client := range []*PinataClient{r.PinataService.API, r.PinataService.Gateway} {
+		state := client.Breaker.State()
+		health.Breakers = append(health.Breakers, state)
+		health.Healthy = health.Healthy && state.State == BreakerClosed
+	}
+	return health
+}
+
+func (r *PinataRepo) URL(cid string, path string) string {
+	return r.PinataService.GatewayLink(cid, path)
+}
+
+// ContentID computes the CID Pinata will give the content, before uploading it.
+func (r *PinataRepo) ContentID(content io.Reader) (string, error) {
+	return ComputeCID(content, r.cidVersion())
+}
+
+func (r *PinataRepo) cidVersion() int {
+	if r.PinataService == nil {
+		return 0
+	}
+	return r.PinataService.CIDVersion
+}
+
+func (r *PinataRepo) pinataOptions() string {
+	options, _ := json.Marshal(map[string]interface{}{"cidVersion": r.cidVersion()})
+	return string(options)
+}
+
+// cidMismatch reports whether Pinata pinned something other than what was sent.
+func cidMismatch(name string, pinnedCID string, localCID string) bool {
+	if localCID == "" || localCID == pinnedCID {
+		return false
+	}
+	fmt.Printf("[WARN] Pinata returned CID %s for %s but its content hashes to %s\n", pinnedCID, name, localCID)
+	return true
+}
+
+// authorize adds the JWT, or the API key/secret from the environment when there is none.
+func (r *PinataRepo) authorize(req *http.Request) error {
 	if r.PinataService != nil && r.PinataService.JWT != "" {
 		req.Header.Set("Authorization", "Bearer "+r.PinataService.JWT)
-	} else {
-		apiKey := strings.TrimSpace(os.Getenv("PINATA_API_KEY"))
-		apiSecret := strings.TrimSpace(os.Getenv("PINATA_API_SECRET"))
-		if apiKey == "" || apiSecret == "" {
-			return model.AudioStruct{}, fmt.Errorf("missing Pinata credentials: please set JWT or API key/secret")
-		}
-		req.Header.Set("pinata_api_key", apiKey)
-		req.Header.Set("pinata_secret_api_key", apiSecret)
+		return nil
 	}
+	apiKey := strings.TrimSpace(os.Getenv("PINATA_API_KEY"))
+	apiSecret := strings.TrimSpace(os.Getenv("PINATA_API_SECRET"))
+	if apiKey == "" || apiSecret == "" {
+		return fmt.Errorf("missing Pinata credentials: please set JWT or API key/secret")
+	}
+	req.Header.Set("pinata_api_key", apiKey)
+	req.Header.Set("pinata_secret_api_key", apiSecret)
+	return nil
+}
+
+// formAttempt is the body of one pinFileToIPFS attempt.
+type formAttempt struct {
+	body     *io.PipeReader
+	written  chan struct{} // closed once writeForm returned, localCID is safe to read after it
+	localCID string
+}
+
+// finish stops the attempt's writer, which is still running when Pinata answered before reading
+// the whole form, and waits for it to return.
+func (a *formAttempt) finish() {
+	a.body.Close()
+	<-a.written
+}
+
+// pinMultipart posts the form writeForm writes to pinFileToIPFS and returns the response body of
+// a successful pin, along with the CID writeForm computed while writing the form of that attempt.
+// writeForm runs once per attempt, never two at a time; with replayable=false there is only one.
+func (r *PinataRepo) pinMultipart(ctx context.Context, replayable bool, writeForm func(writer *multipart.Writer) (string, error)) ([]byte, string, error) {
+	var attempt *formAttempt
 
-	client := &http.Client{Timeout: 0}
-	resp, err := client.Do(req)
+	resp, err := r.PinataService.API.Do(ctx, replayable, func(ctx context.Context) (*http.Request, error) {
+		// The previous attempt's writer reads the same file, it must be done before this one rewinds it
+		if attempt != nil {
+			attempt.finish()
+		}
+		pr, pw := io.Pipe()
+		writer := multipart.NewWriter(pw)
+		current := &formAttempt{body: pr, written: make(chan struct{})}
+		attempt = current
+
+		go func() {
+			defer close(current.written)
+			localCID, err := writeForm(writer)
+			if err == nil {
+				if err = writer.Close(); err != nil {
+					err = fmt.Errorf("writer close failed: %w", err)
+				}
+			}
+			if err == nil {
+				current.localCID = localCID
+			}
+			// A nil error closes the pipe normally
+			_ = pw.CloseWithError(err)
+		}()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, pinFileURL, pr)
+		if err == nil {
+			err = r.authorize(req)
+		}
+		if err != nil {
+			pr.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	})
+	if attempt != nil {
+		attempt.finish()
+	}
 	if err != nil {
-		return model.AudioStruct{}, fmt.Errorf("failed to send request to Pinata: %w", err)
+		return nil, "", fmt.Errorf("failed to send request to Pinata: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return model.AudioStruct{}, fmt.Errorf("failed to read Pinata response: %w", err)
+		return nil, "", fmt.Errorf("failed to read Pinata response: %w", err)
 	}
-
 	if resp.StatusCode != http.StatusOK {
-		return model.AudioStruct{}, fmt.Errorf("Pinata API returned %d - %s", resp.StatusCode, string(respBytes))
+		return nil, "", fmt.Errorf("Pinata API returned %d - %s", resp.StatusCode, string(respBytes))
 	}
+	// The response belongs to the last attempt
+	return respBytes, attempt.localCID, nil
+}
 
-	var audioResp model.AudioStruct
-	if err := json.Unmarshal(respBytes, &audioResp); err != nil {
-		return model.AudioStruct{}, fmt.Errorf("failed to unmarshal Pinata audio response: %w", err)
+// replayableReader returns how to rewind r to where it is now, and whether it can be rewound.
+func replayableReader(r io.Reader) (func() error, bool) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return func() error { return nil }, false
 	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return func() error { return nil }, false
+	}
+	return func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}, true
+}
 
-	if progressChannel != "" {
-		websocket.GlobalHub.BroadcastProgress(progressChannel, map[string]interface{}{
-			"type":     "tts",
-			"status":   "completed",
-			"progress": 100,
-			"cid":      audioResp.IpfsHash,
-		})
+// fetchFromGateway requests cid/path from the gateway and returns the response if it was a 200,
+// or a 206 to a request with a byte range.
+func (r *PinataRepo) fetchFromGateway(ctx context.Context, method string, cid string, path string, byteRange string) (*http.Response, error) {
+	resp, err := r.PinataService.Gateway.Do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, r.URL(cid, path), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from gateway: %w", cid, err)
 	}
+	if resp.StatusCode == http.StatusOK || (byteRange != "" && resp.StatusCode == http.StatusPartialContent) {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	return nil, fmt.Errorf("gateway returned %d for %s", resp.StatusCode, cid)
+}
 
-	return audioResp, nil
+func gatewayObjectInfo(resp *http.Response, cid string, path string) *StorageObject {
+	object := &StorageObject{ID: cid, Path: path, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		object.ModTime = modified
+	}
+	return object
 }
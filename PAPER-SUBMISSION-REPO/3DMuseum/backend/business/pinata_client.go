@@ -0,0 +1,334 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Pinata client defaults, override with the PINATA_* variables read in NewPinataClient
+const (
+	defaultPinataConnectTimeout  = 10 * time.Second
+	defaultPinataResponseTimeout = 2 * time.Minute // after the request body is sent, Pinata hashes the whole upload first
+	defaultPinataIdleTimeout     = time.Minute
+	defaultPinataMaxAttempts     = 4
+	defaultPinataRetryBaseDelay  = 500 * time.Millisecond
+	defaultPinataRetryMaxDelay   = 15 * time.Second
+	defaultPinataBreakerFailures = 5
+	defaultPinataBreakerCooldown = 30 * time.Second
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var (
+	ErrPinataUnavailable = errors.New("Pinata is unavailable")
+	errPinataStalled     = errors.New("connection to Pinata stalled")
+)
+
+// PinataClient sends every request to one Pinata endpoint (the API or the gateway) over a shared
+// connection pool. Transient failures are retried with jittered backoff, and after repeated
+// failures a circuit breaker fails requests fast until Pinata has had time to recover.
+type PinataClient struct {
+	http        *http.Client
+	idleTimeout time.Duration
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+	Breaker     *CircuitBreaker
+}
+
+// pinataTransport is shared by the API and gateway clients.
+var pinataTransport = sync.OnceValue(func() *http.Transport {
+	connectTimeout := time.Duration(envInt("PINATA_CONNECT_TIMEOUT_SECONDS", int(defaultPinataConnectTimeout/time.Second))) * time.Second
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: time.Duration(envInt("PINATA_RESPONSE_TIMEOUT_SECONDS", int(defaultPinataResponseTimeout/time.Second))) * time.Second,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+		ForceAttemptHTTP2:     true,
+	}
+})
+
+// NewPinataClient reads PINATA_CONNECT_TIMEOUT_SECONDS (default 10), PINATA_RESPONSE_TIMEOUT_SECONDS
+// (default 120), PINATA_IDLE_TIMEOUT_SECONDS (default 60, how long a transfer may stall),
+// PINATA_MAX_ATTEMPTS (default 4), PINATA_BREAKER_FAILURES (default 5) and
+// PINATA_BREAKER_COOLDOWN_SECONDS (default 30).
+func NewPinataClient(name string) *PinataClient {
+	return &PinataClient{
+		http:        &http.Client{Transport: pinataTransport()},
+		idleTimeout: time.Duration(envInt("PINATA_IDLE_TIMEOUT_SECONDS", int(defaultPinataIdleTimeout/time.Second))) * time.Second,
+		maxAttempts: envInt("PINATA_MAX_ATTEMPTS", defaultPinataMaxAttempts),
+		retryBase:   defaultPinataRetryBaseDelay,
+		retryMax:    defaultPinataRetryMaxDelay,
+		Breaker: NewCircuitBreaker(name, envInt("PINATA_BREAKER_FAILURES", defaultPinataBreakerFailures),
+			time.Duration(envInt("PINATA_BREAKER_COOLDOWN_SECONDS", int(defaultPinataBreakerCooldown/time.Second)))*time.Second),
+	}
+}
+
+// Do sends the request newRequest builds and returns the response of the last attempt, whatever
+// its status. newRequest is called once per attempt and must build a fresh body each time; pass
+// replayable=false when it cannot, the request is then sent once. The response body must be closed.
+func (c *PinataClient) Do(ctx context.Context, replayable bool, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	attempts := c.maxAttempts
+	if !replayable {
+		attempts = 1
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := c.sleep(ctx, attempt, lastErr); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.Breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.send(ctx, newRequest)
+		switch {
+		case ctx.Err() != nil:
+			// The caller gave up, that says nothing about Pinata
+			c.Breaker.Release()
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		case err != nil:
+			c.Breaker.Failure(err)
+			lastErr = err
+			continue
+		case retryableStatus(resp.StatusCode):
+			lastErr = &retryableStatusError{status: resp.StatusCode, retryAfter: retryAfter(resp)}
+			c.Breaker.Failure(lastErr)
+			if attempt == attempts-1 {
+				return resp, nil
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			continue
+		default:
+			c.Breaker.Success()
+			return resp, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// send runs one attempt. A watchdog cancels it when the request body stops moving, and again
+// when the response body does; waiting for the response headers is bounded by the transport.
+func (c *PinataClient) send(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	watchdog := time.AfterFunc(c.idleTimeout, func() { cancel(errPinataStalled) })
+
+	req, err := newRequest(attemptCtx)
+	if err != nil {
+		watchdog.Stop()
+		cancel(nil)
+		return nil, err
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &idleReader{ReadCloser: req.Body, watchdog: watchdog, timeout: c.idleTimeout, stopAtEOF: true}
+	} else {
+		watchdog.Stop()
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		watchdog.Stop()
+		if cause := context.Cause(attemptCtx); errors.Is(cause, errPinataStalled) {
+			err = fmt.Errorf("%w for %s", errPinataStalled, c.idleTimeout)
+		}
+		cancel(nil)
+		return nil, err
+	}
+	watchdog.Reset(c.idleTimeout)
+	resp.Body = &idleReader{ReadCloser: resp.Body, watchdog: watchdog, timeout: c.idleTimeout, cancel: func() { cancel(nil) }}
+	return resp, nil
+}
+
+// sleep waits out the backoff before the attempt-th retry: full jitter over an exponentially
+// growing window, or as long as a 429 asked for.
+func (c *PinataClient) sleep(ctx context.Context, attempt int, lastErr error) error {
+	window := min(c.retryBase<<(attempt-1), c.retryMax)
+	delay := rand.N(window) + 1
+	var statusErr *retryableStatusError
+	if errors.As(lastErr, &statusErr) && statusErr.retryAfter > 0 {
+		delay = min(statusErr.retryAfter, c.retryMax)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryableStatus is true for rate limiting and server-side failures.
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+type retryableStatusError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e *retryableStatusError) Error() string {
+	return fmt.Sprintf("Pinata returned %d", e.status)
+}
+
+// idleReader pushes the watchdog back whenever data moves.
+type idleReader struct {
+	io.ReadCloser
+	watchdog  *time.Timer
+	timeout   time.Duration
+	stopAtEOF bool   // request bodies: the transport times the wait for the response
+	cancel    func() // response bodies: releases the attempt context on Close
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.watchdog.Reset(r.timeout)
+	}
+	if err == io.EOF && r.stopAtEOF {
+		r.watchdog.Stop()
+	}
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.cancel != nil {
+		r.watchdog.Stop()
+		r.cancel()
+	}
+	return err
+}
+
+// ------------------------
+// Circuit breaker
+// ------------------------
+
+// CircuitBreaker opens after Threshold consecutive failures and fails requests fast for the
+// cooldown. Then one probe request is let through (half open): success closes it, failure opens
+// it for another cooldown.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// BreakerState is a snapshot of a circuit breaker, as shown on the health endpoint.
+type BreakerState struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Failures  int        `json:"consecutive_failures"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{name: name, threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow returns ErrPinataUnavailable while the breaker is open. Every allowed request must be
+// followed by Success, Failure or Release.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		retryAt := b.openedAt.Add(b.cooldown)
+		if time.Now().Before(retryAt) {
+			return fmt.Errorf("%w (%s), retry after %s", ErrPinataUnavailable, b.name, retryAt.Format(time.RFC3339))
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return fmt.Errorf("%w (%s), checking whether it recovered", ErrPinataUnavailable, b.name)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		fmt.Printf("[INFO] %s circuit breaker closed, Pinata recovered\n", b.name)
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *CircuitBreaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	b.probing = false
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		fmt.Printf("[WARN] %s circuit breaker open after %d failures: %v\n", b.name, b.failures, err)
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release ends an allowed request that neither succeeded nor failed, e.g. one the caller cancelled.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := BreakerState{Name: b.name, State: b.state, Failures: b.failures, LastError: b.lastError}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.cooldown)
+		state.OpenedAt = &openedAt
+		state.RetryAt = &retryAt
+	}
+	return state
+}
@@ -0,0 +1,15 @@
+package business
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RandomID returns a 128-bit random hex identifier for uploads and jobs.
+func RandomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
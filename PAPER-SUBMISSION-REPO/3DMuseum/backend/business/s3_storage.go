@@ -0,0 +1,414 @@
+package business
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"main/model"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Parts of a multipart upload, override with S3_PART_SIZE_MB. Files smaller than one part
+// go up in a single PUT. S3 rejects parts under 5 MB.
+const (
+	defaultS3PartSizeMB = 16
+	minS3PartSizeMB     = 5
+)
+
+// S3Config is an S3-compatible bucket (AWS, MinIO, ...) read from the S3_* variables.
+type S3Config struct {
+	Endpoint        string // S3_ENDPOINT, e.g. http://localhost:9000; https unless the scheme says otherwise
+	Region          string // S3_REGION, default us-east-1
+	Bucket          string // S3_BUCKET
+	Prefix          string // S3_PREFIX, prepended to every key
+	AccessKeyID     string // S3_ACCESS_KEY_ID
+	SecretAccessKey string // S3_SECRET_ACCESS_KEY
+	PublicURL       string // S3_PUBLIC_URL, where the bucket is served from; the endpoint when unset
+	PartSize        int64  // S3_PART_SIZE_MB
+}
+
+func S3ConfigFromEnv() S3Config {
+	partSize := envMegabytes("S3_PART_SIZE_MB", defaultS3PartSizeMB)
+	if partSize < minS3PartSizeMB<<20 {
+		fmt.Printf("[WARN] S3_PART_SIZE_MB is below the S3 minimum, using %d MB\n", minS3PartSizeMB)
+		partSize = minS3PartSizeMB << 20
+	}
+	return S3Config{
+		Endpoint:        strings.TrimSpace(os.Getenv("S3_ENDPOINT")),
+		Region:          envString("S3_REGION", "us-east-1"),
+		Bucket:          strings.TrimSpace(os.Getenv("S3_BUCKET")),
+		Prefix:          strings.Trim(strings.TrimSpace(os.Getenv("S3_PREFIX")), "/"),
+		AccessKeyID:     strings.TrimSpace(os.Getenv("S3_ACCESS_KEY_ID")),
+		SecretAccessKey: strings.TrimSpace(os.Getenv("S3_SECRET_ACCESS_KEY")),
+		PublicURL:       strings.TrimSuffix(strings.TrimSpace(os.Getenv("S3_PUBLIC_URL")), "/"),
+		PartSize:        partSize,
+	}
+}
+
+// S3Storage keeps objects in an S3-compatible bucket for deployments that may not put
+// collection media on public IPFS. Like LocalStorage, objects are addressed by the SHA-256
+// of their content: a file is stored at <prefix>/<sha256>, a directory under <prefix>/<id>/.
+type S3Storage struct {
+	Config S3Config
+	client *minio.Client
+}
+
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3 storage needs S3_ENDPOINT and S3_BUCKET")
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		// A bare host:port, as MinIO documents it
+		endpoint = &url.URL{Scheme: "https", Host: config.Endpoint}
+	}
+	config.Endpoint = endpoint.Scheme + "://" + endpoint.Host
+
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, ""),
+		Secure: endpoint.Scheme != "http",
+		Region: config.Region,
+		// MinIO and most self-hosted servers have no virtual-host bucket DNS
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach S3 bucket %s: %w", config.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("S3 bucket %s does not exist", config.Bucket)
+	}
+	return &S3Storage{Config: config, client: client}, nil
+}
+
+func (s *S3Storage) Name() string {
+	return StorageDriverS3
+}
+
+func (s *S3Storage) PutAsset(ctx context.Context, file io.Reader, size int64, originalFileName string, progressChannel string) (model.AssetStruct, error) {
+	categoryID, folderName, err := categorizePinnedFile(originalFileName)
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+	id, err := s.putFile(ctx, file, size, originalFileName, folderName, progressChannel, "upload")
+	if err != nil {
+		return model.AssetStruct{}, err
+	}
+	reportStored(ctx, progressChannel, "upload", "")
+	return model.AssetStruct{
+		Filename:   strings.TrimSuffix(originalFileName, filepath.Ext(originalFileName)),
+		IpfsHash:   id,
+		CategoryID: categoryID,
+	}, nil
+}
+
+func (s *S3Storage) PutAudio(ctx context.Context, audio io.Reader, size int64, fileName string, progressChannel string) (model.AudioStruct, error) {
+	id, err := s.putFile(ctx, audio, size, fileName, "Audio", progressChannel, "tts")
+	if err != nil {
+		return model.AudioStruct{}, err
+	}
+	reportStored(ctx, progressChannel, "tts", id)
+	return model.AudioStruct{IpfsHash: id}, nil
+}
+
+func (s *S3Storage) PutDirectory(ctx context.Context, dir string, progressChannel string) (model.AssetStruct, error) {
+	root := filepath.Base(dir)
+
+	// The ID must be known before the first key is written, so hash everything up front
+	type entry struct {
+		path string
+		rel  string
+		hash string
+		size int64
+	}
+	var files []entry
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		fileHash, size, err := hashContent(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		files = append(files, entry{path: path, rel: filepath.ToSlash(rel), hash: fileHash, size: size})
+		total += size
+		return nil
+	})
+	if err != nil {
+		return model.AssetStruct{}, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	if len(files) == 0 {
+		return model.AssetStruct{}, fmt.Errorf("directory %s is empty", root)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	dirHash := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(dirHash, "%s\x00%s\n", file.rel, file.hash)
+	}
+	id := hex.EncodeToString(dirHash.Sum(nil))
+
+	// One reader for all files so progress covers the whole directory
+	progR := &progressReader{total: total, ch: progressChannel, typ: "upload", jobID: jobIDFromContext(ctx)}
+	for _, file := range files {
+		in, err := os.Open(file.path)
+		if err != nil {
+			return model.AssetStruct{}, err
+		}
+		progR.r = in
+		_, err = s.client.PutObject(ctx, s.Config.Bucket, s.key(id, file.rel), progR, file.size, minio.PutObjectOptions{
+			ContentType:  MimeType(file.rel),
+			UserMetadata: map[string]string{"folder": "Asset_Image"},
+			PartSize:     uint64(s.Config.PartSize),
+		})
+		in.Close()
+		if err != nil {
+			return model.AssetStruct{}, fmt.Errorf("failed to upload %s to S3: %w", file.rel, err)
+		}
+	}
+	reportStored(ctx, progressChannel, "upload", "")
+	return model.AssetStruct{Filename: root, IpfsHash: id, CategoryID: 1}, nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, id string, path string) (io.ReadCloser, *StorageObject, error) {
+	key, err := s.objectKey(id, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	object, err := s.client.GetObject(ctx, s.Config.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+	// GetObject is lazy, Stat is what tells whether the key exists
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, nil, s3Error(err)
+	}
+	return object, s3ObjectInfo(info, id, path), nil
+}
+
+func (s *S3Storage) GetRange(ctx context.Context, id string, path string, offset int64, length int64) (io.ReadCloser, error) {
+	key, err := s.objectKey(id, path)
+	if err != nil {
+		return nil, err
+	}
+	var options minio.GetObjectOptions
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		err = options.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = options.SetRange(offset, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	object, err := s.client.GetObject(ctx, s.Config.Bucket, key, options)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, s3Error(err)
+	}
+	return object, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, id string, path string) (*StorageObject, error) {
+	key, err := s.objectKey(id, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.client.StatObject(ctx, s.Config.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return s3ObjectInfo(info, id, path), nil
+}
+
+// Delete removes a file, or every key of a directory.
+func (s *S3Storage) Delete(ctx context.Context, id string) error {
+	key, err := s.objectKey(id, "")
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.StatObject(ctx, s.Config.Bucket, key, minio.StatObjectOptions{}); err == nil {
+		return s3Error(s.client.RemoveObject(ctx, s.Config.Bucket, key, minio.RemoveObjectOptions{}))
+	} else if !errors.Is(s3Error(err), ErrObjectNotFound) {
+		return s3Error(err)
+	}
+
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var keys []minio.ObjectInfo
+	for object := range s.client.ListObjects(listCtx, s.Config.Bucket, minio.ListObjectsOptions{Prefix: key + "/", Recursive: true}) {
+		if object.Err != nil {
+			return s3Error(object.Err)
+		}
+		keys = append(keys, object)
+	}
+	if len(keys) == 0 {
+		return ErrObjectNotFound
+	}
+
+	objects := make(chan minio.ObjectInfo, len(keys))
+	for _, object := range keys {
+		objects <- object
+	}
+	close(objects)
+	for removeErr := range s.client.RemoveObjects(ctx, s.Config.Bucket, objects, minio.RemoveObjectsOptions{}) {
+		err = errors.Join(err, fmt.Errorf("failed to delete %s: %w", removeErr.ObjectName, removeErr.Err))
+	}
+	return err
+}
+
+// URL is only reachable when the bucket, or S3_PUBLIC_URL in front of it, allows anonymous reads.
+func (s *S3Storage) URL(id string, path string) string {
+	base := s.Config.PublicURL
+	if base == "" {
+		base = s.Config.Endpoint + "/" + s.Config.Bucket
+	}
+	return base + "/" + s.key(id, strings.TrimPrefix(path, "/"))
+}
+
+func (s *S3Storage) ContentID(content io.Reader) (string, error) {
+	id, _, err := hashContent(content)
+	return id, err
+}
+
+// putFile hashes r for its ID and uploads it, in parts once it is larger than PartSize.
+func (s *S3Storage) putFile(ctx context.Context, r io.Reader, size int64, fileName string, folderName string, progressChannel string, typ string) (string, error) {
+	body, cleanup, err := seekableUpload(r)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	id, contentSize, err := hashContent(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", fileName, err)
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	progR := &progressReader{r: body, total: size, ch: progressChannel, typ: typ, jobID: jobIDFromContext(ctx)}
+	_, err = s.client.PutObject(ctx, s.Config.Bucket, s.key(id, ""), progR, contentSize, minio.PutObjectOptions{
+		ContentType: MimeType(fileName),
+		UserMetadata: map[string]string{
+			"folder": folderName,
+			"name":   fileName,
+		},
+		PartSize: uint64(s.Config.PartSize),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s to S3: %w", fileName, err)
+	}
+	return id, nil
+}
+
+func (s *S3Storage) key(id string, objectPath string) string {
+	key := id
+	if s.Config.Prefix != "" {
+		key = s.Config.Prefix + "/" + id
+	}
+	if objectPath != "" {
+		key += "/" + objectPath
+	}
+	return key
+}
+
+// objectKey is key for IDs and paths that come from clients.
+func (s *S3Storage) objectKey(id string, objectPath string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != sha256.Size*2 {
+		return "", ErrObjectNotFound
+	}
+	if objectPath != "" {
+		objectPath = strings.TrimPrefix(path.Clean("/"+objectPath), "/")
+	}
+	return s.key(id, objectPath), nil
+}
+
+// seekableUpload returns r itself when it can be rewound after hashing, otherwise a spooled copy.
+func seekableUpload(r io.Reader) (io.ReadSeeker, func(), error) {
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		return seeker, func() {}, nil
+	}
+	workspace, err := NewWorkspace("s3-spool")
+	if err != nil {
+		return nil, nil, err
+	}
+	spool, err := os.Create(workspace.Path("upload"))
+	if err != nil {
+		workspace.Cleanup()
+		return nil, nil, err
+	}
+	cleanup := func() {
+		spool.Close()
+		workspace.Cleanup()
+	}
+	if _, err := io.Copy(spool, r); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return spool, cleanup, nil
+}
+
+// hashContent returns the hex SHA-256 and size of what r has left.
+func hashContent(r io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+func s3ObjectInfo(info minio.ObjectInfo, id string, path string) *StorageObject {
+	return &StorageObject{ID: id, Path: path, Size: info.Size, ContentType: info.ContentType, ModTime: info.LastModified}
+}
+
+// s3Error maps missing keys to ErrObjectNotFound.
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"main/model"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage drivers, selected with STORAGE_DRIVER
+const (
+	StorageDriverPinata = "pinata"
+	StorageDriverLocal  = "local"
+	StorageDriverS3     = "s3"
+	StorageDriverKubo   = "kubo"
+)
+
+// Local driver defaults, override with LOCAL_STORAGE_DIR and LOCAL_STORAGE_URL
+const (
+	defaultLocalStorageDir = "storage"
+	defaultLocalStorageURL = "http://localhost:3001/storage"
+)
+
+var ErrObjectNotFound = errors.New("object not found in storage")
+
+// StorageObject describes a stored file, or a file inside a stored directory.
+type StorageObject struct {
+	ID          string
+	Path        string // inside the directory ID, empty for a single file
+	Size        int64  // -1 when the driver cannot tell
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage is where uploads, renditions and audio end up. IDs are whatever the driver
+// addresses content by (a CID for Pinata and Kubo, a SHA-256 for the local disk and S3) and are stored
+// as the asset and audio CIDs.
+type Storage interface {
+	// Name is the STORAGE_DRIVER value of the driver.
+	Name() string
+	// PutAsset stores a file and reports progress to progressChannel; size is only
+	// used for progress reporting, pass 0 when it is unknown.
+	PutAsset(ctx context.Context, file io.Reader, size int64, originalFileName string, progressChannel string) (model.AssetStruct, error)
+	PutAudio(ctx context.Context, audio io.Reader, size int64, fileName string, progressChannel string) (model.AudioStruct, error)
+	// PutDirectory stores every file under dir as one object, so URL(id, "a/b.webp") resolves to dir/a/b.webp.
+	PutDirectory(ctx context.Context, dir string, progressChannel string) (model.AssetStruct, error)
+	// Get opens a stored file, path selects a file inside a stored directory.
+	Get(ctx context.Context, id string, path string) (io.ReadCloser, *StorageObject, error)
+	// GetRange opens length bytes of a stored file starting at offset, the rest of it when length < 0.
+	GetRange(ctx context.Context, id string, path string, offset int64, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, id string, path string) (*StorageObject, error)
+	Delete(ctx context.Context, id string) error
+	// URL is where clients can fetch the object from.
+	URL(id string, path string) string
+	// ContentID is the ID content would be stored under, computed without uploading it.
+	ContentID(content io.Reader) (string, error)
+}
+
+// StorageHealth tells whether a driver can currently reach its backend.
+type StorageHealth struct {
+	Driver   string         `json:"driver"`
+	Healthy  bool           `json:"healthy"`
+	Breakers []BreakerState `json:"circuit_breakers,omitempty"`
+}
+
+// HealthReporter is implemented by drivers that track the health of their backend.
+type HealthReporter interface {
+	Health() StorageHealth
+}
+
+// NewStorageFromEnv returns the driver named by STORAGE_DRIVER, Pinata when unset.
+func NewStorageFromEnv(pinataService *PinataService) (Storage, error) {
+	switch driver := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_DRIVER"))); driver {
+	case "", StorageDriverPinata:
+		return NewPinataRepo(pinataService), nil
+	case StorageDriverLocal:
+		return NewLocalStorage(envString("LOCAL_STORAGE_DIR", defaultLocalStorageDir), envString("LOCAL_STORAGE_URL", defaultLocalStorageURL))
+	case StorageDriverS3:
+		return NewS3Storage(S3ConfigFromEnv())
+	case StorageDriverKubo:
+		cidVersion := defaultKuboCIDVersion
+		if v := strings.TrimSpace(os.Getenv("KUBO_CID_VERSION")); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid KUBO_CID_VERSION %q", v)
+			}
+			cidVersion = n
+		}
+		return NewKuboStorage(envString("KUBO_API_URL", defaultKuboAPIURL), envString("KUBO_GATEWAY_URL", defaultKuboGatewayURL), cidVersion, os.Getenv("KUBO_API_AUTH"))
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+func envString(key string, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// MimeType is the content type stored objects are served with, by file name.
+func MimeType(name string) string {
+	if contentType := contentTypeByName(name); contentType != "" {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// limitReadCloser stops reading rc after length bytes, unless length < 0.
+func limitReadCloser(rc io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return rc
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, length), rc}
+}
@@ -0,0 +1,281 @@
+package business
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// Same parameters as `ipfs add` with default settings, which is what Pinata pins with:
+// 256 KiB chunks in a balanced DAG of at most 174 links per node. CIDv0 wraps every chunk
+// in a dag-pb UnixFS node, CIDv1 stores chunks as raw leaves.
+const (
+	unixfsChunkSize = 256 << 10
+	unixfsMaxLinks  = 174
+
+	// Directories whose links add up to more than this are HAMT-sharded by Kubo
+	unixfsShardThreshold = 256 << 10
+)
+
+// Multicodec and multihash codes
+const (
+	codecDagPB   = 0x70
+	codecRaw     = 0x55
+	hashSHA2_256 = 0x12
+)
+
+// UnixFS node types
+const (
+	unixfsDirectory = 1
+	unixfsFile      = 2
+)
+
+var ErrCIDUnsupported = errors.New("CID of this content cannot be computed locally")
+
+// dagNode is a block of the DAG as seen by its parent link.
+type dagNode struct {
+	cid      []byte // binary CID
+	filesize uint64 // UnixFS payload below the node
+	tsize    uint64 // encoded size of the node and everything below it
+}
+
+// CIDBuilder computes the UnixFS CID of a file as it is written, without holding more than one
+// chunk in memory, so it can sit next to an upload stream.
+type CIDBuilder struct {
+	version int
+	chunk   []byte
+	leaves  []dagNode
+}
+
+func NewCIDBuilder(version int) *CIDBuilder {
+	return &CIDBuilder{version: version, chunk: make([]byte, 0, unixfsChunkSize)}
+}
+
+func (b *CIDBuilder) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(unixfsChunkSize-len(b.chunk), len(p))
+		b.chunk = append(b.chunk, p[:n]...)
+		p = p[n:]
+		if len(b.chunk) == unixfsChunkSize {
+			b.flush()
+		}
+	}
+	return written, nil
+}
+
+// CID finishes the DAG and returns its root CID as Pinata and Kubo print it.
+func (b *CIDBuilder) CID() string {
+	return formatCID(b.root().cid)
+}
+
+// root links the leaves level by level, filling every node before starting the next one.
+// That is the tree the balanced layout grows for the same number of leaves.
+func (b *CIDBuilder) root() dagNode {
+	if len(b.chunk) > 0 || len(b.leaves) == 0 {
+		b.flush()
+	}
+	level := b.leaves
+	for len(level) > 1 {
+		var parents []dagNode
+		for start := 0; start < len(level); start += unixfsMaxLinks {
+			parents = append(parents, b.fileNode(level[start:min(start+unixfsMaxLinks, len(level))]))
+		}
+		level = parents
+	}
+	return level[0]
+}
+
+func (b *CIDBuilder) flush() {
+	chunk := b.chunk
+	if b.version == 1 {
+		b.leaves = append(b.leaves, dagNode{cid: makeCID(1, codecRaw, chunk), filesize: uint64(len(chunk)), tsize: uint64(len(chunk))})
+	} else {
+		block := encodeDagPB(nil, encodeUnixFS(unixfsFile, chunk, uint64(len(chunk)), nil))
+		b.leaves = append(b.leaves, dagNode{cid: makeCID(0, codecDagPB, block), filesize: uint64(len(chunk)), tsize: uint64(len(block))})
+	}
+	b.chunk = b.chunk[:0]
+}
+
+// fileNode links children into an intermediate UnixFS file node.
+func (b *CIDBuilder) fileNode(children []dagNode) dagNode {
+	var filesize, tsize uint64
+	blocksizes := make([]uint64, len(children))
+	links := make([]dagLink, len(children))
+	for i, child := range children {
+		filesize += child.filesize
+		tsize += child.tsize
+		blocksizes[i] = child.filesize
+		links[i] = dagLink{cid: child.cid, tsize: child.tsize}
+	}
+	block := encodeDagPB(links, encodeUnixFS(unixfsFile, nil, filesize, blocksizes))
+	return dagNode{cid: makeCID(b.version, codecDagPB, block), filesize: filesize, tsize: tsize + uint64(len(block))}
+}
+
+// ComputeCID returns the CID r would get when pinned with the given CID version.
+func ComputeCID(r io.Reader, version int) (string, error) {
+	builder := NewCIDBuilder(version)
+	if _, err := io.Copy(builder, r); err != nil {
+		return "", err
+	}
+	return builder.CID(), nil
+}
+
+// ComputeDirectoryCID returns the CID of dir pinned as a directory, as UploadDirectoryToPinata does.
+// Directories big enough to be sharded are not supported and return ErrCIDUnsupported.
+func ComputeDirectoryCID(dir string, version int) (string, error) {
+	node, err := directoryNode(dir, version)
+	if err != nil {
+		return "", err
+	}
+	return formatCID(node.cid), nil
+}
+
+func directoryNode(dir string, version int) (dagNode, error) {
+	// os.ReadDir sorts by name, the order UnixFS directories link in
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return dagNode{}, err
+	}
+
+	var links []dagLink
+	var tsize, estimate uint64
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		var child dagNode
+		if entry.IsDir() {
+			child, err = directoryNode(path, version)
+		} else {
+			child, err = fileDAGNode(path, version)
+		}
+		if err != nil {
+			return dagNode{}, err
+		}
+		links = append(links, dagLink{cid: child.cid, name: entry.Name(), tsize: child.tsize})
+		tsize += child.tsize
+		estimate += uint64(len(entry.Name()) + len(child.cid))
+	}
+	if estimate > unixfsShardThreshold {
+		return dagNode{}, fmt.Errorf("%w: %s is a sharded directory", ErrCIDUnsupported, filepath.Base(dir))
+	}
+
+	block := encodeDagPB(links, encodeUnixFS(unixfsDirectory, nil, 0, nil))
+	return dagNode{cid: makeCID(version, codecDagPB, block), tsize: tsize + uint64(len(block))}, nil
+}
+
+func fileDAGNode(path string, version int) (dagNode, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return dagNode{}, err
+	}
+	defer file.Close()
+
+	builder := NewCIDBuilder(version)
+	if _, err := io.Copy(builder, file); err != nil {
+		return dagNode{}, err
+	}
+	return builder.root(), nil
+}
+
+// ------------------------
+// Encoding
+// ------------------------
+
+type dagLink struct {
+	cid   []byte
+	name  string
+	tsize uint64
+}
+
+// encodeDagPB encodes a PBNode: links first, then data, as the dag-pb spec orders them.
+// Links always carry their name, Kubo writes an empty one for file chunks.
+func encodeDagPB(links []dagLink, data []byte) []byte {
+	var node bytes.Buffer
+	for _, link := range links {
+		var l bytes.Buffer
+		appendBytesField(&l, 1, link.cid)
+		appendBytesField(&l, 2, []byte(link.name))
+		appendVarintField(&l, 3, link.tsize)
+		appendBytesField(&node, 2, l.Bytes())
+	}
+	appendBytesField(&node, 1, data)
+	return node.Bytes()
+}
+
+// encodeUnixFS encodes the UnixFS Data message carried in a dag-pb node.
+func encodeUnixFS(nodeType uint64, data []byte, filesize uint64, blocksizes []uint64) []byte {
+	var msg bytes.Buffer
+	appendVarintField(&msg, 1, nodeType)
+	if len(data) > 0 {
+		appendBytesField(&msg, 2, data)
+	}
+	if nodeType == unixfsFile {
+		appendVarintField(&msg, 3, filesize)
+	}
+	for _, size := range blocksizes {
+		appendVarintField(&msg, 4, size)
+	}
+	return msg.Bytes()
+}
+
+func appendVarintField(buf *bytes.Buffer, field int, value uint64) {
+	buf.Write(binary.AppendUvarint(nil, uint64(field)<<3))
+	buf.Write(binary.AppendUvarint(nil, value))
+}
+
+func appendBytesField(buf *bytes.Buffer, field int, value []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(field)<<3|2))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	buf.Write(value)
+}
+
+// makeCID hashes block and returns the binary CID; CIDv0 is the bare SHA-256 multihash.
+func makeCID(version int, codec uint64, block []byte) []byte {
+	digest := sha256.Sum256(block)
+	multihash := append([]byte{hashSHA2_256, sha256.Size}, digest[:]...)
+	if version == 0 {
+		return multihash
+	}
+	cid := binary.AppendUvarint([]byte{1}, codec)
+	return append(cid, multihash...)
+}
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// formatCID prints CIDv0 in base58btc (Qm...) and CIDv1 in multibase base32 (bafy..., bafk...).
+func formatCID(cid []byte) string {
+	if cid[0] == hashSHA2_256 {
+		return base58Encode(cid)
+	}
+	return "b" + base32Lower.EncodeToString(cid)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -0,0 +1,110 @@
+package business
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testData returns n bytes that do not repeat within a chunk, so every chunk gets its own CID.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*31 + i/251)
+	}
+	return data
+}
+
+// Expected CIDs are what `ipfs add` (`--cid-version=1` for v1) prints for the same content.
+func TestComputeCID(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		v0, v1  string
+	}{
+		{"empty", nil,
+			"QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"},
+		{"small", []byte("hello world\n"),
+			"QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"},
+		{"one chunk", testData(unixfsChunkSize),
+			"QmTz9WKK5UQ9PUfNGBZn9gZ9FTpGjbFMMFDQyGv3A62kyj", "bafkreih6s5milhbufc3r6uucif6xq25t4kpqqmpxegnm5zcxjexsetsam4"},
+		{"two chunks", testData(unixfsChunkSize + 1),
+			"QmcN5qRDtjEBHgLSn8FpRT9WFG58kf6sRFhj3QdrUX95mL", "bafybeievsntv4eesy5d3sxvnv7py27tnj6pw7toyyvy6tamrqhmfs4wiwu"},
+		{"174 chunks, one full node", testData(unixfsMaxLinks * unixfsChunkSize),
+			"QmYPWLQH3YVRnk4dtACuZ8rozRAs9DYTakvNr9PB2zRXjK", "bafybeickwrrf4tsttqet2bdlbz7lg7gqa7mfdlm7xfdghnl72wxqsihfam"},
+		{"175 chunks, two levels", testData((unixfsMaxLinks+1)*unixfsChunkSize - 100),
+			"QmRGuF1dKafhVQHr779U6L5F8GAVadtoNzTzzyPAs69jkP", "bafybeieeqrbplkcff4a4f6ak7c7d66b7lyhtocj327s5rhjkbrtt3biegy"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for version, want := range []string{test.v0, test.v1} {
+				got, err := ComputeCID(bytes.NewReader(test.content), version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("CIDv%d = %s, want %s", version, got, want)
+				}
+			}
+		})
+	}
+}
+
+// Writes of any size must give the CID of the whole content.
+func TestCIDBuilderSplitWrites(t *testing.T) {
+	content := testData(unixfsChunkSize + 1)
+	builder := NewCIDBuilder(0)
+	for start := 0; start < len(content); start += 1000 {
+		builder.Write(content[start:min(start+1000, len(content))])
+	}
+	if got, want := builder.CID(), "QmcN5qRDtjEBHgLSn8FpRT9WFG58kf6sRFhj3QdrUX95mL"; got != want {
+		t.Errorf("CID = %s, want %s", got, want)
+	}
+}
+
+func TestComputeDirectoryCID(t *testing.T) {
+	empty := t.TempDir()
+
+	tree := t.TempDir()
+	for name, content := range map[string][]byte{
+		"a.txt":     []byte("hello world\n"),
+		"b.bin":     testData(unixfsChunkSize + 1),
+		"sub/c.txt": []byte("nested\n"),
+	} {
+		path := filepath.Join(tree, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tree, "sub", "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		dir    string
+		v0, v1 string
+	}{
+		{"empty", empty,
+			"QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354"},
+		{"nested", tree,
+			"QmT7ugNmACrK13UpPnwWEMK3fy8LySkC2b4k5A2KFBtnWP", "bafybeidenh7uoedy2ydymuqzolb2hmly53zvfzt6zymcixyqgclwswk2he"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for version, want := range []string{test.v0, test.v1} {
+				got, err := ComputeDirectoryCID(test.dir, version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("CIDv%d = %s, want %s", version, got, want)
+				}
+			}
+		})
+	}
+}
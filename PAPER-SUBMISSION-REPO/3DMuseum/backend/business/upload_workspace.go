@@ -0,0 +1,153 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var (
+	ErrUploadTooLarge      = errors.New("uploaded file exceeds the size limit for its category")
+	ErrUnsupportedFileType = errors.New("invalid file type: only " + strings.Join(allowedUploadTypes(), ", ") + " are allowed")
+)
+
+// allowedUploadTypes lists the extensions CategorizeFile accepts.
+func allowedUploadTypes() []string {
+	var types []string
+	for _, allowed := range [][]string{allowImageType, allowVideoType, allow3DType} {
+		types = append(types, allowed...)
+	}
+	return types
+}
+
+// Default per-category upload limits in MB, override with MAX_IMAGE_UPLOAD_MB,
+// MAX_VIDEO_UPLOAD_MB and MAX_MODEL_UPLOAD_MB.
+const (
+	defaultImageUploadMB = 100
+	defaultVideoUploadMB = 2048
+	defaultModelUploadMB = 1024
+
+	// Bulk import archives and the files extracted from one, override with MAX_IMPORT_ARCHIVE_MB
+	// and MAX_IMPORT_EXTRACTED_MB
+	defaultImportArchiveMB   = 4096
+	defaultImportExtractedMB = 4096
+)
+
+// CategorizeFile maps a file name to its category ID and Pinata folder by extension.
+func CategorizeFile(fileName string) (int, string, error) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+
+	if slices.Contains(allowImageType, ext) {
+		return 1, "Asset_Image", nil
+	} else if slices.Contains(allowVideoType, ext) {
+		return 2, "Asset_Video", nil
+	} else if slices.Contains(allow3DType, ext) {
+		return 3, "Asset_3D", nil
+	}
+	return 0, "", ErrUnsupportedFileType
+}
+
+// UploadSizeLimit returns the maximum accepted size in bytes for a category.
+func UploadSizeLimit(categoryID int) int64 {
+	switch categoryID {
+	case 1:
+		return envMegabytes("MAX_IMAGE_UPLOAD_MB", defaultImageUploadMB)
+	case 2:
+		return envMegabytes("MAX_VIDEO_UPLOAD_MB", defaultVideoUploadMB)
+	case 3:
+		return envMegabytes("MAX_MODEL_UPLOAD_MB", defaultModelUploadMB)
+	}
+	return 0
+}
+
+// MaxUploadSize is the largest limit across all categories, used to cap the whole request body.
+func MaxUploadSize() int64 {
+	limit := UploadSizeLimit(1)
+	for _, categoryID := range []int{2, 3} {
+		if l := UploadSizeLimit(categoryID); l > limit {
+			limit = l
+		}
+	}
+	return limit
+}
+
+// ImportArchiveSizeLimit returns the maximum accepted size in bytes for a bulk import zip.
+func ImportArchiveSizeLimit() int64 {
+	return envMegabytes("MAX_IMPORT_ARCHIVE_MB", defaultImportArchiveMB)
+}
+
+// ImportExtractedSizeLimit returns how many bytes the files of one bulk import may take on disk.
+func ImportExtractedSizeLimit() int64 {
+	return envMegabytes("MAX_IMPORT_EXTRACTED_MB", defaultImportExtractedMB)
+}
+
+func envMegabytes(key string, fallback int64) int64 {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if mb, err := strconv.ParseInt(v, 10, 64); err == nil && mb > 0 {
+			return mb << 20
+		}
+		fmt.Printf("[WARN] invalid %s=%q, using default %d MB\n", key, v, fallback)
+	}
+	return fallback << 20
+}
+
+// Workspace is a private scratch directory for a single upload request.
+// Everything produced while handling the upload lives here and is removed by Cleanup.
+type Workspace struct {
+	Dir string
+}
+
+func NewWorkspace(prefix string) (*Workspace, error) {
+	root := os.Getenv("UPLOAD_WORKSPACE_DIR")
+	if root == "" {
+		root = os.TempDir()
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace root: %w", err)
+	}
+	dir, err := os.MkdirTemp(root, prefix+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return &Workspace{Dir: dir}, nil
+}
+
+// Path returns a path inside the workspace for the given file name.
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.Dir, filepath.Base(name))
+}
+
+func (w *Workspace) Cleanup() {
+	if err := os.RemoveAll(w.Dir); err != nil {
+		fmt.Printf("[WARN] failed to remove workspace %s: %v\n", w.Dir, err)
+	}
+}
+
+// SpoolFile copies src into dst without holding it in memory.
+// It stops and removes dst as soon as more than limit bytes have been read.
+func SpoolFile(src io.Reader, dst string, limit int64) (int64, error) {
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	written, err := io.Copy(out, io.LimitReader(src, limit+1))
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && written > limit {
+		err = ErrUploadTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return 0, err
+	}
+	return written, nil
+}
@@ -0,0 +1,393 @@
+package business
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoVideoStream is returned for containers without a (non cover-art) video stream.
+var ErrNoVideoStream = errors.New("file has no video stream")
+
+// VideoProbe is what ffprobe reports about an upload. Width and Height are as displayed,
+// with the rotation of phone recordings already applied.
+type VideoProbe struct {
+	Duration float64 // seconds
+	Width    int
+	Height   int
+	HasAudio bool
+}
+
+// HLSVariant is one rung of the HLS ladder: its media playlist and the segments it lists.
+type HLSVariant struct {
+	Name      string
+	Width     int
+	Height    int
+	Bandwidth int // peak bits per second, as advertised in the master playlist
+	Playlist  string
+	Segments  []string
+
+	videoKbps int
+}
+
+// HLSOutput is a transcoded video on disk. Playlists reference their segments and variants by
+// relative path, see RewritePlaylist to point them somewhere else.
+type HLSOutput struct {
+	Probe      VideoProbe
+	Master     string
+	Variants   []HLSVariant
+	PosterJPEG string
+	PosterWebP string
+}
+
+// hlsLadder lists the H.264 renditions, smallest first. Height is the short edge, so portrait
+// videos get the same ladder; rungs larger than the source are skipped.
+var hlsLadder = []struct {
+	Name      string
+	Height    int
+	VideoKbps int
+}{
+	{"360p", 360, 800},
+	{"480p", 480, 1400},
+	{"720p", 720, 2800},
+	{"1080p", 1080, 5000},
+}
+
+const (
+	hlsAudioKbps      = 128
+	hlsSegmentSeconds = 6
+	posterMaxWidth    = 1920
+
+	defaultVideoTimeout = 30 * time.Minute
+)
+
+// ProbeVideo reads duration, display size and audio presence of a video with ffprobe.
+func ProbeVideo(ctx context.Context, inputPath string) (VideoProbe, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", inputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return VideoProbe{}, fmt.Errorf("ffprobe failed: %v\n%s", err, stderr.String())
+	}
+
+	var report struct {
+		Streams []struct {
+			CodecType   string `json:"codec_type"`
+			Width       int    `json:"width"`
+			Height      int    `json:"height"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+			Tags struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideDataList []struct {
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return VideoProbe{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var probe VideoProbe
+	probe.Duration, _ = strconv.ParseFloat(report.Format.Duration, 64)
+	for _, stream := range report.Streams {
+		switch {
+		case stream.CodecType == "audio":
+			probe.HasAudio = true
+		case stream.CodecType == "video" && stream.Disposition.AttachedPic == 0 && probe.Width == 0:
+			probe.Width, probe.Height = stream.Width, stream.Height
+			// Older ffmpeg reports rotation as a tag, newer ones as display matrix side data
+			rotation, _ := strconv.ParseFloat(stream.Tags.Rotate, 64)
+			for _, side := range stream.SideDataList {
+				if side.Rotation != 0 {
+					rotation = side.Rotation
+				}
+			}
+			if int(math.Abs(rotation))%180 == 90 {
+				probe.Width, probe.Height = probe.Height, probe.Width
+			}
+		}
+	}
+	if probe.Width <= 0 || probe.Height <= 0 {
+		return VideoProbe{}, ErrNoVideoStream
+	}
+	return probe, nil
+}
+
+// TranscodeToHLS encodes inputPath into an H.264/AAC HLS ladder plus a poster frame, all under
+// outputDir/hls. onProgress, when set, receives the encoding progress in percent. The transcode
+// is limited by VIDEO_TRANSCODE_TIMEOUT_SECONDS (default 1800) instead of the converter timeout.
+func TranscodeToHLS(ctx context.Context, inputPath string, outputDir string, onProgress func(percent int)) (*HLSOutput, error) {
+	probe, err := ProbeVideo(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(outputDir, "hls")
+	output := &HLSOutput{
+		Probe:      probe,
+		Master:     filepath.Join(dir, "master.m3u8"),
+		Variants:   hlsVariants(probe, dir),
+		PosterJPEG: filepath.Join(dir, "poster.jpg"),
+		PosterWebP: filepath.Join(dir, "poster.webp"),
+	}
+	for _, variant := range output.Variants {
+		if err := os.MkdirAll(filepath.Dir(variant.Playlist), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := time.Duration(envInt("VIDEO_TRANSCODE_TIMEOUT_SECONDS", int(defaultVideoTimeout/time.Second))) * time.Second
+	err = Converters().RunWithTimeout(ctx, timeout, func(ctx context.Context) error {
+		if err := runFFmpeg(ctx, hlsArgs(inputPath, dir, output), probe.Duration, onProgress); err != nil {
+			return err
+		}
+		if err := extractPoster(ctx, inputPath, output); err != nil {
+			return fmt.Errorf("poster: %w", err)
+		}
+		return nil
+	})
+	if err == nil {
+		err = output.writeMaster()
+	}
+	for i := range output.Variants {
+		if err != nil {
+			break
+		}
+		output.Variants[i].Segments, err = playlistFiles(output.Variants[i].Playlist)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return output, nil
+}
+
+// hlsVariants picks the ladder rungs that fit the source. Sources smaller than the lowest
+// rung get a single rung at their own size.
+func hlsVariants(probe VideoProbe, dir string) []HLSVariant {
+	short := min(probe.Width, probe.Height)
+	size := func(edge int) (int, int) {
+		// Even dimensions, as yuv420p requires
+		long := int(math.Round(float64(edge)*float64(max(probe.Width, probe.Height))/float64(short)/2)) * 2
+		if probe.Width >= probe.Height {
+			return long, edge
+		}
+		return edge, long
+	}
+
+	variant := func(name string, edge int, videoKbps int) HLSVariant {
+		width, height := size(edge)
+		// Peak rate: the video maxrate (7% over its average) plus audio
+		bandwidth := videoKbps * 107 / 100
+		if probe.HasAudio {
+			bandwidth += hlsAudioKbps
+		}
+		return HLSVariant{
+			Name: name, Width: width, Height: height, Bandwidth: bandwidth * 1000,
+			Playlist: filepath.Join(dir, name, "index.m3u8"), videoKbps: videoKbps,
+		}
+	}
+
+	var variants []HLSVariant
+	for _, rung := range hlsLadder {
+		if rung.Height > short {
+			break
+		}
+		variants = append(variants, variant(rung.Name, rung.Height, rung.VideoKbps))
+	}
+	if len(variants) == 0 {
+		edge := max(short&^1, 2)
+		variants = append(variants, variant(strconv.Itoa(edge)+"p", edge, hlsLadder[0].VideoKbps))
+	}
+	return variants
+}
+
+// hlsArgs builds a single ffmpeg run that decodes the source once and encodes every variant.
+func hlsArgs(inputPath string, dir string, output *HLSOutput) []string {
+	args := []string{"-hide_banner", "-nostdin", "-y", "-loglevel", "error", "-nostats", "-progress", "pipe:1", "-i", inputPath}
+
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(output.Variants))
+	for i := range output.Variants {
+		fmt.Fprintf(&filter, "[s%d]", i)
+	}
+	for i, variant := range output.Variants {
+		fmt.Fprintf(&filter, ";[s%d]scale=%d:%d,setsar=1[v%d]", i, variant.Width, variant.Height, i)
+	}
+	args = append(args, "-filter_complex", filter.String())
+
+	var streamMap []string
+	for i, variant := range output.Variants {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i),
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", variant.videoKbps),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", variant.videoKbps*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", variant.videoKbps*3/2))
+		entry := fmt.Sprintf("v:%d", i)
+		if output.Probe.HasAudio {
+			args = append(args, "-map", "0:a:0")
+			entry += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, entry+",name:"+variant.Name)
+	}
+
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-pix_fmt", "yuv420p",
+		// Keyframes on segment boundaries so every variant can be switched at every segment
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds), "-sc_threshold", "0",
+	)
+	if output.Probe.HasAudio {
+		args = append(args, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", hlsAudioKbps), "-ac", "2")
+	}
+	return append(args,
+		"-f", "hls", "-hls_time", strconv.Itoa(hlsSegmentSeconds), "-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(dir, "%v", "segment_%03d.ts"),
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(dir, "%v", "index.m3u8"),
+	)
+}
+
+// runFFmpeg runs ffmpeg with -progress pipe:1 and turns the reported timestamps into percent.
+func runFFmpeg(ctx context.Context, args []string, duration float64, onProgress func(percent int)) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.WaitDelay = 5 * time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg failed to start: %w", err)
+	}
+
+	lastPercent := -1
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		// out_time_ms is in microseconds as well, it is what older ffmpeg versions print
+		if (key != "out_time_us" && key != "out_time_ms") || onProgress == nil || duration <= 0 {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		percent := min(int(float64(us)/1e6/duration*100), 99)
+		if percent > lastPercent {
+			lastPercent = percent
+			onProgress(percent)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v\n%s", err, stderr.String())
+	}
+	if onProgress != nil {
+		onProgress(100)
+	}
+	return nil
+}
+
+// extractPoster grabs a frame a tenth into the video (at most 10s in) as JPEG and WebP.
+func extractPoster(ctx context.Context, inputPath string, output *HLSOutput) error {
+	at := min(output.Probe.Duration/10, 10)
+	scale := fmt.Sprintf("scale='min(%d,iw)':-2", posterMaxWidth)
+	for _, seek := range []float64{at, 0} {
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-nostdin", "-y", "-loglevel", "error",
+			"-ss", strconv.FormatFloat(seek, 'f', 3, 64), "-i", inputPath, "-frames:v", "1", "-vf", scale, "-q:v", "3", output.PosterJPEG)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ffmpeg failed: %v\n%s", err, stderr.String())
+		}
+		// Seeking past the last keyframe of a very short clip yields no frame, try the first one
+		if info, err := os.Stat(output.PosterJPEG); err == nil && info.Size() > 0 {
+			break
+		}
+	}
+
+	img, err := decodeImageFile(ctx, output.PosterJPEG)
+	if err != nil {
+		return err
+	}
+	return encodeWebPFile(output.PosterWebP, img, 85)
+}
+
+// writeMaster writes the master playlist; ffmpeg's own depends on its version and does not
+// always list RESOLUTION, which players use to pick the first variant.
+func (o *HLSOutput) writeMaster() error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, variant := range o.Variants {
+		rel, err := filepath.Rel(filepath.Dir(o.Master), variant.Playlist)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=\"%s\"\n%s\n", variant.Bandwidth, variant.Width, variant.Height, variant.Name, filepath.ToSlash(rel))
+	}
+	return os.WriteFile(o.Master, []byte(b.String()), 0o644)
+}
+
+// playlistFiles returns the local files a playlist references, in playlist order.
+func playlistFiles(playlistPath string) ([]string, error) {
+	data, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		files = append(files, filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(line)))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("playlist %s is empty", filepath.Base(playlistPath))
+	}
+	return files, nil
+}
+
+// RewritePlaylist replaces every URI of a playlist with the one uris maps its local file to,
+// e.g. the CID it was pinned under. A bare CID resolves against a gateway URL of the playlist
+// itself (https://gateway/ipfs/<playlist cid>), so no gateway is baked into the file.
+func RewritePlaylist(playlistPath string, uris map[string]string) error {
+	data, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		uri, ok := uris[filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(line))]
+		if !ok {
+			return fmt.Errorf("playlist %s: no replacement for %s", filepath.Base(playlistPath), line)
+		}
+		lines[i] = uri
+	}
+	return os.WriteFile(playlistPath, []byte(strings.Join(lines, "\n")), 0o644)
+}
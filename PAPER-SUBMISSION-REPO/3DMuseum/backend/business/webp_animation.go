@@ -0,0 +1,185 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+)
+
+// VP8X feature flags
+const (
+	webpFlagAnimation = 0x02
+	webpFlagAlpha     = 0x10
+)
+
+// animatedWebP muxes separately encoded frames into an animated WebP. Every frame only covers
+// the part of the canvas that changed since the previous one and replaces it without blending,
+// so frames can be encoded in any mode, including lossy with alpha.
+type animatedWebP struct {
+	mode     string
+	quality  float32
+	previous *image.NRGBA
+	frames   []webpAnimationFrame
+	alpha    bool
+}
+
+type webpAnimationFrame struct {
+	rect     image.Rectangle
+	duration int
+	chunks   []byte // ALPH and VP8, or VP8L, as the single image encoder wrote them
+}
+
+// encodeAnimatedWebPFile converts the GIF or APNG at inputPath into an animated WebP.
+func encodeAnimatedWebPFile(ctx context.Context, inputPath string, outputFile string, mode string, quality float32) error {
+	animation := &animatedWebP{mode: mode, quality: quality}
+	loopCount, err := decodeAnimation(inputPath, func(canvas *image.NRGBA, duration int) error {
+		// Every frame is a full encoder run, check in between
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return animation.addFrame(canvas, duration)
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFile, animation.bytes(loopCount), 0o644)
+}
+
+func (a *animatedWebP) addFrame(canvas *image.NRGBA, duration int) error {
+	rect := canvas.Bounds()
+	if a.previous == nil {
+		a.previous = cloneNRGBA(canvas)
+	} else {
+		rect = changedRect(a.previous, canvas)
+		if rect.Empty() {
+			// Nothing moved, show the previous frame for longer
+			last := &a.frames[len(a.frames)-1]
+			last.duration = min(last.duration+duration, 1<<24-1)
+			return nil
+		}
+		// Frame offsets are stored halved
+		rect.Min.X &^= 1
+		rect.Min.Y &^= 1
+		copy(a.previous.Pix, canvas.Pix)
+	}
+
+	frame := canvas.SubImage(rect).(*image.NRGBA)
+	if !frame.Opaque() {
+		a.alpha = true
+	}
+	encoded, err := encodeWebPImage(frame, a.mode, a.quality)
+	if err != nil {
+		return err
+	}
+	chunks, err := webpImageChunks(encoded)
+	if err != nil {
+		return err
+	}
+	a.frames = append(a.frames, webpAnimationFrame{rect: rect, duration: duration, chunks: chunks})
+	return nil
+}
+
+// changedRect is the bounding box of the pixels that differ between two canvases.
+func changedRect(previous, current *image.NRGBA) image.Rectangle {
+	bounds := current.Bounds()
+	changed := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := current.PixOffset(bounds.Min.X, y)
+		a := previous.Pix[row : row+bounds.Dx()*4]
+		b := current.Pix[row : row+bounds.Dx()*4]
+		if bytes.Equal(a, b) {
+			continue
+		}
+		first := 0
+		for first < len(a) && a[first] == b[first] {
+			first++
+		}
+		last := len(a) - 1
+		for last > first && a[last] == b[last] {
+			last--
+		}
+		changed = changed.Union(image.Rect(bounds.Min.X+first/4, y, bounds.Min.X+last/4+1, y+1))
+	}
+	return changed
+}
+
+// webpImageChunks returns the image data chunks of a single image WebP file.
+func webpImageChunks(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("encoder did not produce a WebP file")
+	}
+	var chunks []byte
+	for offset := 12; offset+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		end := offset + 8 + size + size&1
+		if end > len(data) {
+			end = len(data)
+		}
+		switch string(data[offset : offset+4]) {
+		case "ALPH", "VP8 ", "VP8L":
+			chunks = append(chunks, data[offset:end]...)
+		}
+		offset = end
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("encoder output has no image data")
+	}
+	return chunks, nil
+}
+
+// bytes writes the RIFF container: VP8X, ANIM with a transparent background, one ANMF per frame.
+func (a *animatedWebP) bytes(loopCount int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	width, height := a.previous.Bounds().Dx(), a.previous.Bounds().Dy()
+	header := make([]byte, 10)
+	header[0] = webpFlagAnimation
+	if a.alpha {
+		header[0] |= webpFlagAlpha
+	}
+	putUint24(header[4:], width-1)
+	putUint24(header[7:], height-1)
+	writeRIFFChunk(&body, "VP8X", header)
+
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(min(loopCount, 0xFFFF)))
+	writeRIFFChunk(&body, "ANIM", anim)
+
+	for _, frame := range a.frames {
+		payload := make([]byte, 16, 16+len(frame.chunks))
+		putUint24(payload[0:], frame.rect.Min.X/2)
+		putUint24(payload[3:], frame.rect.Min.Y/2)
+		putUint24(payload[6:], frame.rect.Dx()-1)
+		putUint24(payload[9:], frame.rect.Dy()-1)
+		putUint24(payload[12:], frame.duration)
+		payload[15] = 0x02 // do not blend, do not dispose
+		writeRIFFChunk(&body, "ANMF", append(payload, frame.chunks...))
+	}
+
+	out := make([]byte, 8, 8+body.Len())
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(body.Len()))
+	return append(out, body.Bytes()...)
+}
+
+func writeRIFFChunk(w *bytes.Buffer, fourCC string, data []byte) {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+	w.WriteString(fourCC)
+	w.Write(size[:])
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
@@ -1,36 +1,144 @@
 package business
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"os"
 	"path/filepath"
 	"strings"
-	_ "image/png"
-	_ "image/jpeg"
-	"github.com/chai2010/webp"
+
+	"golang.org/x/image/draw"
 )
 
-func ConvertToWebP(inputPath string) ([]byte, string, error) {
+// WebPRendition is one encoded rung of the WebP ladder.
+type WebPRendition struct {
+	Label    string
+	Width    int
+	Height   int
+	Path     string
+	Mode     string // lossy, lossless or near_lossless
+	Animated bool
+}
+
+// webpLadder lists the renditions produced for every image, smallest first. Edge is the
+// longest edge in pixels; 0 keeps the source size. Rungs as large as the source are skipped.
+var webpLadder = []struct {
+	Label   string
+	Edge    int
+	Quality float32
+}{
+	{"thumbnail", 256, 75},
+	{"512", 512, 80},
+	{"1024", 1024, 85},
+	{"2048", 2048, 85},
+	{"original", 0, 90},
+}
+
+// ConvertToWebPRenditions decodes inputPath once and encodes every rung of the WebP ladder
+// into outputDir. The "original" rendition is named after the input, <name>.webp. mode is
+// one of the WebPMode constants, "" or auto picks it from the image. Of an animated GIF or APNG
+// only the "original" rendition is animated, the smaller rungs show its first frame.
+func ConvertToWebPRenditions(ctx context.Context, inputPath string, outputDir string, mode string) ([]WebPRendition, error) {
+	base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+
+	var renditions []WebPRendition
+	err := Converters().Run(ctx, func(ctx context.Context) error {
+		img, err := decodeImageFile(ctx, inputPath)
+		if err != nil {
+			return err
+		}
+		bounds := img.Bounds()
+		longest := max(bounds.Dx(), bounds.Dy())
+		mode := resolveWebPMode(mode, img)
+		animated := IsAnimatedImage(inputPath)
+
+		for _, rung := range webpLadder {
+			if rung.Edge > 0 && rung.Edge >= longest {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rendition := WebPRendition{Label: rung.Label, Width: bounds.Dx(), Height: bounds.Dy(), Mode: mode}
+			scaled := img
+			if rung.Edge > 0 {
+				rendition.Width = max(1, bounds.Dx()*rung.Edge/longest)
+				rendition.Height = max(1, bounds.Dy()*rung.Edge/longest)
+				dst := image.NewNRGBA(image.Rect(0, 0, rendition.Width, rendition.Height))
+				draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+				scaled = dst
+				rendition.Path = filepath.Join(outputDir, base+"-"+rung.Label+".webp")
+			} else {
+				rendition.Path = filepath.Join(outputDir, base+".webp")
+			}
+
+			if rung.Edge == 0 && animated {
+				rendition.Animated = true
+				renditions = append(renditions, rendition)
+				if err := encodeAnimatedWebPFile(ctx, inputPath, rendition.Path, mode, rung.Quality); err != nil {
+					return err
+				}
+				continue
+			}
+			renditions = append(renditions, rendition)
+			if err := encodeWebPFileMode(rendition.Path, scaled, mode, rung.Quality); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, rendition := range renditions {
+			_ = os.Remove(rendition.Path)
+		}
+		return nil, err
+	}
+	return renditions, nil
+}
+
+// decodeImageFile decodes any supported image type: PNG, JPEG, GIF, WebP, TIFF and BMP directly,
+// HEIC through heif-convert, and SVG by rasterizing it.
+func decodeImageFile(ctx context.Context, inputPath string) (image.Image, error) {
+	switch format, err := sniffImageFile(inputPath); {
+	case err != nil:
+		return nil, err
+	case format == "ISO media":
+		return decodeHEIF(ctx, inputPath)
+	case format == "XML":
+		return rasterizeSVG(inputPath)
+	}
+
+	if _, _, err := CheckDecodeLimits(inputPath); err != nil {
+		return nil, err
+	}
 	file, err := os.Open(inputPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to open file: %v", err)
+		return nil, fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	img, format , err := image.Decode(file)
-	fmt.Println("DETECTED FORMAT: ", format)
+	img, _, err := image.Decode(file)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to decode: %v", err)
+		return nil, fmt.Errorf("failed to decode: %v", err)
 	}
+	return img, nil
+}
 
-	var buf bytes.Buffer
-	opt := &webp.Options{Quality: 90}
-	if err := webp.Encode(&buf, img, opt); err != nil {
-		return nil, "", fmt.Errorf("webp encode failed: %v", err)
-	}
+func encodeWebPFile(outputFile string, img image.Image, quality float32) error {
+	return encodeWebPFileMode(outputFile, img, WebPModeLossy, quality)
+}
 
-	newFile := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)) + ".webp"
-	return buf.Bytes(), newFile, nil
+func encodeWebPFileMode(outputFile string, img image.Image, mode string, quality float32) error {
+	data, err := encodeWebPImage(img, mode, quality)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, data, 0o644); err != nil {
+		return fmt.Errorf("failed to create webp file: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,151 @@
+package business
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+
+	"github.com/chai2010/webp"
+)
+
+var ErrUnknownWebPMode = errors.New("unknown webp mode")
+
+// WebP encoding modes, picked per image from its content unless the upload asks for one.
+const (
+	WebPModeAuto         = "auto"          // choose from the image (default)
+	WebPModeLossy        = "lossy"         // photographs
+	WebPModeLossless     = "lossless"      // transparency, cut-out edges stay clean
+	WebPModeNearLossless = "near_lossless" // line art and flat colour: lossless with smooth areas quantized
+)
+
+const (
+	// Pixels sampled to tell line art from photographs, on a regular grid over the image
+	webpAnalysisSamples = 1 << 18
+	// An image is line art when this many colours cover nearly all of it
+	lineArtPalette  = 256
+	lineArtCoverage = 0.9
+	// Low bits near-lossless may change in smooth areas, about libwebp's -near_lossless 60
+	nearLosslessBits = 2
+)
+
+// ValidateWebPMode accepts "", auto, lossy, lossless and near_lossless.
+func ValidateWebPMode(mode string) error {
+	switch mode {
+	case "", WebPModeAuto, WebPModeLossy, WebPModeLossless, WebPModeNearLossless:
+		return nil
+	}
+	return fmt.Errorf("%w %q, expected auto, lossy, lossless or near_lossless", ErrUnknownWebPMode, mode)
+}
+
+// resolveWebPMode returns mode, or the mode chooseWebPMode picks for img when it is "" or auto.
+func resolveWebPMode(mode string, img image.Image) string {
+	if mode == "" || mode == WebPModeAuto {
+		return chooseWebPMode(toNRGBA(img, &NormalizationReport{}))
+	}
+	return mode
+}
+
+// chooseWebPMode looks at the pixels: any transparency needs lossless, lossy would blur the
+// alpha edge; a few colours covering almost the whole image is line art or flat artwork,
+// where lossy rings around every stroke; everything else is a photograph.
+func chooseWebPMode(img *image.NRGBA) string {
+	if !img.Opaque() {
+		return WebPModeLossless
+	}
+
+	bounds := img.Bounds()
+	step := max(1, int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/webpAnalysisSamples)))
+	// Bounded, a photograph would otherwise put most of its samples in here
+	counts := make(map[uint32]int, 4096)
+	samples := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			samples++
+			i := img.PixOffset(x, y)
+			key := uint32(img.Pix[i])<<16 | uint32(img.Pix[i+1])<<8 | uint32(img.Pix[i+2])
+			if _, ok := counts[key]; ok || len(counts) < 4096 {
+				counts[key]++
+			}
+		}
+	}
+
+	frequencies := make([]int, 0, len(counts))
+	for _, count := range counts {
+		frequencies = append(frequencies, count)
+	}
+	slices.Sort(frequencies)
+	slices.Reverse(frequencies)
+	covered := 0
+	for _, count := range frequencies[:min(len(frequencies), lineArtPalette)] {
+		covered += count
+	}
+	if float64(covered) >= lineArtCoverage*float64(samples) {
+		return WebPModeNearLossless
+	}
+	return WebPModeLossy
+}
+
+// encodeWebPImage encodes img as a single WebP image in the given (resolved) mode. quality only
+// applies to lossy.
+func encodeWebPImage(img image.Image, mode string, quality float32) ([]byte, error) {
+	nrgba := toNRGBA(img, &NormalizationReport{})
+	opt := &webp.Options{Quality: quality}
+	switch mode {
+	case WebPModeLossless:
+		opt = &webp.Options{Lossless: true}
+	case WebPModeNearLossless:
+		nrgba = nearLossless(nrgba, nearLosslessBits)
+		opt = &webp.Options{Lossless: true}
+	}
+
+	// libwebp wants straight alpha, but the wrapper premultiplies everything that is not an
+	// *image.RGBA, which darkens every semi-transparent edge. Hand it the NRGBA bytes as is.
+	straight := &image.RGBA{Pix: nrgba.Pix, Stride: nrgba.Stride, Rect: nrgba.Rect}
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, straight, opt); err != nil {
+		return nil, fmt.Errorf("webp encode failed: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// nearLossless rounds the colour of pixels in smooth areas to a multiple of 2^bits, which
+// lossless WebP then stores in fewer bits. Edges, flat areas and alpha are left exact, so
+// strokes stay crisp and a white background stays white.
+func nearLossless(img *image.NRGBA, bits int) *image.NRGBA {
+	limit := 1 << bits
+	out := image.NewNRGBA(img.Rect)
+	copy(out.Pix, img.Pix)
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			smooth, flat := true, true
+			for _, n := range [4]image.Point{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if !n.In(bounds) {
+					continue
+				}
+				j := img.PixOffset(n.X, n.Y)
+				for c := 0; c < 4; c++ {
+					diff := int(img.Pix[i+c]) - int(img.Pix[j+c])
+					if diff != 0 {
+						flat = false
+					}
+					if diff >= limit || diff <= -limit {
+						smooth = false
+					}
+				}
+			}
+			if !smooth || flat {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				out.Pix[i+c] = uint8(min(int(img.Pix[i+c])+limit/2, 255) &^ (limit - 1))
+			}
+		}
+	}
+	return out
+}
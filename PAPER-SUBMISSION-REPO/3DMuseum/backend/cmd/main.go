@@ -9,6 +9,7 @@ import (
 	"main/websocket"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 
@@ -49,9 +50,12 @@ func main() {
 	CORS.AllowOrigins = []string{FRONTEND_URL}
 
 	// ALLOW COMMONS HEADER
-	CORS.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "User-Agent", "Cache-Control", "Pragma"}
-	// Allow common methods (GET, POST, PUT, DELETE, PATCH, OPTIONS)
-	CORS.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	CORS.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "User-Agent", "Cache-Control", "Pragma",
+		"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"}
+	// Resumable upload clients need to read these back from cross-origin responses
+	CORS.ExposeHeaders = []string{"Location", "Tus-Resumable", "Upload-Offset", "Upload-Length", "Upload-Expires", "Upload-Job-ID"}
+	// Allow common methods (GET, HEAD, POST, PUT, DELETE, PATCH, OPTIONS)
+	CORS.AllowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
 	// If you use cookies or authorization headers that require credentials
 	CORS.AllowCredentials = true
 
@@ -62,9 +66,26 @@ func main() {
 	fmt.Println("PINATA_JWT: ", pinataJWT)
 	fmt.Println("PINATA_GATEWAY_URL: ", pinataGatewayURL)
 
-	PinataService := business.NewPinataService(pinataJWT, pinataGatewayURL)
+	// CIDv0 unless PINATA_CID_VERSION=1, uploads are checked against the CID computed locally
+	pinataCIDVersion := 0
+	if v := os.Getenv("PINATA_CID_VERSION"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && (n == 0 || n == 1) {
+			pinataCIDVersion = n
+		} else {
+			log.Fatalf("Invalid PINATA_CID_VERSION %q, must be 0 or 1", v)
+		}
+	}
+
+	PinataService := business.NewPinataService(pinataJWT, pinataGatewayURL, pinataCIDVersion)
+
+	// STORAGE_DRIVER=local keeps everything on disk, for running without a Pinata account
+	Storage, err := business.NewStorageFromEnv(PinataService)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	fmt.Println("STORAGE_DRIVER: ", Storage.Name())
 
-	api.RegisterRoutes(router, db, PinataService, SFU)
+	api.RegisterRoutes(router, db, Storage, SFU)
 
 	go func() {
 		if err := router.Run(":3001"); err != nil {
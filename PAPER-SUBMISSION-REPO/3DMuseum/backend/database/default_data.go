@@ -5,7 +5,7 @@ import (
 )
 
 var Categories = []model.Category{
-	{Category: "Image"},
+	{Category: "Image", DefaultEncodingProfile: "artwork-standard"},
 	{Category: "Video"},
 	{Category: "Model"},
 	{Category: "Audio"},
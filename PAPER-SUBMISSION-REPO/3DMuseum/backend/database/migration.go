@@ -15,6 +15,10 @@ func Migrate(db *gorm.DB) error {
 		&model.Category{}, 
 		&model.Asset{},
 		&model.Audio{},    
+		&model.Job{},
+		&model.AssetRendition{},
+		&model.Blob{},
+		&model.RetentionAudit{},
 	}
 
 	for _, m := range modelsToMigrate {
@@ -24,6 +28,13 @@ func Migrate(db *gorm.DB) error {
 			return logFatalErrorf("FAILED TO AUTO-MIGRATE DATABASE SCHEMA for %T: %v", m, err)
 		}
 	}
+
+	// The (room, mesh, version) index became unique under a new name, the CIDs are no longer unique
+	if db.Migrator().HasIndex(&model.Asset{}, "idx_assets_room_mesh_version") {
+		if err := db.Migrator().DropIndex(&model.Asset{}, "idx_assets_room_mesh_version"); err != nil {
+			return logFatalErrorf("FAILED TO DROP INDEX idx_assets_room_mesh_version: %v", err)
+		}
+	}
 	
 	// 2. Seed initial Category data
 	log.Println("Seeding initial category data...")
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// Blob ( SHA256 , Driver , CID , Size , Mime , Timestamps )
+// Content-hash index of every stored original and rendition, so identical files are stored once.
+type Blob struct {
+	SHA256    string    `gorm:"column:sha256;type:char(64);primaryKey" json:"sha256"`
+	Driver    string    `gorm:"type:varchar(20);primaryKey" json:"driver"` // IDs of one storage driver mean nothing to another
+	CID       string    `gorm:"column:cid;type:varchar(255);not null;index" json:"cid"`
+	Size      int64     `json:"size"`
+	Mime      string    `gorm:"type:varchar(100)" json:"mime"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	// Set by every upload that stores or reuses the blob, retention keeps blobs an upload that has
+	// not saved its asset yet may still point at
+	LastUsedAt time.Time `gorm:"index" json:"last_used_at"`
+}
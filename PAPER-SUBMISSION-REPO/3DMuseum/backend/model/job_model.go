@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// Upload job states, in pipeline order
+const (
+	JobQueued     = "queued"
+	JobConverting = "converting"
+	JobPinning    = "pinning"
+	JobPersisting = "persisting"
+	JobDone       = "done"
+	JobFailed     = "failed"
+)
+
+// Job ( JobID , RoomID , MeshName , Filename , Status , Error , ErrorCode , Asset_CID , Timestamps )
+type Job struct {
+	JobID      string     `gorm:"column:job_id;type:varchar(64);primaryKey" json:"job_id"`
+	RoomID     uint       `gorm:"not null;index" json:"room_id"`
+	MeshName   string     `gorm:"type:varchar(255)" json:"mesh_name"`
+	Filename   string     `gorm:"type:varchar(255)" json:"filename"`
+	Status     string     `gorm:"type:varchar(20);default:'queued';index" json:"status"`
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	ErrorCode  string     `gorm:"type:varchar(50)" json:"code,omitempty"` // see business.ErrorCode
+	AssetCID   string     `gorm:"column:asset_cid;type:varchar(255)" json:"asset_cid,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -6,39 +6,57 @@ import (
 
 // Room ( RID , RoomName )
 type Room struct {
-	RID      uint    `gorm:"column:room_id;primaryKey;autoIncrement" json:"rid"`
-	RoomName string  `gorm:"type:varchar(255);unique;not null" json:"room_name"`
-	Assets   []Asset `gorm:"foreignKey:RoomID"` // One-to-Many: Room → Assets
+	RID                    uint    `gorm:"column:room_id;primaryKey;autoIncrement" json:"rid"`
+	RoomName               string  `gorm:"type:varchar(255);unique;not null" json:"room_name"`
+	DefaultEncodingProfile string  `gorm:"type:varchar(50)" json:"default_encoding_profile"` // KTX2 profile for uploads in this room
+	RetainVersions         *int    `json:"retain_versions"`                                  // superseded versions kept per mesh, nil when not part of the policy
+	RetainDays             *int    `json:"retain_days"`                                      // days a superseded version is kept, nil when not part of the policy
+	Assets                 []Asset `gorm:"foreignKey:RoomID"`                                // One-to-Many: Room → Assets
 }
 
 // Category ( CID , Category )
 type Category struct {
-	CID      uint    `gorm:"column:category_id;primaryKey;autoIncrement" json:"cid"`
-	Category string  `gorm:"column:category;type:varchar(50);unique;not null" json:"category"`
-	Assets   []Asset `gorm:"foreignKey:CategoryID"` // One-to-Many: Category → Assets
+	CID                    uint    `gorm:"column:category_id;primaryKey;autoIncrement" json:"cid"`
+	Category               string  `gorm:"column:category;type:varchar(50);unique;not null" json:"category"`
+	DefaultEncodingProfile string  `gorm:"type:varchar(50)" json:"default_encoding_profile"` // used when the room has none
+	Assets                 []Asset `gorm:"foreignKey:CategoryID"`                            // One-to-Many: Category → Assets
 }
 
 // Asset ( AID , Asset_CID , AssetMeshName ,  AssetName , Title, Descriptions, Timestamps, Foreign Keys )
 type Asset struct {
 	AID      uint   `gorm:"column:asset_id;primaryKey;autoIncrement" json:"aid"`
-	AssetCID string `gorm:"column:asset_cid;type:varchar(255);unique;not null" json:"asset_cid"`
-	WebpCID  string `gorm:"column:webp_cid;type:varchar(255);unique" json:"webp_cid"` // fallback webp image
+	AssetCID string `gorm:"column:asset_cid;type:varchar(255);not null;index" json:"asset_cid"` // meshes uploaded from identical files share it
+	WebpCID  string `gorm:"column:webp_cid;type:varchar(255);index" json:"webp_cid"`            // fallback webp image
 
-	AssetMeshName         string `gorm:"type:varchar(255);index:idx_assets_room_mesh_version,priority:2" json:"asset_mesh_name"`
+	AssetMeshName         string `gorm:"type:varchar(255);uniqueIndex:uniq_assets_room_mesh_version,priority:2" json:"asset_mesh_name"`
 	AssetName             string `gorm:"type:varchar(255);not null" json:"asset_name"`
 	Title                 string `gorm:"type:varchar(255)" json:"title"`
 	VietnameseDescription string `gorm:"type:text" json:"vietnamese_description"`
 	EnglishDescription    string `gorm:"type:text" json:"english_description"`
+	EncodingProfile       string `gorm:"type:varchar(50)" json:"encoding_profile"` // KTX2 profile the asset was encoded with
+	Normalization         string `gorm:"type:text" json:"normalization,omitempty"`  // JSON report of the pre-conversion image normalization
+
+	// Videos only: the HLS master playlist and what ffprobe reported about the upload
+	HLSCID   string  `gorm:"column:hls_cid;type:varchar(255)" json:"hls_cid,omitempty"`
+	Duration float64 `gorm:"column:duration_seconds" json:"duration_seconds,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+
+	// SHA-256 of the upload and the options it was converted with, identical re-uploads reuse this row
+	SourceKey string `gorm:"type:char(64);index" json:"-"`
+
+	// Images uploaded with deep_zoom only
+	TileManifest *TileManifest `gorm:"type:text;serializer:json" json:"tile_manifest,omitempty"`
 
 	// Foreign Key to Room (One-to-Many)
-	RoomID uint `gorm:"not null;index:idx_assets_room_mesh_version,priority:1" json:"room_id"`
+	RoomID uint `gorm:"not null;uniqueIndex:uniq_assets_room_mesh_version,priority:1" json:"room_id"`
 	Room   Room `gorm:"foreignKey:RoomID"`
 
 	// Foreign Key to Category (One-to-Many)
 	CategoryID uint     `gorm:"not null;index" json:"category_id"`
 	Category   Category `gorm:"foreignKey:CategoryID"`
 	Filesize   int64
-	Version    int       `gorm:"default:1;index:idx_assets_room_mesh_version,priority:3,sort:desc" json:"version"`
+	Version    int       `gorm:"default:1;uniqueIndex:uniq_assets_room_mesh_version,priority:3,sort:desc" json:"version"`
 	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
@@ -4,8 +4,15 @@ type AssetStruct struct {
 	Filename string
 	IpfsHash string // Asset_CID
 	CategoryID int
+
+	// CID computed from the uploaded bytes, and whether the storage returned a different one
+	LocalCID    string
+	CIDMismatch bool
 }
 
 type AudioStruct struct{
 	IpfsHash string `json:"IPFSHash"` // AudioCID
-}
\ No newline at end of file
+
+	LocalCID    string `json:"-"`
+	CIDMismatch bool   `json:"-"`
+}
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// Rendition kinds
+const (
+	RenditionWebP    = "webp"
+	RenditionLOD     = "lod"
+	RenditionHLS     = "hls"         // one variant playlist of a video, its CID is the rewritten playlist
+	RenditionSegment = "hls_segment" // one segment of a variant, labelled "<variant>/<file>"; kept for retention, not listed
+	RenditionPoster  = "poster"      // video poster frame, "jpeg" and "webp"
+)
+
+// BoundingBox is an axis-aligned box in model space.
+type BoundingBox struct {
+	Min [3]float64 `json:"min"`
+	Max [3]float64 `json:"max"`
+}
+
+// AssetRendition ( RenditionID , Asset_CID , Kind , Label , Size , CID , Timestamps )
+// One pinned variant of an asset, e.g. the 1024px WebP of a painting.
+type AssetRendition struct {
+	RenditionID uint      `gorm:"column:rendition_id;primaryKey;autoIncrement" json:"-"`
+	AssetCID    string    `gorm:"column:asset_cid;type:varchar(255);not null;uniqueIndex:idx_renditions_asset_kind_label,priority:1" json:"-"`
+	Kind        string    `gorm:"type:varchar(20);not null;uniqueIndex:idx_renditions_asset_kind_label,priority:2" json:"kind"`
+	Label       string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_renditions_asset_kind_label,priority:3" json:"label"` // "thumbnail", "512", ... "original" for WebP, "lod0", "lod1", ... for models, "720p", ... for HLS
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	CID         string    `gorm:"column:cid;type:varchar(255);not null" json:"cid"`
+	Filesize    int64     `json:"filesize"`
+	Mime        string    `gorm:"type:varchar(100)" json:"mime"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"-"`
+
+	// WebP only
+	WebPMode string `gorm:"column:webp_mode;type:varchar(20)" json:"webp_mode,omitempty"` // lossy, lossless or near_lossless
+	Animated bool   `json:"animated,omitempty"`
+
+	// Model LODs only
+	TriangleCount int          `json:"triangle_count,omitempty"`
+	BoundingBox   *BoundingBox `gorm:"type:text;serializer:json" json:"bounding_box,omitempty"`
+}
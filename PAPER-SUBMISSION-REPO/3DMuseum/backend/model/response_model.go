@@ -9,4 +9,13 @@ type ResponseMetadataInfor struct {
 	EnglishDescription    string `json:"en_des" gorm:"column:english_description"`
 	VietAudioCID          string `json:"viet_audio_cid" gorm:"column:viet_audio_cid"`
 	EngAudioCID           string `json:"eng_audio_cid" gorm:"column:eng_audio_cid"`
+	// Videos only
+	HLSCID   string  `json:"hls_cid,omitempty" gorm:"column:hls_cid"`
+	Duration float64 `json:"duration_seconds,omitempty" gorm:"column:duration_seconds"`
+	Width    int     `json:"width,omitempty" gorm:"column:width"`
+	Height   int     `json:"height,omitempty" gorm:"column:height"`
+	// Images uploaded with deep_zoom only
+	TileManifest *TileManifest `json:"tile_manifest,omitempty" gorm:"column:tile_manifest;serializer:json"`
+	// Renditions is loaded separately, smallest first, so the client can pick one by distance or device
+	Renditions []AssetRendition `json:"renditions" gorm:"-"`
 }
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// Retention audit actions
+const (
+	RetentionUnpinned = "unpinned"
+	RetentionFailed   = "failed"
+)
+
+// RetentionAudit ( AuditID , RunID , RoomID , AssetID , CID , Kind , Action , Error , Timestamps )
+// One CID a retention run removed from storage, or failed to.
+type RetentionAudit struct {
+	AuditID   uint      `gorm:"column:audit_id;primaryKey;autoIncrement" json:"audit_id"`
+	RunID     string    `gorm:"type:varchar(64);not null;index" json:"run_id"`
+	RoomID    uint      `gorm:"not null;index" json:"room_id"`
+	AssetID   uint      `json:"asset_id"` // superseded version the CID belonged to
+	MeshName  string    `gorm:"type:varchar(255)" json:"mesh_name"`
+	Version   int       `json:"version"`
+	CID       string    `gorm:"column:cid;type:varchar(255);not null;index" json:"cid"`
+	Kind      string    `gorm:"type:varchar(20)" json:"kind"` // asset, webp, hls, tiles, audio or a rendition kind
+	Driver    string    `gorm:"type:varchar(20)" json:"driver"`
+	Action    string    `gorm:"type:varchar(20);not null" json:"action"`
+	Error     string    `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package model
+
+// TileManifest describes the deep-zoom pyramid of an image, pinned as one DZI directory.
+// A tile lives at <cid>/<tile_path> with {level}, {x} and {y} filled in; level max_level is
+// the full-size image and every level below halves it.
+type TileManifest struct {
+	CID        string `json:"cid"`
+	Descriptor string `json:"descriptor"` // path of the .dzi inside the directory, for OpenSeadragon & co.
+	TilePath   string `json:"tile_path"`
+	Format     string `json:"format"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	TileSize   int    `json:"tile_size"`
+	Overlap    int    `json:"overlap"`
+	MaxLevel   int    `json:"max_level"`
+	Tiles      int    `json:"tiles"`
+}
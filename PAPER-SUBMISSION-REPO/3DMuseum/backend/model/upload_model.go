@@ -1,11 +1,24 @@
 package model
 
 type DetailUploadInfor struct {
-	Filename              string `form:"-"` // Populated from file metadata, not a form field
-	MeshName              string `form:"mesh_name"`
-	Title                 string `form:"title"`
-	VietnameseDescription string `form:"vietnamese_description"`
-	EnglishDescription    string `form:"english_description"`
-	RoomID                int    `form:"roomID"`
-	FileBuffer            []byte `form:"-"` // Populated from file content, not a form field
-}
\ No newline at end of file
+	Filename              string        `form:"-"` // Populated from file metadata, not a form field
+	MeshName              string        `form:"mesh_name"`
+	Title                 string        `form:"title"`
+	VietnameseDescription string        `form:"vietnamese_description"`
+	EnglishDescription    string        `form:"english_description"`
+	RoomID                int           `form:"roomID"`
+	EncodingProfile       string        `form:"encoding_profile"` // Optional KTX2 profile, defaults per room or category
+	NormalizeMode         string        `form:"normalize_mode"`   // Optional image block-size handling: pad4 (default), pot or none
+	DeepZoom              bool          `form:"deep_zoom"`        // Optional: also cut the image into a deep-zoom tile pyramid
+	WebPMode              string        `form:"webp_mode"`        // Optional WebP encoding: auto (default), lossy, lossless or near_lossless
+	Normalization         string        `form:"-"`                // JSON normalization report, filled in by the upload pipeline
+	HLSCID                string        `form:"-"`                // Master playlist of a transcoded video, filled in by the upload pipeline
+	Duration              float64       `form:"-"`                // Video duration in seconds
+	Width                 int           `form:"-"`                // Video display width, rotation applied
+	Height                int           `form:"-"`                // Video display height, rotation applied
+	TileManifest          *TileManifest `form:"-"`                // Deep-zoom pyramid, filled in by the upload pipeline
+	SourceKey             string        `form:"-"`                // Hash of the upload and its conversion options, filled in by the upload pipeline
+	FilePath              string        `form:"-"`                // Spooled upload inside the request workspace, not a form field
+	Filesize              int64         `form:"-"`                // Size of the spooled upload in bytes
+	JobID                 string        `form:"-"`                // Set when the upload runs as a background job
+}
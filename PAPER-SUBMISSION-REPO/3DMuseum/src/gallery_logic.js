@@ -1,6 +1,7 @@
 // gallery_logic.js
 import galleryTemplate from '../template/gallery_template.js';
 import navigationPage from '../template/navigation_page_template.js';
+import { MediaURL } from './game_logic/services.js';
 
 let currentRoom = 0;
 let currentPage = 1;
@@ -112,7 +113,7 @@ function renderGallery() {
     const item = document.createElement('div');
     item.className = 'asset-item border p-2 rounded shadow flex flex-col items-center';
     item.innerHTML = `
-      <img src="https://gateway.pinata.cloud/ipfs/${asset.asset_cid}" alt="${asset.title}" class="w-full h-40 object-cover rounded" />
+      <img src="${MediaURL(asset.asset_cid)}" alt="${asset.title}" class="w-full h-40 object-cover rounded" />
       <div class="text-sm mt-2 font-semibold text-center">${asset.title}</div>
     `;
     item.addEventListener('click', () => showAssetDetail(asset));
@@ -151,7 +152,7 @@ function showAssetDetail(asset) {
   modal.innerHTML = `
     <div class="bg-white p-6 rounded w-1/2 max-w-lg">
       <h2 class="text-xl font-bold mb-2">${asset.title}</h2>
-      <img src="https://gateway.pinata.cloud/ipfs/${asset.asset_cid}" class="w-full h-64 object-contain rounded mb-3" />
+      <img src="${MediaURL(asset.asset_cid)}" class="w-full h-64 object-contain rounded mb-3" />
       <p class="text-sm">${asset.viet_des || asset.en_des || 'No description available'}</p>
       <div class="text-right mt-4">
         <button class="px-4 py-2 bg-gray-300 rounded hover:bg-gray-400" id="close-modal">Close</button>
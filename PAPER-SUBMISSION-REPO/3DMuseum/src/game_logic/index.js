@@ -10,7 +10,7 @@ import FirstPersonPlayer from './control';
 import ThirdPersonPlayer from "./ThirdPersonPlayer.js";
 import AnnotationDiv from "./annotationDiv";
 import { displayUploadModal, initUploadModal , Mapping_PictureFrame_ImageMesh , DisplayImageOnDiv} from "./utils";
-import { GetRoomAsset } from "./services";
+import { GetRoomAsset, MediaURL } from "./services";
 import { Museum } from "./constants";
 import { Capsule, DRACOLoader} from "three/examples/jsm/Addons.js";
 import RaycasterManager from "./raycaster.js"
@@ -100,11 +100,6 @@ const bvhMeshList = [];          // meshes used for BVH raycasts (ground snap +
 const navInputMeshes = [];   // meshes we will pass to recast
 let  pictureFramesArray = [];
 
-// PINATA URL 
-const PINATA_URL = import.meta.env.MODE === "production"
-    ? import.meta.env.VITE_PINATA_PRIVATE_GATEWAY // Use VITE_ prefix
-    : import.meta.env.VITE_PINATA_PRIVATE_GATEWAY;     // Use VITE_ prefix
-
 
 // Container instance 
 let loadingManager = document.getElementById('loading-container');
@@ -218,7 +213,7 @@ export async function prefetchAudio(audioCID) {
   if (audioCache.has(audioCID) || audioRawCache.has(audioCID)) return; // already cached
 
   try {
-    const url = `https://${PINATA_URL}${audioCID}`;
+    const url = MediaURL(audioCID);
     const response = await fetch(url);
     if (!response.ok) throw new Error(`HTTP error ${response.status}`);
 
@@ -1157,7 +1152,7 @@ async function loadModel() {
             if (annotationMesh[asset_mesh_name]) {
                 annotationMesh[asset_mesh_name].annotationDiv.setAnnotationDetails(title, viet_des, en_des , viet_audio_cid , eng_audio_cid);
 
-                setImageToMeshKTX2(currentScene, asset_mesh_name, `https://${PINATA_URL}${asset_cid}`);
+                setImageToMeshKTX2(currentScene, asset_mesh_name, MediaURL(asset_cid));
             }
         });
 
@@ -4,6 +4,11 @@ const BACKEND_URL =
     ? import.meta.env.VITE_PROD_BACKEND_URL // Use VITE_ prefix
     : import.meta.env.VITE_BACKEND_URL;     // Use VITE_ prefix
 
+// URL OF A STORED FILE, PROXIED BY THE BACKEND FROM WHEREVER IT IS STORED
+export function MediaURL(cid) {
+    return `${BACKEND_URL}/media/${cid}`
+}
+
 // API FETCH ALL INFORMATION FOR SPECIFIC ROOM
 export async function GetRoomAsset(roomID) {
     const url = `${BACKEND_URL}/list/${roomID}`